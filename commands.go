@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/Johnermac/http-server/internal/api"
+)
+
+// run-command
+// Runs a command-line subcommand and returns the exit code.
+func runCommand(cfg *api.APIConfig, name string, args []string) int {
+	switch name {
+	case "create-admin":
+		return createAdminCommand(cfg, args)
+	case "worker":
+		return workerCommand(cfg, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\ncommands:\n  create-admin -email <email>\n  worker [-concurrency n]\n", name)
+		return 2
+	}
+}
+
+// create-admin-command
+// Bootstraps the first admin. The password of a new user is read from stdin,
+// so it doesn't end up in the shell history:
+//
+//	echo "$ADMIN_PASSWORD" | chirpy create-admin -email admin@example.com
+func createAdminCommand(cfg *api.APIConfig, args []string) int {
+	fs := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	email := fs.String("email", "", "email of the admin; an existing user is promoted")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *email == "" {
+		fmt.Fprintln(os.Stderr, "create-admin: -email is required")
+		return 2
+	}
+
+	fmt.Fprint(os.Stderr, "Password (ignored for existing users): ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		fmt.Fprintln(os.Stderr, "\ncreate-admin: cannot read password:", err)
+		return 1
+	}
+	fmt.Fprintln(os.Stderr)
+
+	user, err := cfg.BootstrapAdmin(context.Background(), *email, strings.TrimRight(password, "\r\n"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "create-admin:", err)
+		return 1
+	}
+
+	fmt.Printf("%s (%s) is now an admin\n", user.Email, user.ID)
+	return 0
+}
+
+// worker-command
+// Runs background jobs and the outbox relay without serving HTTP, until
+// SIGINT or SIGTERM; then running jobs get to finish. Pair it with
+// JOB_WORKER_IN_SERVER=false to keep jobs off the API servers.
+func workerCommand(cfg *api.APIConfig, args []string) int {
+	fs := flag.NewFlagSet("worker", flag.ContinueOnError)
+	concurrency := fs.Int("concurrency", cfg.JobConcurrency, "jobs to run at once")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	cfg.JobConcurrency = *concurrency
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Fprintf(os.Stderr, "worker running %d jobs at a time\n", *concurrency)
+	if err := runWorker(ctx, cfg); err != nil {
+		fmt.Fprintln(os.Stderr, "worker:", err)
+		return 1
+	}
+	return 0
+}
+
+// run-worker
+// Runs background jobs and the outbox relay until ctx is done.
+func runWorker(ctx context.Context, cfg *api.APIConfig) error {
+	worker, err := cfg.NewWorker()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var relay sync.WaitGroup
+	relay.Add(1)
+	go func() {
+		defer relay.Done()
+		cfg.Outbox.Run(ctx)
+	}()
+
+	err = worker.Run(ctx)
+	cancel()
+	relay.Wait()
+	return err
+}
@@ -0,0 +1,159 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HTTP Signatures (draft-cavage-http-signatures-12) as used by Mastodon & co.
+
+const maxClockSkew = 5 * time.Minute
+
+// digest
+func digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// signing-string
+func signingString(r *http.Request, headers []string) (string, error) {
+	lines := make([]string, 0, len(headers))
+	for _, h := range headers {
+		var value string
+		switch h {
+		case "(request-target)":
+			value = strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "host":
+			value = r.Host
+			if value == "" {
+				value = r.URL.Host
+			}
+		default:
+			value = r.Header.Get(h)
+			if value == "" {
+				return "", fmt.Errorf("missing signed header %q", h)
+			}
+		}
+		lines = append(lines, h+": "+value)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// sign-request
+func SignRequest(r *http.Request, keyID string, key *rsa.PrivateKey, body []byte) error {
+	if r.Header.Get("Date") == "" {
+		r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	if r.Host == "" {
+		r.Host = r.URL.Host
+	}
+
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		r.Header.Set("Digest", digest(body))
+		headers = append(headers, "digest")
+	}
+
+	toSign, err := signingString(r, headers)
+	if err != nil {
+		return err
+	}
+
+	hashed := sha256.Sum256([]byte(toSign))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+// parse-signature-header
+func parseSignatureHeader(header string) map[string]string {
+	params := map[string]string{}
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		params[k] = strings.Trim(v, `"`)
+	}
+	return params
+}
+
+// signature-key-id
+func SignatureKeyID(r *http.Request) (string, error) {
+	header := r.Header.Get("Signature")
+	if header == "" {
+		return "", errors.New("missing signature header")
+	}
+	keyID := parseSignatureHeader(header)["keyId"]
+	if keyID == "" {
+		return "", errors.New("signature has no keyId")
+	}
+	return keyID, nil
+}
+
+// verify-request
+func VerifyRequest(r *http.Request, body []byte, key *rsa.PublicKey) error {
+	params := parseSignatureHeader(r.Header.Get("Signature"))
+	if params["signature"] == "" {
+		return errors.New("missing signature")
+	}
+	if alg := params["algorithm"]; alg != "" && alg != "rsa-sha256" && alg != "hs2019" {
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+
+	headers := strings.Fields(params["headers"])
+	if len(headers) == 0 {
+		headers = []string{"date"}
+	}
+	required := []string{"(request-target)", "host", "date"}
+	if len(body) > 0 {
+		required = append(required, "digest")
+	}
+	for _, h := range required {
+		if !slices.Contains(headers, h) {
+			return fmt.Errorf("header %q must be signed", h)
+		}
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return errors.New("invalid date header")
+	}
+	if skew := time.Since(date); skew > maxClockSkew || skew < -maxClockSkew {
+		return errors.New("date header outside of allowed window")
+	}
+
+	if len(body) > 0 {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Digest")), []byte(digest(body))) != 1 {
+			return errors.New("digest mismatch")
+		}
+	}
+
+	toVerify, err := signingString(r, headers)
+	if err != nil {
+		return err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+
+	hashed := sha256.Sum256([]byte(toVerify))
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig)
+}
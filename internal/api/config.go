@@ -1,24 +1,91 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"log"
+	"log/slog"
+	"math"
+	"net/http"
 	"os"
-	"sync/atomic"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/billing"
 	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/entitlements"
+	"github.com/Johnermac/http-server/internal/jobs"
+	"github.com/Johnermac/http-server/internal/mailer"
+	"github.com/Johnermac/http-server/internal/oidc"
+	"github.com/Johnermac/http-server/internal/outbox"
+	"github.com/Johnermac/http-server/internal/passwordpolicy"
+	"github.com/Johnermac/http-server/internal/ratelimit"
+	"github.com/Johnermac/http-server/internal/tracing"
+	"github.com/Johnermac/http-server/internal/webhook"
 	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type APIConfig struct {
-	FileserverHits atomic.Int32
 	DB             *database.Queries
+	SQLDB          *sql.DB // for transactions; queries go through DB
 	Platform       string
 	JWTSecret      string
-	Polka_KEY      string
+	Keyset         *auth.Keyset
+	Passwords      *auth.Passwords
+	PasswordPolicy passwordpolicy.Policy
+	BaseURL        string
+	Mailer         mailer.Mailer
+
+	// one JSON line per request, see MiddlewareLogging
+	Logger *slog.Logger
+	// continue the trace a request's traceparent names; only behind a proxy
+	// that sets or strips the header, see MiddlewareTracing
+	TrustTraceparent bool
+
+	// Prometheus metrics; METRICS_TOKEN guards the endpoint when set
+	Metrics      *AppMetrics
+	MetricsToken string
+
+	// signed Polka webhooks
+	PolkaWebhooks *webhook.Verifier
+	// subscription periods the provider doesn't send
+	Billing billing.Policy
+	// what each plan unlocks
+	Entitlements *entitlements.Engine
+
+	// outbound webhooks; private URLs are only allowed for local development
+	WebhookClient        *http.Client
+	AllowPrivateWebhooks bool
+
+	// ActivityPub fetches and deliveries, with the same private address guard
+	FederationClient *http.Client
+
+	// domain events, published by the relay where jobs run
+	Outbox *outbox.Relay
+
+	// background jobs; the server runs a worker too unless
+	// JOB_WORKER_IN_SERVER is false and `chirpy worker` runs them
+	Jobs            *jobs.Client
+	JobConcurrency  int
+	RunJobsInServer bool
+
+	// external login providers, by name
+	OIDCProviders map[string]*oidc.Provider
+
+	// block chirp creation until the user's email is verified
+	RequireVerifiedEmail bool
+
+	PasswordResetByIP    *ratelimit.Limiter
+	PasswordResetByEmail *ratelimit.Limiter
+	MFAAttempts          *ratelimit.Limiter
+	LoginFailuresByIP    *ratelimit.Backoff
 }
 
-func newDB() *database.Queries {
+func newDB() *sql.DB {
 	godotenv.Load()
 
 	dbURL := os.Getenv("DB_URL")
@@ -28,14 +95,270 @@ func newDB() *database.Queries {
 		log.Fatal("cannot connect to db:", err)
 	}
 
-	return database.New(db)
+	return db
+}
+
+func newMailer() mailer.Mailer {
+	m, err := mailer.New(mailer.Config{
+		Driver:   os.Getenv("MAIL_DRIVER"),
+		From:     os.Getenv("MAIL_FROM"),
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		LogPath:  os.Getenv("MAIL_LOG_PATH"),
+	})
+	if err != nil {
+		log.Fatal("cannot configure mailer:", err)
+	}
+
+	return m
+}
+
+// JWT_SIGNING_KEYS switches access tokens to the configured keyset;
+// without it they are HS256 with JWT_SECRET, as before.
+func newKeyset() *auth.Keyset {
+	spec := os.Getenv("JWT_SIGNING_KEYS")
+	if spec == "" {
+		return auth.NewHMACKeyset(os.Getenv("JWT_SECRET"))
+	}
+
+	ks, err := auth.LoadKeyset(spec)
+	if err != nil {
+		log.Fatal("cannot load jwt signing keys:", err)
+	}
+
+	return ks.WithLegacySecret(os.Getenv("JWT_SECRET"))
+}
+
+// PASSWORD_HASHER picks the algorithm for new hashes, argon2id (default) or
+// bcrypt; hashes of the other kind are still accepted and upgraded on login.
+// PASSWORD_HASH_CONCURRENCY caps the hashes and checks running at once, one
+// per CPU by default.
+func newPasswords(m *AppMetrics) *auth.Passwords {
+	params := auth.DefaultArgon2idParams
+	params.Memory = uint32(envIntIn("ARGON2_MEMORY_KIB", int(params.Memory), 8, 4*1024*1024))
+	params.Iterations = uint32(envIntIn("ARGON2_ITERATIONS", int(params.Iterations), 1, 100))
+	params.Parallelism = uint8(envIntIn("ARGON2_PARALLELISM", int(params.Parallelism), 1, math.MaxUint8))
+	concurrency := envInt("PASSWORD_HASH_CONCURRENCY", runtime.GOMAXPROCS(0))
+
+	argon2id := timedHasher{
+		PasswordHasher: auth.Argon2idHasher{Params: params},
+		algorithm:      "argon2id",
+		durations:      m.passwordHashDuration,
+	}
+	bcryptHasher := timedHasher{
+		PasswordHasher: auth.BcryptHasher{Cost: envIntIn("BCRYPT_COST", bcrypt.DefaultCost, bcrypt.MinCost, bcrypt.MaxCost)},
+		algorithm:      "bcrypt",
+		durations:      m.passwordHashDuration,
+	}
+
+	switch os.Getenv("PASSWORD_HASHER") {
+	case "", "argon2id":
+		return auth.NewPasswords(argon2id, bcryptHasher).WithMaxConcurrent(concurrency)
+	case "bcrypt":
+		return auth.NewPasswords(bcryptHasher, argon2id).WithMaxConcurrent(concurrency)
+	}
+
+	log.Fatal("unknown PASSWORD_HASHER: ", os.Getenv("PASSWORD_HASHER"))
+	return nil
+}
+
+// PASSWORD_MIN_LENGTH and PASSWORD_MIN_ENTROPY_BITS set to 0 disable their
+// rule. BREACHED_PASSWORDS_DIR points at a local corpus of Pwned Passwords
+// range files; without it nothing rejects breached passwords, and the
+// built-in list of common ones only lowers the entropy estimate.
+func newPasswordPolicy() passwordpolicy.Policy {
+	policy := passwordpolicy.DefaultPolicy
+	policy.MinLength = envIntIn("PASSWORD_MIN_LENGTH", policy.MinLength, 0, math.MaxInt)
+	policy.MinEntropyBits = float64(envIntIn("PASSWORD_MIN_ENTROPY_BITS", int(policy.MinEntropyBits), 0, math.MaxInt))
+
+	if dir := os.Getenv("BREACHED_PASSWORDS_DIR"); dir != "" {
+		corpus, err := passwordpolicy.OpenCorpus(dir)
+		if err != nil {
+			log.Fatal("cannot open breached password corpus:", err)
+		}
+		policy.Breached = corpus
+	}
+
+	return policy
+}
+
+// POLKA_WEBHOOK_SECRET signs new webhooks; while rotating, the old secret
+// stays valid as POLKA_WEBHOOK_SECRET_PREVIOUS.
+func newPolkaVerifier() *webhook.Verifier {
+	v := &webhook.Verifier{
+		Tolerance: time.Duration(envInt("POLKA_WEBHOOK_TOLERANCE_SECONDS", int(webhook.DefaultTolerance.Seconds()))) * time.Second,
+	}
+	for _, name := range []string{"POLKA_WEBHOOK_SECRET", "POLKA_WEBHOOK_SECRET_PREVIOUS"} {
+		if secret := os.Getenv(name); secret != "" {
+			v.Secrets = append(v.Secrets, []byte(secret))
+		}
+	}
+	if len(v.Secrets) == 0 {
+		log.Print("POLKA_WEBHOOK_SECRET is not set, Polka webhooks will be rejected")
+	}
+	return v
+}
+
+// SUBSCRIPTION_PERIOD_DAYS is assumed when a billing event doesn't say when
+// the period ends; SUBSCRIPTION_GRACE_PERIOD_DAYS is how long a failed
+// payment or a late renewal keeps the plan.
+func newBillingPolicy() billing.Policy {
+	policy := billing.DefaultPolicy
+	day := 24 * time.Hour
+	policy.Period = time.Duration(envInt("SUBSCRIPTION_PERIOD_DAYS", int(policy.Period/day))) * day
+	policy.GracePeriod = time.Duration(envInt("SUBSCRIPTION_GRACE_PERIOD_DAYS", int(policy.GracePeriod/day))) * day
+	return policy
+}
+
+// ENTITLEMENTS_FILE replaces the built-in plans with a JSON file.
+func newEntitlements() *entitlements.Engine {
+	plans := entitlements.DefaultPlans
+	if path := os.Getenv("ENTITLEMENTS_FILE"); path != "" {
+		var err error
+		plans, err = entitlements.LoadPlans(path)
+		if err != nil {
+			log.Fatal("cannot load entitlements: ", err)
+		}
+	}
+	return entitlements.NewEngine(plans)
+}
+
+// LOG_LEVEL is debug, info (default), warn or error.
+func newLogger() *slog.Logger {
+	var level slog.Level
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			log.Fatal("invalid LOG_LEVEL: ", v)
+		}
+	}
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+}
+
+// env-int
+func envInt(name string, fallback int) int {
+	return envIntIn(name, fallback, 1, math.MaxInt)
+}
+
+// env-int-in
+// Values outside [lo, hi] are fatal, rather than wrapping around when the
+// caller narrows them.
+func envIntIn(name string, fallback, lo, hi int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < lo || n > hi {
+		log.Fatalf("%s must be an integer from %d to %d", name, lo, hi)
+	}
+	return n
+}
+
+// OIDC_PROVIDERS lists provider names; each one is configured with
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET and
+// optionally OIDC_<NAME>_SCOPES.
+func newOIDCProviders() map[string]*oidc.Provider {
+	providers := map[string]*oidc.Provider{}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		p, err := oidc.NewProvider(oidc.Config{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		})
+		if err != nil {
+			log.Fatalf("cannot configure oidc provider %q: %v", name, err)
+		}
+		providers[name] = p
+	}
+
+	return providers
 }
 
 func NewAPIConfig() *APIConfig {
-	return &APIConfig{
-		DB:        newDB(),
-		Platform:  os.Getenv("PLATFORM"),
-		JWTSecret: os.Getenv("JWT_SECRET"),
-		Polka_KEY: os.Getenv("POLKA_KEY"),
+	godotenv.Load()
+	allowPrivateWebhooks := os.Getenv("WEBHOOK_ALLOW_PRIVATE_URLS") == "true"
+	sqlDB := newDB()
+	db := database.New(tracing.WrapDB(sqlDB))
+	appMetrics := NewAppMetrics(sqlDB)
+
+	cfg := &APIConfig{
+		DB:                   db,
+		SQLDB:                sqlDB,
+		Platform:             os.Getenv("PLATFORM"),
+		JWTSecret:            os.Getenv("JWT_SECRET"),
+		Keyset:               newKeyset(),
+		Passwords:            newPasswords(appMetrics),
+		PasswordPolicy:       newPasswordPolicy(),
+		PolkaWebhooks:        newPolkaVerifier(),
+		Billing:              newBillingPolicy(),
+		Entitlements:         newEntitlements(),
+		WebhookClient:        webhook.NewClient(webhookDeliveryTimeout, allowPrivateWebhooks),
+		AllowPrivateWebhooks: allowPrivateWebhooks,
+		FederationClient:     webhook.NewClient(federationTimeout, allowPrivateWebhooks),
+		Outbox:               outbox.NewRelay(sqlDB, db),
+		Jobs:                 jobs.NewClient(db),
+		JobConcurrency:       envInt("JOB_WORKER_CONCURRENCY", jobs.DefaultConcurrency),
+		RunJobsInServer:      os.Getenv("JOB_WORKER_IN_SERVER") != "false",
+		BaseURL:              strings.TrimSuffix(os.Getenv("BASE_URL"), "/"),
+		Mailer:               newMailer(),
+		Logger:               newLogger(),
+		Metrics:              appMetrics,
+		MetricsToken:         os.Getenv("METRICS_TOKEN"),
+		OIDCProviders:        newOIDCProviders(),
+		RequireVerifiedEmail: os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
+		TrustTraceparent:     os.Getenv("TRUST_TRACEPARENT") == "true",
+		PasswordResetByIP:    ratelimit.New(20, time.Hour),
+		PasswordResetByEmail: ratelimit.New(3, time.Hour),
+		MFAAttempts:          ratelimit.New(5, 5*time.Minute),
+		LoginFailuresByIP:    ratelimit.NewBackoff(20, time.Minute, time.Hour),
+	}
+	cfg.subscribeOutbox()
+
+	return cfg
+}
+
+// with-tx
+// Runs fn in a transaction, committed when fn returns nil.
+func (cfg *APIConfig) withTx(ctx context.Context, fn func(q *database.Queries) error) error {
+	tx, err := cfg.SQLDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(database.New(tracing.WrapDB(tx))); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// whatever fn wrote to the outbox is ready
+	cfg.Outbox.Notify()
+	return nil
+}
+
+// base-url
+func (cfg *APIConfig) baseURLFor(r *http.Request) string {
+	if cfg.BaseURL != "" {
+		return cfg.BaseURL
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
 	}
+	return scheme + "://" + r.Host
 }
@@ -0,0 +1,281 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/helpers"
+)
+
+const (
+	mfaTokenTTL       = 5 * time.Minute
+	recoveryCodeCount = 10
+	totpIssuer        = "Chirpy"
+)
+
+// check-second-factor
+// Accepts either a current TOTP code or an unused recovery code.
+func (cfg *APIConfig) checkSecondFactor(ctx context.Context, totp database.UserTotp, code, recoveryCode string) bool {
+	if code != "" {
+		step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now())
+		if !ok {
+			return false
+		}
+
+		// a code can only be used once, even inside its window
+		n, err := cfg.DB.UseTOTPStep(ctx, database.UseTOTPStepParams{
+			UserID:       totp.UserID,
+			LastUsedStep: step,
+		})
+		return err == nil && n == 1
+	}
+
+	if recoveryCode != "" {
+		code := auth.NormalizeRecoveryCode(recoveryCode)
+		n, err := cfg.DB.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+			UserID:   totp.UserID,
+			CodeHash: auth.HashToken(code),
+		})
+		// codes stored before the dash was ignored were hashed with it
+		if err == nil && n == 0 && len(code) == 8 {
+			n, err = cfg.DB.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+				UserID:   totp.UserID,
+				CodeHash: auth.HashToken(code[:4] + "-" + code[4:]),
+			})
+		}
+		return err == nil && n == 1
+	}
+
+	return false
+}
+
+// enroll-2fa
+func (cfg *APIConfig) EnrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	type responseBody struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioning_uri"`
+	}
+
+	// Auth
+	userID, scopes, err := cfg.AuthenticateRequest(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+	if !scopes.Has(auth.ScopeAll) {
+		helpers.RespondWithError(w, 403, missingScope(auth.ScopeAll))
+		return
+	}
+
+	user, err := cfg.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		helpers.RespondWithError(w, 404, "User not found", err)
+		return
+	}
+
+	existing, err := cfg.DB.GetTOTP(r.Context(), userID)
+	if err == nil && existing.ConfirmedAt.Valid {
+		helpers.RespondWithError(w, 409, "Two-factor authentication already enabled")
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Error generating secret", err)
+		return
+	}
+
+	err = cfg.DB.UpsertPendingTOTP(r.Context(), database.UpsertPendingTOTPParams{
+		UserID: userID,
+		Secret: secret,
+	})
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	helpers.RespondWithJSON(w, 200, responseBody{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(secret, totpIssuer, user.Email)})
+}
+
+// confirm-2fa
+func (cfg *APIConfig) ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	type requestBody struct {
+		Code string `json:"code"`
+	}
+	type responseBody struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	// Parse request
+	params, err := helpers.ParseRequest[requestBody](r)
+	if err != nil {
+		helpers.RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	// Auth
+	userID, scopes, err := cfg.AuthenticateRequest(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+	if !scopes.Has(auth.ScopeAll) {
+		helpers.RespondWithError(w, 403, missingScope(auth.ScopeAll))
+		return
+	}
+
+	totp, err := cfg.DB.GetTOTP(r.Context(), userID)
+	if err != nil {
+		helpers.RespondWithError(w, 404, "Two-factor enrollment not started", err)
+		return
+	}
+	if totp.ConfirmedAt.Valid {
+		helpers.RespondWithError(w, 409, "Two-factor authentication already enabled")
+		return
+	}
+
+	if !cfg.MFAAttempts.Allow(userID.String()) {
+		helpers.RespondWithError(w, 429, "Too many attempts")
+		return
+	}
+	if !cfg.checkSecondFactor(r.Context(), totp, params.Code, "") {
+		helpers.RespondWithError(w, 400, "Invalid code")
+		return
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Error generating recovery codes", err)
+		return
+	}
+
+	// replace any codes left over from a previous enrollment
+	if err := cfg.DB.DeleteRecoveryCodes(r.Context(), userID); err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+	for _, code := range codes {
+		err := cfg.DB.InsertRecoveryCode(r.Context(), database.InsertRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code)),
+		})
+		if err != nil {
+			helpers.RespondWithError(w, 500, "Database error", err)
+			return
+		}
+	}
+
+	if err := cfg.DB.ConfirmTOTP(r.Context(), userID); err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	// recovery codes are only ever shown once
+	helpers.RespondWithJSON(w, 200, responseBody{
+		RecoveryCodes: codes})
+}
+
+// disable-2fa
+func (cfg *APIConfig) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	type requestBody struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	// Parse request
+	params, err := helpers.ParseRequest[requestBody](r)
+	if err != nil {
+		helpers.RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	// Auth
+	userID, scopes, err := cfg.AuthenticateRequest(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+	if !scopes.Has(auth.ScopeAll) {
+		helpers.RespondWithError(w, 403, missingScope(auth.ScopeAll))
+		return
+	}
+
+	totp, err := cfg.DB.GetTOTP(r.Context(), userID)
+	if err != nil || !totp.ConfirmedAt.Valid {
+		helpers.RespondWithError(w, 404, "Two-factor authentication not enabled")
+		return
+	}
+
+	if !cfg.MFAAttempts.Allow(userID.String()) {
+		helpers.RespondWithError(w, 429, "Too many attempts")
+		return
+	}
+	if !cfg.checkSecondFactor(r.Context(), totp, params.Code, params.RecoveryCode) {
+		helpers.RespondWithError(w, 401, "Invalid code")
+		return
+	}
+
+	if err := cfg.DB.DeleteTOTP(r.Context(), userID); err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+	if err := cfg.DB.DeleteRecoveryCodes(r.Context(), userID); err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	helpers.RespondNoContent(w)
+}
+
+// login-mfa
+func (cfg *APIConfig) LoginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	type requestBody struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	// Parse request
+	params, err := helpers.ParseRequest[requestBody](r)
+	if err != nil {
+		helpers.RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	userID, err := auth.ValidateMFAToken(params.MFAToken, cfg.JWTSecret)
+	if err != nil {
+		helpers.RespondWithError(w, 401, "Invalid or expired MFA token", err)
+		return
+	}
+
+	totp, err := cfg.DB.GetTOTP(r.Context(), userID)
+	if err != nil || !totp.ConfirmedAt.Valid {
+		helpers.RespondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	if !cfg.MFAAttempts.Allow(userID.String()) {
+		helpers.RespondWithError(w, 429, "Too many attempts")
+		return
+	}
+	if !cfg.checkSecondFactor(r.Context(), totp, params.Code, params.RecoveryCode) {
+		helpers.RespondWithError(w, 401, "Invalid code")
+		return
+	}
+
+	user, err := cfg.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		helpers.RespondWithError(w, 401, "Unauthorized", err)
+		return
+	}
+
+	cfg.respondWithLogin(w, r, user)
+}
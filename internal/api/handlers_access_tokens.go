@@ -0,0 +1,182 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/helpers"
+	"github.com/google/uuid"
+)
+
+const patMaxLifetimeDays = 365
+
+// create-personal-access-token
+func (cfg *APIConfig) CreateAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	type requestBody struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	type responseBody struct {
+		Id         uuid.UUID  `json:"id"`
+		Created_at time.Time  `json:"created_at"`
+		Name       string     `json:"name"`
+		Scopes     []string   `json:"scopes"`
+		Expires_at *time.Time `json:"expires_at"`
+		Token      string     `json:"token"`
+	}
+
+	// Parse request
+	params, err := helpers.ParseRequest[requestBody](r)
+	if err != nil {
+		helpers.RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	// Auth: only an interactive login can mint tokens
+	userID, scopes, err := cfg.AuthenticateRequest(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+	if !scopes.Has(auth.ScopeAll) {
+		helpers.RespondWithError(w, 403, "Personal access tokens can't manage tokens")
+		return
+	}
+
+	// Business logic
+	if params.Name == "" {
+		helpers.RespondWithError(w, 400, "Name is required")
+		return
+	}
+	if err := auth.ValidatePATScopes(params.Scopes); err != nil {
+		helpers.RespondWithError(w, 400, err.Error())
+		return
+	}
+	if params.ExpiresInDays < 0 || params.ExpiresInDays > patMaxLifetimeDays {
+		helpers.RespondWithError(w, 400, "expires_in_days must be between 1 and 365, or 0 for no expiry")
+		return
+	}
+
+	var expiresAt sql.NullTime
+	if params.ExpiresInDays > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().UTC().AddDate(0, 0, params.ExpiresInDays), Valid: true}
+	}
+
+	token, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Error in Token creation", err)
+		return
+	}
+
+	pat, err := cfg.DB.CreatePersonalAccessToken(r.Context(), database.CreatePersonalAccessTokenParams{
+		UserID:    userID,
+		Name:      params.Name,
+		TokenHash: auth.HashToken(token),
+		Scopes:    params.Scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Error in Token creation", err)
+		return
+	}
+
+	// the token is only ever shown once
+	helpers.RespondWithJSON(w, 201, responseBody{
+		Id:         pat.ID,
+		Created_at: pat.CreatedAt,
+		Name:       pat.Name,
+		Scopes:     pat.Scopes,
+		Expires_at: nullTimePtr(pat.ExpiresAt),
+		Token:      token})
+}
+
+// list-personal-access-tokens
+func (cfg *APIConfig) ListAccessTokensHandler(w http.ResponseWriter, r *http.Request) {
+	type responseBody struct {
+		Id           uuid.UUID  `json:"id"`
+		Created_at   time.Time  `json:"created_at"`
+		Name         string     `json:"name"`
+		Scopes       []string   `json:"scopes"`
+		Expires_at   *time.Time `json:"expires_at"`
+		Last_used_at *time.Time `json:"last_used_at"`
+	}
+
+	// Auth
+	userID, scopes, err := cfg.AuthenticateRequest(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+	if !scopes.Has(auth.ScopeAll) {
+		helpers.RespondWithError(w, 403, "Personal access tokens can't manage tokens")
+		return
+	}
+
+	tokens, err := cfg.DB.ListPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	responses := make([]responseBody, len(tokens))
+	for i, t := range tokens {
+		responses[i] = responseBody{
+			Id:           t.ID,
+			Created_at:   t.CreatedAt,
+			Name:         t.Name,
+			Scopes:       t.Scopes,
+			Expires_at:   nullTimePtr(t.ExpiresAt),
+			Last_used_at: nullTimePtr(t.LastUsedAt),
+		}
+	}
+
+	helpers.RespondWithJSON(w, 200, responses)
+}
+
+// revoke-personal-access-token
+func (cfg *APIConfig) RevokeAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Invalid token ID", err)
+		return
+	}
+
+	// Auth
+	userID, scopes, err := cfg.AuthenticateRequest(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+	if !scopes.Has(auth.ScopeAll) {
+		helpers.RespondWithError(w, 403, "Personal access tokens can't manage tokens")
+		return
+	}
+
+	n, err := cfg.DB.RevokePersonalAccessToken(r.Context(), database.RevokePersonalAccessTokenParams{
+		ID:     tokenID,
+		UserID: userID,
+	})
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+	if n == 0 {
+		helpers.RespondWithError(w, 404, "Token not found")
+		return
+	}
+
+	helpers.RespondNoContent(w)
+}
+
+// null-time-ptr
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
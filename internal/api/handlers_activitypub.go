@@ -0,0 +1,474 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/activitypub"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/helpers"
+	"github.com/Johnermac/http-server/internal/jobs"
+	"github.com/Johnermac/http-server/internal/outbox"
+	"github.com/google/uuid"
+)
+
+// per request to another server
+const federationTimeout = 10 * time.Second
+
+// respond-with-activity
+func respondWithActivity(w http.ResponseWriter, code int, payload any) {
+	response, err := json.Marshal(payload)
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Error encoding activity", err)
+		return
+	}
+	w.Header().Set("Content-Type", activitypub.ContentType)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(code)
+	w.Write(response)
+}
+
+// actor-url
+func actorURL(base string, userID uuid.UUID) string {
+	return base + "/ap/users/" + userID.String()
+}
+
+// actor-key
+// Keys are generated lazily the first time a user is federated.
+func (cfg *APIConfig) actorKey(ctx context.Context, userID uuid.UUID) (database.ActorKey, error) {
+	key, err := cfg.DB.GetActorKey(ctx, userID)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return key, err
+	}
+
+	publicPEM, privatePEM, err := activitypub.GenerateKeyPair()
+	if err != nil {
+		return key, err
+	}
+	err = cfg.DB.InsertActorKey(ctx, database.InsertActorKeyParams{
+		UserID:        userID,
+		PublicKeyPem:  publicPEM,
+		PrivateKeyPem: privatePEM,
+	})
+	if err != nil {
+		return key, err
+	}
+
+	// re-read in case another request won the race
+	return cfg.DB.GetActorKey(ctx, userID)
+}
+
+// note-for-chirp
+func noteForChirp(base string, chirp database.Chirp) activitypub.Note {
+	actor := actorURL(base, chirp.UserID)
+	return activitypub.Note{
+		ID:           base + "/ap/chirps/" + chirp.ID.String(),
+		Type:         "Note",
+		AttributedTo: actor,
+		Content:      "<p>" + html.EscapeString(chirp.Body) + "</p>",
+		Published:    chirp.CreatedAt.UTC().Format(time.RFC3339),
+		Updated:      chirp.UpdatedAt.UTC().Format(time.RFC3339),
+		URL:          base + "/api/chirps/" + chirp.ID.String(),
+		To:           []string{activitypub.PublicAudience},
+		Cc:           []string{actor + "/followers"},
+	}
+}
+
+// get-local-user
+func (cfg *APIConfig) getLocalUser(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		helpers.RespondWithError(w, 404, "User not found", err)
+		return database.User{}, false
+	}
+
+	user, err := cfg.DB.GetUser(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.RespondWithError(w, 404, "User not found")
+		return user, false
+	}
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return user, false
+	}
+	return user, true
+}
+
+// webfinger
+func (cfg *APIConfig) WebFingerHandler(w http.ResponseWriter, r *http.Request) {
+	base := cfg.baseURLFor(r)
+	host := strings.TrimPrefix(strings.TrimPrefix(base, "https://"), "http://")
+
+	resource := r.URL.Query().Get("resource")
+	var name string
+	switch {
+	case strings.HasPrefix(resource, "acct:"):
+		var domain string
+		var ok bool
+		name, domain, ok = strings.Cut(strings.TrimPrefix(resource, "acct:"), "@")
+		if !ok || !strings.EqualFold(domain, host) {
+			helpers.RespondWithError(w, 404, "Resource not found")
+			return
+		}
+	case strings.HasPrefix(resource, base+"/ap/users/"):
+		name = strings.TrimPrefix(resource, base+"/ap/users/")
+	default:
+		helpers.RespondWithError(w, 400, "Invalid resource")
+		return
+	}
+
+	userID, err := uuid.Parse(name)
+	if err != nil {
+		helpers.RespondWithError(w, 404, "Resource not found", err)
+		return
+	}
+	if _, err := cfg.DB.GetUser(r.Context(), userID); err != nil {
+		helpers.RespondWithError(w, 404, "Resource not found", err)
+		return
+	}
+
+	actor := actorURL(base, userID)
+	w.Header().Set("Content-Type", "application/jrd+json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(activitypub.WebFinger{
+		Subject: "acct:" + userID.String() + "@" + host,
+		Aliases: []string{actor},
+		Links: []activitypub.WebFingerLink{
+			{Rel: "self", Type: activitypub.ContentType, Href: actor},
+		},
+	})
+}
+
+// get-actor
+func (cfg *APIConfig) ActorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.getLocalUser(w, r)
+	if !ok {
+		return
+	}
+
+	key, err := cfg.actorKey(r.Context(), user.ID)
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Error loading actor key", err)
+		return
+	}
+
+	base := cfg.baseURLFor(r)
+	actor := actorURL(base, user.ID)
+	respondWithActivity(w, 200, activitypub.Actor{
+		Context:           activitypub.WithContext(),
+		ID:                actor,
+		Type:              "Person",
+		PreferredUsername: user.ID.String(),
+		Inbox:             actor + "/inbox",
+		Outbox:            actor + "/outbox",
+		Followers:         actor + "/followers",
+		URL:               base + "/api/chirps?author_id=" + user.ID.String(),
+		PublicKey: activitypub.PublicKey{
+			ID:           actor + "#main-key",
+			Owner:        actor,
+			PublicKeyPem: key.PublicKeyPem,
+		},
+	})
+}
+
+// get-outbox
+func (cfg *APIConfig) OutboxHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.getLocalUser(w, r)
+	if !ok {
+		return
+	}
+
+	chirps, err := cfg.DB.GetChirpsByAuthor(r.Context(), user.ID)
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Get Chirps error", err)
+		return
+	}
+
+	base := cfg.baseURLFor(r)
+	actor := actorURL(base, user.ID)
+
+	// newest first
+	items := make([]any, 0, len(chirps))
+	for i := len(chirps) - 1; i >= 0; i-- {
+		note := noteForChirp(base, chirps[i])
+		items = append(items, map[string]any{
+			"id":        note.ID + "/activity",
+			"type":      "Create",
+			"actor":     actor,
+			"published": note.Published,
+			"to":        note.To,
+			"cc":        note.Cc,
+			"object":    note,
+		})
+	}
+
+	respondWithActivity(w, 200, activitypub.OrderedCollection{
+		Context:      activitypub.WithContext(),
+		ID:           actor + "/outbox",
+		Type:         "OrderedCollection",
+		TotalItems:   len(items),
+		OrderedItems: items,
+	})
+}
+
+// get-followers
+func (cfg *APIConfig) FollowersHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.getLocalUser(w, r)
+	if !ok {
+		return
+	}
+
+	count, err := cfg.DB.CountFollowers(r.Context(), user.ID)
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	respondWithActivity(w, 200, activitypub.OrderedCollection{
+		Context:    activitypub.WithContext(),
+		ID:         actorURL(cfg.baseURLFor(r), user.ID) + "/followers",
+		Type:       "OrderedCollection",
+		TotalItems: int(count),
+	})
+}
+
+// get-note
+func (cfg *APIConfig) NoteHandler(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Invalid chirp ID", err)
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		helpers.RespondWithError(w, 404, "Chirp Not Found", err)
+		return
+	}
+
+	note := noteForChirp(cfg.baseURLFor(r), chirp)
+	note.Context = activitypub.WithContext()
+	respondWithActivity(w, 200, note)
+}
+
+// post-inbox
+func (cfg *APIConfig) InboxHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	user, ok := cfg.getLocalUser(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := activitypub.ReadBody(r)
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Something went wrong", err)
+		return
+	}
+
+	// Auth: the request must be signed by the key of the sending actor
+	keyID, err := activitypub.SignatureKeyID(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+	if err := activitypub.ValidateURL(keyID); err != nil {
+		helpers.RespondWithError(w, 401, "Invalid signature key id", err)
+		return
+	}
+	remote, err := activitypub.FetchActor(r.Context(), cfg.FederationClient, keyID)
+	if err == nil && (remote.PublicKey.ID != keyID || remote.PublicKey.Owner != remote.ID) {
+		err = errors.New("key is not the actor's")
+	}
+	if err != nil {
+		helpers.RespondWithError(w, 401, "Unable to resolve signing key", err)
+		return
+	}
+	pub, err := activitypub.ParsePublicKey(remote.PublicKey.PublicKeyPem)
+	if err != nil {
+		helpers.RespondWithError(w, 401, "Unable to resolve signing key", err)
+		return
+	}
+	if err := activitypub.VerifyRequest(r, body, pub); err != nil {
+		helpers.RespondWithError(w, 401, "Invalid signature", err)
+		return
+	}
+
+	var activity activitypub.Activity
+	if err := json.Unmarshal(body, &activity); err != nil {
+		helpers.RespondWithError(w, 400, "Couldn't unmarshal activity", err)
+		return
+	}
+	if activity.Actor != remote.ID {
+		helpers.RespondWithError(w, 403, "Actor does not match signature")
+		return
+	}
+
+	base := cfg.baseURLFor(r)
+	local := actorURL(base, user.ID)
+
+	switch activity.Type {
+	case "Follow":
+		if activity.ObjectID() != local {
+			helpers.RespondWithError(w, 400, "Follow object is not this actor")
+			return
+		}
+		if err := activitypub.ValidateURL(remote.Inbox); err != nil {
+			helpers.RespondWithError(w, 400, "Remote actor has no inbox", err)
+			return
+		}
+
+		accept, err := activitypub.NewActivity(local+"#accepts/"+uuid.NewString(), "Accept", local, activity, []string{remote.ID}, nil)
+		if err != nil {
+			helpers.RespondWithError(w, 500, "Error building Accept", err)
+			return
+		}
+
+		// the follower and the Accept that tells them are kept together; the
+		// Accept is retried until their inbox takes it
+		err = cfg.withTx(r.Context(), func(q *database.Queries) error {
+			err := q.InsertFollower(r.Context(), database.InsertFollowerParams{
+				UserID:   user.ID,
+				ActorUrl: remote.ID,
+				InboxUrl: remote.Inbox,
+			})
+			if err != nil {
+				return err
+			}
+			_, err = jobs.NewClient(q).Enqueue(r.Context(), sendActivityJob{
+				UserID:   user.ID,
+				Base:     base,
+				Inbox:    remote.Inbox,
+				Activity: accept,
+			}, deliverActivityOptions)
+			return err
+		})
+		if err != nil {
+			helpers.RespondWithError(w, 500, "Database error", err)
+			return
+		}
+
+	case "Undo":
+		inner, err := activity.ObjectActivity()
+		if err != nil || inner.Type != "Follow" || inner.ObjectID() != local {
+			break
+		}
+		err = cfg.DB.DeleteFollower(r.Context(), database.DeleteFollowerParams{
+			UserID:   user.ID,
+			ActorUrl: remote.ID,
+		})
+		if err != nil {
+			helpers.RespondWithError(w, 500, "Database error", err)
+			return
+		}
+	}
+
+	// unsupported activities are accepted and ignored
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// send-activity
+// Sends a queued activity, signed with its actor's key.
+func (cfg *APIConfig) sendActivity(ctx context.Context, args sendActivityJob) error {
+	key, err := cfg.actorKey(ctx, args.UserID)
+	if err != nil {
+		return fmt.Errorf("load key for %s: %w", args.UserID, err)
+	}
+	priv, err := activitypub.ParsePrivateKey(key.PrivateKeyPem)
+	if err != nil {
+		return jobs.Permanent(fmt.Errorf("parse key for %s: %w", args.UserID, err))
+	}
+
+	keyID := actorURL(args.Base, args.UserID) + "#main-key"
+	return activitypub.Deliver(ctx, cfg.FederationClient, args.Inbox, keyID, priv, args.Activity)
+}
+
+// chirpActivities are what chirp events federate as.
+var chirpActivities = map[string]string{
+	eventChirpCreated: "Create",
+	eventChirpUpdated: "Update",
+	eventChirpDeleted: "Delete",
+}
+
+// federate-chirp
+// Outbox subscriber: queues the chirp's Create, Update or Delete for every
+// follower of its author. It runs in the relay's transaction, so each
+// follower gets one delivery per change, retried on its own.
+func (cfg *APIConfig) federateChirp(ctx context.Context, q *database.Queries, ev outbox.Event) error {
+	if cfg.BaseURL == "" {
+		// activities carry absolute URLs, and there's no request to take
+		// them from
+		log.Printf("activitypub: BASE_URL is not set, not federating %s %s", ev.Type, ev.EventID)
+		return nil
+	}
+
+	var chirp chirpEventData
+	if err := json.Unmarshal(ev.Payload, &chirp); err != nil {
+		return err
+	}
+	followers, err := q.GetFollowers(ctx, ev.UserID)
+	if err != nil {
+		return err
+	}
+
+	client := jobs.NewClient(q)
+	for _, f := range followers {
+		_, err := client.Enqueue(ctx, deliverActivityJob{
+			Activity: chirpActivities[ev.Type],
+			Chirp:    chirp,
+			Inbox:    f.InboxUrl,
+		}, deliverActivityOptions)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver-chirp-activity
+// Sends one queued chirp activity to one inbox.
+func (cfg *APIConfig) deliverChirpActivity(ctx context.Context, args deliverActivityJob) error {
+	chirp := database.Chirp{
+		ID:          args.Chirp.Id,
+		CreatedAt:   args.Chirp.Created_at,
+		UpdatedAt:   args.Chirp.Updated_at,
+		Body:        args.Chirp.Data,
+		UserID:      args.Chirp.User_id,
+		Attachments: args.Chirp.Attachments,
+	}
+	actor := actorURL(cfg.BaseURL, chirp.UserID)
+	note := noteForChirp(cfg.BaseURL, chirp)
+
+	var object any = note
+	if args.Activity == "Delete" {
+		object = map[string]string{"id": note.ID, "type": "Tombstone"}
+	}
+
+	activity, err := activitypub.NewActivity(note.ID+"/"+strings.ToLower(args.Activity), args.Activity, actor, object, note.To, note.Cc)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+
+	key, err := cfg.actorKey(ctx, chirp.UserID)
+	if err != nil {
+		return fmt.Errorf("load key for %s: %w", chirp.UserID, err)
+	}
+	priv, err := activitypub.ParsePrivateKey(key.PrivateKeyPem)
+	if err != nil {
+		return jobs.Permanent(fmt.Errorf("parse key for %s: %w", chirp.UserID, err))
+	}
+
+	return activitypub.Deliver(ctx, cfg.FederationClient, args.Inbox, actor+"#main-key", priv, activity)
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/helpers"
+	"github.com/google/uuid"
+)
+
+var errLastAdmin = errors.New("Cannot demote the last admin")
+
+// set-user-role
+// Admin only (see main.go). The last admin can't be demoted, so the admin
+// endpoints can't lock themselves out.
+func (cfg *APIConfig) SetUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	type requestBody struct {
+		Role string `json:"role"`
+	}
+	type responseBody struct {
+		Id         uuid.UUID `json:"id"`
+		Updated_at time.Time `json:"updated_at"`
+		Email      string    `json:"email"`
+		Role       string    `json:"role"`
+	}
+
+	// Parse request
+	params, err := helpers.ParseRequest[requestBody](r)
+	if err != nil {
+		helpers.RespondWithError(w, 400, err.Error())
+		return
+	}
+	if err := auth.ValidateRole(params.Role); err != nil {
+		helpers.RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Invalid user ID", err)
+		return
+	}
+
+	// the admin rows stay locked until the update commits, so two admins
+	// demoting each other at once can't both count the other one
+	var user database.User
+	err = cfg.withTx(r.Context(), func(q *database.Queries) error {
+		admins, err := q.LockUsersByRole(r.Context(), auth.RoleAdmin)
+		if err != nil {
+			return err
+		}
+		if params.Role != auth.RoleAdmin && slices.Contains(admins, userID) && len(admins) <= 1 {
+			return errLastAdmin
+		}
+
+		user, err = q.UpdateUserRole(r.Context(), database.UpdateUserRoleParams{
+			ID:   userID,
+			Role: params.Role,
+		})
+		return err
+	})
+	if errors.Is(err, errLastAdmin) {
+		helpers.RespondWithError(w, 409, errLastAdmin.Error())
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.RespondWithError(w, 404, "User not found")
+		return
+	}
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	helpers.RespondWithJSON(w, 200, responseBody{
+		Id:         user.ID,
+		Updated_at: user.UpdatedAt,
+		Email:      user.Email,
+		Role:       user.Role})
+}
+
+// bootstrap-admin
+// Makes the first admin, for the create-admin command. An existing user is
+// promoted and keeps their password; otherwise the user is created with a
+// verified email, since whoever runs the command vouches for it.
+func (cfg *APIConfig) BootstrapAdmin(ctx context.Context, email, password string) (database.User, error) {
+	admins, err := cfg.DB.CountUsersByRole(ctx, auth.RoleAdmin)
+	if err != nil {
+		return database.User{}, err
+	}
+	if admins > 0 {
+		return database.User{}, errors.New("an admin already exists; promote users with PUT /admin/users/{userID}/role")
+	}
+
+	user, err := cfg.DB.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		user, err = cfg.createAdminUser(ctx, email, password)
+	}
+	if err != nil {
+		return user, err
+	}
+
+	return cfg.DB.UpdateUserRole(ctx, database.UpdateUserRoleParams{
+		ID:   user.ID,
+		Role: auth.RoleAdmin,
+	})
+}
+
+// create-admin-user
+func (cfg *APIConfig) createAdminUser(ctx context.Context, email, password string) (database.User, error) {
+	if err := helpers.ValidateEmail(email); err != nil {
+		return database.User{}, err
+	}
+	if violations := cfg.PasswordPolicy.Check(password, email); len(violations) > 0 {
+		messages := make([]string, len(violations))
+		for i, v := range violations {
+			messages[i] = v.Message
+		}
+		return database.User{}, fmt.Errorf("password does not meet the password policy: %s", strings.Join(messages, "; "))
+	}
+
+	hash, err := cfg.hashPassword(ctx, password)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	user, err := cfg.DB.CreateUser(ctx, database.CreateUserParams{
+		Email:          email,
+		HashedPassword: hash,
+	})
+	if err != nil {
+		return user, err
+	}
+
+	return user, cfg.DB.VerifyUserEmail(ctx, database.VerifyUserEmailParams{
+		ID:    user.ID,
+		Email: email,
+	})
+}
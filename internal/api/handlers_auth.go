@@ -6,24 +6,113 @@ import (
 	"strings"
 
 	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/helpers"
 	"github.com/google/uuid"
 )
 
 // authenticate-request
-func (cfg *APIConfig) AuthenticateRequest(r *http.Request) (uuid.UUID, error) {
+// Accepts a JWT access token (first-party or OAuth) or a personal access
+// token and returns the scopes it grants. Handlers check the scope they need
+// with Scopes.Has.
+func (cfg *APIConfig) AuthenticateRequest(r *http.Request) (uuid.UUID, auth.Scopes, error) {
 	tokenString, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("Missing or invalid Authorization header")
+		return uuid.Nil, nil, fmt.Errorf("Missing or invalid Authorization header")
+	}
+
+	if auth.IsPersonalAccessToken(tokenString) {
+		pat, err := cfg.DB.GetPersonalAccessToken(r.Context(), auth.HashToken(tokenString))
+		if err != nil {
+			return uuid.Nil, nil, fmt.Errorf("Invalid or expired token")
+		}
+
+		// last-used tracking is best effort
+		cfg.DB.TouchPersonalAccessToken(r.Context(), pat.ID)
+
+		setRequestUser(r, pat.UserID)
+		return pat.UserID, auth.Scopes(pat.Scopes), nil
+	}
+
+	claims, userID, err := cfg.accessClaims(r)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	// OAuth tokens are limited to what the user consented to, and can be
+	// revoked before they expire
+	if claims.ClientID != "" {
+		tokenID, err := uuid.Parse(claims.ID)
+		if err != nil {
+			return uuid.Nil, nil, fmt.Errorf("Invalid or expired token")
+		}
+		token, err := cfg.DB.GetOAuthAccessToken(r.Context(), tokenID)
+		if err != nil || token.RevokedAt.Valid {
+			return uuid.Nil, nil, fmt.Errorf("Invalid or expired token")
+		}
+		return userID, auth.Scopes(auth.ParseScope(claims.Scope)), nil
+	}
+
+	return userID, auth.Scopes{auth.ScopeAll}, nil
+}
+
+// missing-scope
+func missingScope(scope string) string {
+	return fmt.Sprintf("Token is missing the %s scope", scope)
+}
+
+// authenticate-session
+// Like AuthenticateRequest, but only accepts first-party JWTs and also
+// returns the session (refresh token family) the access token was issued
+// for, or uuid.Nil if it has none.
+func (cfg *APIConfig) authenticateSession(r *http.Request) (uuid.UUID, uuid.UUID, error) {
+	claims, userID, err := cfg.accessClaims(r)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	if claims.ClientID != "" {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("Invalid or expired token")
+	}
+
+	sessionID, _ := uuid.Parse(claims.SessionID)
+	return userID, sessionID, nil
+}
+
+// access-claims
+func (cfg *APIConfig) accessClaims(r *http.Request) (*auth.AccessClaims, uuid.UUID, error) {
+	tokenString, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return nil, uuid.Nil, fmt.Errorf("Missing or invalid Authorization header")
 	}
 
 	// sanity check
 	if strings.Count(tokenString, ".") != 2 {
-		return uuid.Nil, fmt.Errorf("Invalid or expired token")
+		return nil, uuid.Nil, fmt.Errorf("Invalid or expired token")
 	}
 
-	userID, err := auth.ValidateJWT(tokenString, cfg.JWTSecret)
+	claims, err := cfg.validateAccessToken(r.Context(), tokenString)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("Invalid or expired token")
+		return nil, uuid.Nil, fmt.Errorf("Invalid or expired token")
 	}
-	return userID, nil
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, uuid.Nil, fmt.Errorf("Invalid or expired token")
+	}
+
+	// a first-party token dies with its session, not just when it expires
+	if sessionID, err := uuid.Parse(claims.SessionID); claims.ClientID == "" && err == nil {
+		active, err := cfg.DB.TouchSession(r.Context(), sessionID)
+		if err != nil || !active {
+			return nil, uuid.Nil, fmt.Errorf("Invalid or expired token")
+		}
+	}
+
+	setRequestUser(r, userID)
+	return claims, userID, nil
+}
+
+// jwks
+func (cfg *APIConfig) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	helpers.RespondWithJSON(w, 200, cfg.Keyset.JWKS())
 }
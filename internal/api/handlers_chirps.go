@@ -3,27 +3,35 @@ package api
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"sort"
 	"time"
 
+	"github.com/Johnermac/http-server/internal/auth"
 	"github.com/Johnermac/http-server/internal/database"
 	"github.com/Johnermac/http-server/internal/helpers"
+	"github.com/Johnermac/http-server/internal/outbox"
 	"github.com/google/uuid"
 )
 
+const maxAttachmentURLLength = 2048
+
 // create-chirp
 func (cfg *APIConfig) CreateChirpHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	type requestBody struct {
-		Data string `json:"body"`
+		Data        string   `json:"body"`
+		Attachments []string `json:"attachments"`
 	}
 	type responseBody struct {
-		Id         uuid.UUID `json:"id"`
-		Created_at time.Time `json:"created_at"`
-		Updated_at time.Time `json:"updated_at"`
-		Data       string    `json:"body"`
-		User_id    uuid.UUID `json:"user_id"`
+		Id          uuid.UUID `json:"id"`
+		Created_at  time.Time `json:"created_at"`
+		Updated_at  time.Time `json:"updated_at"`
+		Data        string    `json:"body"`
+		User_id     uuid.UUID `json:"user_id"`
+		Attachments []string  `json:"attachments"`
 	}
 
 	// Parse request
@@ -34,35 +42,66 @@ func (cfg *APIConfig) CreateChirpHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Auth
-	userID, err := cfg.AuthenticateRequest(r)
+	userID, scopes, err := cfg.AuthenticateRequest(r)
 	if err != nil {
 		helpers.RespondWithError(w, 401, err.Error())
 		return
 	}
+	if !scopes.Has(auth.ScopeChirpsWrite) {
+		helpers.RespondWithError(w, 403, missingScope(auth.ScopeChirpsWrite))
+		return
+	}
+
+	// Policy
+	if !cfg.checkVerifiedEmail(w, r, userID) {
+		return
+	}
 
 	// Business logic
-	if len(params.Data) > 140 {
-		helpers.RespondWithError(w, 400, "Chirp is too long")
+	plan := cfg.planFor(r.Context(), userID)
+	caps := cfg.Entitlements.For(plan)
+	if len(params.Data) > caps.MaxChirpLength {
+		helpers.RespondWithError(w, 400, fmt.Sprintf("Chirp is too long (at most %d characters on your plan)", caps.MaxChirpLength))
+		return
+	}
+	attachments, err := checkAttachments(params.Attachments, caps.MaxAttachments)
+	if err != nil {
+		helpers.RespondWithError(w, 400, err.Error())
+		return
+	}
+	if !cfg.Entitlements.AllowChirp(plan, userID.String()) {
+		helpers.RespondWithError(w, 429, "Too many chirps, try again later")
 		return
 	}
 
-	chirp, err := cfg.DB.CreateChirp(r.Context(), database.CreateChirpParams{
-		Body:   helpers.BadWordReplacement(params.Data),
-		UserID: userID, // UUID from users table
+	var chirp database.Chirp
+	err = cfg.withTx(r.Context(), func(q *database.Queries) error {
+		chirp, err = q.CreateChirp(r.Context(), database.CreateChirpParams{
+			Body:        helpers.BadWordReplacement(params.Data),
+			UserID:      userID, // UUID from users table
+			Attachments: attachments,
+		})
+		if err != nil {
+			return err
+		}
+		return outbox.Write(r.Context(), q, eventChirpCreated, userID, newChirpEventData(chirp))
 	})
 
 	if err != nil {
-		helpers.RespondWithError(w, 500, "Create chirp error")
+		helpers.RespondWithError(w, 500, "Create chirp error", err)
 		return
 	}
 
+	cfg.Metrics.chirpsCreated.Inc()
+
 	// Do something with responseBody
 	helpers.RespondWithJSON(w, 201, responseBody{
-		Id:         chirp.ID,
-		Created_at: chirp.CreatedAt,
-		Updated_at: chirp.UpdatedAt,
-		Data:       chirp.Body,
-		User_id:    chirp.UserID})
+		Id:          chirp.ID,
+		Created_at:  chirp.CreatedAt,
+		Updated_at:  chirp.UpdatedAt,
+		Data:        chirp.Body,
+		User_id:     chirp.UserID,
+		Attachments: chirpAttachments(chirp)})
 }
 
 // get-all-chirps
@@ -71,11 +110,12 @@ func (cfg *APIConfig) GetAllChirpsHandler(w http.ResponseWriter, r *http.Request
 	var err error
 
 	type responseBody struct {
-		Id         uuid.UUID `json:"id"`
-		Created_at time.Time `json:"created_at"`
-		Updated_at time.Time `json:"updated_at"`
-		Data       string    `json:"body"`
-		User_id    uuid.UUID `json:"user_id"`
+		Id          uuid.UUID `json:"id"`
+		Created_at  time.Time `json:"created_at"`
+		Updated_at  time.Time `json:"updated_at"`
+		Data        string    `json:"body"`
+		User_id     uuid.UUID `json:"user_id"`
+		Attachments []string  `json:"attachments"`
 	}
 
 	authorID := r.URL.Query().Get("author_id")
@@ -85,7 +125,7 @@ func (cfg *APIConfig) GetAllChirpsHandler(w http.ResponseWriter, r *http.Request
 	} else {
 		userID, err := uuid.Parse(authorID)
 		if err != nil {
-			helpers.RespondWithError(w, 500, "Error parsing authorID")
+			helpers.RespondWithError(w, 500, "Error parsing authorID", err)
 			return
 		}
 		// get-chirps-by-author
@@ -93,7 +133,7 @@ func (cfg *APIConfig) GetAllChirpsHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	if err != nil {
-		helpers.RespondWithError(w, 500, "Get Chirps error")
+		helpers.RespondWithError(w, 500, "Get Chirps error", err)
 		return
 	}
 
@@ -111,11 +151,12 @@ func (cfg *APIConfig) GetAllChirpsHandler(w http.ResponseWriter, r *http.Request
 	responses := make([]responseBody, len(chirps))
 	for i, c := range chirps {
 		responses[i] = responseBody{
-			Id:         c.ID,
-			Created_at: c.CreatedAt,
-			Updated_at: c.UpdatedAt,
-			Data:       c.Body,
-			User_id:    c.UserID,
+			Id:          c.ID,
+			Created_at:  c.CreatedAt,
+			Updated_at:  c.UpdatedAt,
+			Data:        c.Body,
+			User_id:     c.UserID,
+			Attachments: chirpAttachments(c),
 		}
 	}
 
@@ -126,11 +167,12 @@ func (cfg *APIConfig) GetAllChirpsHandler(w http.ResponseWriter, r *http.Request
 // get-chirp
 func (cfg *APIConfig) GetChirpHandler(w http.ResponseWriter, r *http.Request) {
 	type responseBody struct {
-		Id         uuid.UUID `json:"id"`
-		Created_at time.Time `json:"created_at"`
-		Updated_at time.Time `json:"updated_at"`
-		Data       string    `json:"body"`
-		User_id    uuid.UUID `json:"user_id"`
+		Id          uuid.UUID `json:"id"`
+		Created_at  time.Time `json:"created_at"`
+		Updated_at  time.Time `json:"updated_at"`
+		Data        string    `json:"body"`
+		User_id     uuid.UUID `json:"user_id"`
+		Attachments []string  `json:"attachments"`
 	}
 
 	chirpIDStr := r.PathValue("chirpID")
@@ -138,34 +180,36 @@ func (cfg *APIConfig) GetChirpHandler(w http.ResponseWriter, r *http.Request) {
 	// Convert string → UUID
 	chirpID, err := uuid.Parse(chirpIDStr)
 	if err != nil {
-		helpers.RespondWithError(w, 400, "Invalid chirp ID")
+		helpers.RespondWithError(w, 400, "Invalid chirp ID", err)
 		return
 	}
 
 	chirp, err := cfg.DB.GetChirp(r.Context(), chirpID)
 	if err != nil {
-		helpers.RespondWithError(w, 404, "Chirp Not Found")
+		helpers.RespondWithError(w, 404, "Chirp Not Found", err)
 		return
 	}
 
 	// respond with responseBody
 	helpers.RespondWithJSON(w, 200, responseBody{
-		Id:         chirp.ID,
-		Created_at: chirp.CreatedAt,
-		Updated_at: chirp.UpdatedAt,
-		Data:       chirp.Body,
-		User_id:    chirp.UserID,
+		Id:          chirp.ID,
+		Created_at:  chirp.CreatedAt,
+		Updated_at:  chirp.UpdatedAt,
+		Data:        chirp.Body,
+		User_id:     chirp.UserID,
+		Attachments: chirpAttachments(chirp),
 	})
 }
 
 // delete-chirp
 func (cfg *APIConfig) DeleteChirpHandler(w http.ResponseWriter, r *http.Request) {
 	type responseBody struct {
-		Id         uuid.UUID `json:"id"`
-		Created_at time.Time `json:"created_at"`
-		Updated_at time.Time `json:"updated_at"`
-		Data       string    `json:"body"`
-		User_id    uuid.UUID `json:"user_id"`
+		Id          uuid.UUID `json:"id"`
+		Created_at  time.Time `json:"created_at"`
+		Updated_at  time.Time `json:"updated_at"`
+		Data        string    `json:"body"`
+		User_id     uuid.UUID `json:"user_id"`
+		Attachments []string  `json:"attachments"`
 	}
 
 	chirpIDStr := r.PathValue("chirpID")
@@ -173,16 +217,20 @@ func (cfg *APIConfig) DeleteChirpHandler(w http.ResponseWriter, r *http.Request)
 	// Convert string → UUID
 	chirpID, err := uuid.Parse(chirpIDStr)
 	if err != nil {
-		helpers.RespondWithError(w, 400, "Invalid chirp ID")
+		helpers.RespondWithError(w, 400, "Invalid chirp ID", err)
 		return
 	}
 
 	// Auth
-	userID, err := cfg.AuthenticateRequest(r)
+	userID, scopes, err := cfg.AuthenticateRequest(r)
 	if err != nil {
 		helpers.RespondWithError(w, 401, err.Error())
 		return
 	}
+	if !scopes.Has(auth.ScopeChirpsWrite) {
+		helpers.RespondWithError(w, 403, missingScope(auth.ScopeChirpsWrite))
+		return
+	}
 
 	chirp, err := cfg.DB.GetChirp(r.Context(), chirpID)
 	if errors.Is(err, sql.ErrNoRows) {
@@ -190,7 +238,7 @@ func (cfg *APIConfig) DeleteChirpHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	if err != nil {
-		helpers.RespondWithError(w, 500, "Database error")
+		helpers.RespondWithError(w, 500, "Database error", err)
 		return
 	}
 
@@ -199,15 +247,176 @@ func (cfg *APIConfig) DeleteChirpHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = cfg.DB.DeleteChirp(r.Context(), database.DeleteChirpParams{
-		UserID: userID,
-		ID:     chirpID,
+	err = cfg.withTx(r.Context(), func(q *database.Queries) error {
+		err := q.DeleteChirp(r.Context(), database.DeleteChirpParams{
+			UserID: userID,
+			ID:     chirpID,
+		})
+		if err != nil {
+			return err
+		}
+		return outbox.Write(r.Context(), q, eventChirpDeleted, userID, newChirpEventData(chirp))
 	})
 	if err != nil {
-		helpers.RespondWithError(w, 500, "Database error")
+		helpers.RespondWithError(w, 500, "Database error", err)
 		return
 	}
 
 	// respond with responseBody
 	helpers.RespondNoContent(w)
 }
+
+// update-chirp
+// Editing is an entitlement: only within the plan's edit window, and with
+// the plan's limits. Attachments are replaced if given and kept if not.
+func (cfg *APIConfig) UpdateChirpHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	type requestBody struct {
+		Data        string   `json:"body"`
+		Attachments []string `json:"attachments"`
+	}
+	type responseBody struct {
+		Id          uuid.UUID `json:"id"`
+		Created_at  time.Time `json:"created_at"`
+		Updated_at  time.Time `json:"updated_at"`
+		Data        string    `json:"body"`
+		User_id     uuid.UUID `json:"user_id"`
+		Attachments []string  `json:"attachments"`
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Invalid chirp ID", err)
+		return
+	}
+
+	// Parse request
+	params, err := helpers.ParseRequest[requestBody](r)
+	if err != nil {
+		helpers.RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	// Auth
+	userID, scopes, err := cfg.AuthenticateRequest(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+	if !scopes.Has(auth.ScopeChirpsWrite) {
+		helpers.RespondWithError(w, 403, missingScope(auth.ScopeChirpsWrite))
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirp(r.Context(), chirpID)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.RespondWithError(w, 404, "Chirp not found")
+		return
+	}
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+	if chirp.UserID != userID {
+		helpers.RespondWithError(w, 403, "Forbidden")
+		return
+	}
+
+	// Policy
+	if !cfg.checkVerifiedEmail(w, r, userID) {
+		return
+	}
+
+	// Business logic
+	caps := cfg.Entitlements.For(cfg.planFor(r.Context(), userID))
+	if caps.EditWindow == 0 {
+		helpers.RespondWithError(w, 403, "Your plan doesn't include editing chirps")
+		return
+	}
+	if !caps.CanEdit(chirp.CreatedAt, time.Now()) {
+		helpers.RespondWithError(w, 403, "The edit window for this chirp has passed")
+		return
+	}
+	if len(params.Data) > caps.MaxChirpLength {
+		helpers.RespondWithError(w, 400, fmt.Sprintf("Chirp is too long (at most %d characters on your plan)", caps.MaxChirpLength))
+		return
+	}
+	attachments := chirpAttachments(chirp)
+	if params.Attachments != nil {
+		attachments, err = checkAttachments(params.Attachments, caps.MaxAttachments)
+		if err != nil {
+			helpers.RespondWithError(w, 400, err.Error())
+			return
+		}
+	}
+
+	err = cfg.withTx(r.Context(), func(q *database.Queries) error {
+		chirp, err = q.UpdateChirp(r.Context(), database.UpdateChirpParams{
+			ID:          chirpID,
+			UserID:      userID,
+			Body:        helpers.BadWordReplacement(params.Data),
+			Attachments: attachments,
+		})
+		if err != nil {
+			return err
+		}
+		return outbox.Write(r.Context(), q, eventChirpUpdated, userID, newChirpEventData(chirp))
+	})
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	helpers.RespondWithJSON(w, 200, responseBody{
+		Id:          chirp.ID,
+		Created_at:  chirp.CreatedAt,
+		Updated_at:  chirp.UpdatedAt,
+		Data:        chirp.Body,
+		User_id:     chirp.UserID,
+		Attachments: chirpAttachments(chirp)})
+}
+
+// check-verified-email
+// With REQUIRE_VERIFIED_EMAIL, only users who verified their address may
+// write chirps. Responds and returns false if the user may not.
+func (cfg *APIConfig) checkVerifiedEmail(w http.ResponseWriter, r *http.Request, userID uuid.UUID) bool {
+	if !cfg.RequireVerifiedEmail {
+		return true
+	}
+
+	user, err := cfg.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		helpers.RespondWithError(w, 401, "User not found", err)
+		return false
+	}
+	if !user.EmailVerifiedAt.Valid {
+		helpers.RespondWithError(w, 403, "Email address not verified")
+		return false
+	}
+	return true
+}
+
+// check-attachments
+// Attachments are links to media hosted elsewhere: absolute https URLs, no
+// more than the plan allows. Returns them ready to store.
+func checkAttachments(urls []string, limit int) ([]string, error) {
+	if len(urls) > limit {
+		return nil, fmt.Errorf("Too many attachments (at most %d on your plan)", limit)
+	}
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || u.Scheme != "https" || u.Host == "" || len(raw) > maxAttachmentURLLength {
+			return nil, fmt.Errorf("Invalid attachment URL %q", raw)
+		}
+	}
+	return append([]string{}, urls...), nil
+}
+
+// chirp-attachments
+// [] rather than null for a chirp without attachments.
+func chirpAttachments(c database.Chirp) []string {
+	if c.Attachments == nil {
+		return []string{}
+	}
+	return c.Attachments
+}
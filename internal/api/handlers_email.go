@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/helpers"
+	"github.com/Johnermac/http-server/internal/mailer"
+)
+
+const emailVerificationTTL = 24 * time.Hour
+
+// send-verification-email
+func (cfg *APIConfig) sendVerificationEmail(ctx context.Context, base string, user database.User) error {
+	token, id, err := auth.MakeEmailVerificationToken(user.ID, user.Email, cfg.JWTSecret, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	_, err = cfg.DB.CreateEmailVerification(ctx, database.CreateEmailVerificationParams{
+		ID:        id,
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: time.Now().UTC().Add(emailVerificationTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := base + "/api/verify-email?token=" + url.QueryEscape(token)
+	return cfg.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Welcome to Chirpy!\n\nConfirm your email address by opening this link:\n\n%s\n\n"+
+			"The link expires in 24 hours and can only be used once.\n", link),
+	})
+}
+
+// verify-email
+func (cfg *APIConfig) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		helpers.RespondWithError(w, 400, "Missing token")
+		return
+	}
+
+	userID, email, id, err := auth.ValidateEmailVerificationToken(token, cfg.JWTSecret)
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Invalid or expired token", err)
+		return
+	}
+
+	// single-use: fails if the token was already consumed
+	verification, err := cfg.DB.UseEmailVerification(r.Context(), id)
+	if err != nil || verification.UserID != userID || verification.Email != email {
+		helpers.RespondWithError(w, 400, "Invalid or expired token")
+		return
+	}
+
+	// no-op if the user changed their email since the token was issued
+	err = cfg.DB.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{
+		ID:    userID,
+		Email: email,
+	})
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	helpers.RespondWithJSON(w, 200, "Email verified")
+}
+
+// resend-verification-email
+func (cfg *APIConfig) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	// Auth
+	userID, scopes, err := cfg.AuthenticateRequest(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+	if !scopes.Has(auth.ScopeProfileWrite) {
+		helpers.RespondWithError(w, 403, missingScope(auth.ScopeProfileWrite))
+		return
+	}
+
+	user, err := cfg.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		helpers.RespondWithError(w, 404, "User not found", err)
+		return
+	}
+
+	if user.EmailVerifiedAt.Valid {
+		helpers.RespondWithError(w, 409, "Email already verified")
+		return
+	}
+
+	if err := cfg.sendVerificationEmail(r.Context(), cfg.baseURLFor(r), user); err != nil {
+		helpers.RespondWithError(w, 500, "Error sending verification email", err)
+		return
+	}
+
+	helpers.RespondNoContent(w)
+}
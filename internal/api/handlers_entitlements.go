@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/Johnermac/http-server/internal/entitlements"
+	"github.com/Johnermac/http-server/internal/helpers"
+)
+
+// get-entitlements
+// What the caller's current plan allows, so clients can show the limits.
+func (cfg *APIConfig) GetEntitlementsHandler(w http.ResponseWriter, r *http.Request) {
+	type responseBody struct {
+		Plan string `json:"plan"`
+		entitlements.Capabilities
+	}
+
+	// Auth
+	userID, _, err := cfg.AuthenticateRequest(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+
+	plan := cfg.planFor(r.Context(), userID)
+	helpers.RespondWithJSON(w, 200, responseBody{
+		Plan:         plan,
+		Capabilities: cfg.Entitlements.For(plan)})
+}
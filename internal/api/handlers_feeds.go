@@ -0,0 +1,136 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/feeds"
+	"github.com/Johnermac/http-server/internal/helpers"
+	"github.com/google/uuid"
+)
+
+const feedMaxEntries = 50
+
+// split-feed-name
+func splitFeedName(file string) (string, string, bool) {
+	name, format, ok := strings.Cut(file, ".")
+	if !ok || (format != "atom" && format != "rss") {
+		return "", "", false
+	}
+	return name, format, true
+}
+
+// user-feed
+func (cfg *APIConfig) UserFeedHandler(w http.ResponseWriter, r *http.Request) {
+	name, format, ok := splitFeedName(r.PathValue("file"))
+	if !ok {
+		helpers.RespondWithError(w, 404, "Feed not found")
+		return
+	}
+
+	userID, err := uuid.Parse(name)
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Invalid user ID", err)
+		return
+	}
+
+	user, err := cfg.DB.GetUser(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.RespondWithError(w, 404, "User not found")
+		return
+	}
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	chirps, err := cfg.DB.GetChirpsByAuthor(r.Context(), user.ID)
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Get Chirps error", err)
+		return
+	}
+
+	base := cfg.baseURLFor(r)
+	cfg.serveFeed(w, r, format, user.UpdatedAt, chirps, feeds.Feed{
+		ID:          "urn:uuid:" + user.ID.String(),
+		Title:       "Chirpy - chirps by " + user.ID.String(),
+		Description: "Latest chirps by " + user.ID.String(),
+		SelfURL:     base + r.URL.Path,
+		SiteURL:     base + "/api/chirps?author_id=" + user.ID.String(),
+	})
+}
+
+// global-feed
+func (cfg *APIConfig) GlobalFeedHandler(w http.ResponseWriter, r *http.Request) {
+	name, format, ok := splitFeedName(r.PathValue("file"))
+	if !ok || name != "chirps" {
+		helpers.RespondWithError(w, 404, "Feed not found")
+		return
+	}
+
+	chirps, err := cfg.DB.GetAllChirps(r.Context())
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Get Chirps error", err)
+		return
+	}
+
+	base := cfg.baseURLFor(r)
+	cfg.serveFeed(w, r, format, time.Unix(0, 0), chirps, feeds.Feed{
+		ID:          base + "/feeds/chirps",
+		Title:       "Chirpy - all chirps",
+		Description: "Latest chirps on Chirpy",
+		SelfURL:     base + r.URL.Path,
+		SiteURL:     base + "/api/chirps",
+	})
+}
+
+// serve-feed
+func (cfg *APIConfig) serveFeed(w http.ResponseWriter, r *http.Request, format string, updated time.Time, chirps []database.Chirp, feed feeds.Feed) {
+	base := cfg.baseURLFor(r)
+
+	// newest first, capped
+	for i := len(chirps) - 1; i >= 0 && len(feed.Entries) < feedMaxEntries; i-- {
+		c := chirps[i]
+		feed.Entries = append(feed.Entries, feeds.Entry{
+			ID:        "urn:uuid:" + c.ID.String(),
+			URL:       base + "/api/chirps/" + c.ID.String(),
+			Author:    c.UserID.String(),
+			Body:      c.Body,
+			CreatedAt: c.CreatedAt,
+			UpdatedAt: c.UpdatedAt,
+		})
+		if c.UpdatedAt.After(updated) {
+			updated = c.UpdatedAt
+		}
+	}
+	feed.Updated = updated
+
+	var body []byte
+	var err error
+	if format == "atom" {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		body, err = feeds.RenderAtom(feed)
+	} else {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		body, err = feeds.RenderRSS(feed)
+	}
+	if err != nil {
+		w.Header().Del("Content-Type")
+		helpers.RespondWithError(w, 500, "Error rendering feed", err)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// handles If-None-Match / If-Modified-Since
+	http.ServeContent(w, r, "", updated, bytes.NewReader(body))
+}
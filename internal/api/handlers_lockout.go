@@ -0,0 +1,144 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/helpers"
+	"github.com/Johnermac/http-server/internal/ratelimit"
+	"github.com/google/uuid"
+)
+
+const (
+	accountLockThreshold = 5
+	accountLockBase      = time.Minute
+	accountLockMax       = time.Hour
+)
+
+var (
+	// the same for unknown emails, wrong passwords and locked accounts
+	errInvalidLogin    = errors.New("Invalid email or password")
+	errTooManyAttempts = errors.New("Too many failed login attempts, try again later")
+)
+
+// check-login
+// Checks an email and password, counting failures per IP (in memory) and per
+// account (in the database). Locked accounts answer exactly like a wrong
+// password; only the per-IP block, which says nothing about the account,
+// is reported as such, with how long to wait.
+func (cfg *APIConfig) checkLogin(r *http.Request, email, password string) (database.User, time.Duration, error) {
+	ip := helpers.ClientIP(r)
+	if wait, blocked := cfg.LoginFailuresByIP.Blocked(ip); blocked {
+		return database.User{}, wait, errTooManyAttempts
+	}
+
+	user, err := cfg.DB.GetUserByEmail(r.Context(), email)
+	if err != nil {
+		cfg.burnPasswordCheck(r.Context(), password)
+		cfg.LoginFailuresByIP.Fail(ip)
+		return database.User{}, 0, errInvalidLogin
+	}
+
+	locked := false
+	failures, err := cfg.DB.GetLoginFailures(r.Context(), user.ID)
+	if err == nil && failures.LockedUntil.Valid && failures.LockedUntil.Time.After(time.Now()) {
+		locked = true
+	}
+
+	// checked even when locked, so a locked account takes as long to answer
+	ok, rehash, err := cfg.verifyPassword(r.Context(), password, user.HashedPassword)
+	if err != nil || !ok || locked {
+		cfg.LoginFailuresByIP.Fail(ip)
+		if !locked {
+			cfg.recordLoginFailure(r, user.ID)
+		}
+		return database.User{}, 0, errInvalidLogin
+	}
+
+	if _, err := cfg.DB.ClearLoginFailures(r.Context(), user.ID); err != nil {
+		cfg.logger(r.Context()).ErrorContext(r.Context(), "clear login failures", "user_id", user.ID, "error", err)
+	}
+
+	// the plaintext is only around now, so this is when old hashes get upgraded
+	if rehash {
+		cfg.rehashPassword(r, user, password)
+	}
+	return user, 0, nil
+}
+
+// rehash-password
+// Best effort: a failure leaves the old, still valid, hash in place.
+func (cfg *APIConfig) rehashPassword(r *http.Request, user database.User, password string) {
+	hash, err := cfg.hashPassword(r.Context(), password)
+	if err != nil {
+		cfg.logger(r.Context()).ErrorContext(r.Context(), "rehash password", "user_id", user.ID, "error", err)
+		return
+	}
+
+	err = cfg.DB.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		ID:             user.ID,
+		HashedPassword: hash,
+	})
+	if err != nil {
+		cfg.logger(r.Context()).ErrorContext(r.Context(), "rehash password", "user_id", user.ID, "error", err)
+	}
+}
+
+// record-login-failure
+func (cfg *APIConfig) recordLoginFailure(r *http.Request, userID uuid.UUID) {
+	n, err := cfg.DB.RecordLoginFailure(r.Context(), userID)
+	if err != nil {
+		cfg.logger(r.Context()).ErrorContext(r.Context(), "record login failure", "user_id", userID, "error", err)
+		return
+	}
+
+	delay := ratelimit.BackoffDelay(int(n), accountLockThreshold, accountLockBase, accountLockMax)
+	if delay == 0 {
+		return
+	}
+
+	cfg.logger(r.Context()).WarnContext(r.Context(), "locking account", "user_id", userID, "duration", delay, "failed_logins", n)
+	err = cfg.DB.LockAccount(r.Context(), database.LockAccountParams{
+		UserID:      userID,
+		LockedUntil: sql.NullTime{Time: time.Now().UTC().Add(delay), Valid: true},
+	})
+	if err != nil {
+		cfg.logger(r.Context()).ErrorContext(r.Context(), "lock account", "user_id", userID, "error", err)
+	}
+}
+
+// respond-login-error
+func respondLoginError(w http.ResponseWriter, wait time.Duration, err error) {
+	if errors.Is(err, errTooManyAttempts) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		helpers.RespondWithError(w, 429, err.Error())
+		return
+	}
+	helpers.RespondWithError(w, 401, errInvalidLogin.Error())
+}
+
+// unlock-user
+// Moderators and admins only (see main.go).
+func (cfg *APIConfig) UnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Invalid user ID", err)
+		return
+	}
+
+	if _, err := cfg.DB.GetUser(r.Context(), userID); err != nil {
+		helpers.RespondWithError(w, 404, "User not found", err)
+		return
+	}
+
+	if _, err := cfg.DB.ClearLoginFailures(r.Context(), userID); err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	helpers.RespondNoContent(w)
+}
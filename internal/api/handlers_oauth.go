@@ -0,0 +1,636 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/helpers"
+	"github.com/google/uuid"
+)
+
+const (
+	oauthCodeTTL        = 10 * time.Minute
+	oauthAccessTokenTTL = time.Hour
+)
+
+// authorizeRequest is a validated authorization request.
+type authorizeRequest struct {
+	Client        database.OauthClient
+	RedirectURI   string
+	Scopes        []string
+	State         string
+	CodeChallenge string
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorize {{.Client.Name}}</title></head>
+<body>
+  <h1>{{.Client.Name}} wants to access your Chirpy account</h1>
+  <p>It is asking for:</p>
+  <ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+  {{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+  <form method="post" action="/oauth/authorize">
+    <input type="hidden" name="response_type" value="code">
+    <input type="hidden" name="client_id" value="{{.Client.ID}}">
+    <input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+    <input type="hidden" name="scope" value="{{.Scope}}">
+    <input type="hidden" name="state" value="{{.State}}">
+    <input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+    <input type="hidden" name="code_challenge_method" value="S256">
+    <label>Email <input type="email" name="email"></label>
+    <label>Password <input type="password" name="password"></label>
+    <label>2FA code (if enabled) <input type="text" name="code" autocomplete="one-time-code"></label>
+    <button type="submit" name="decision" value="approve">Allow</button>
+    <button type="submit" name="decision" value="deny">Deny</button>
+  </form>
+</body>
+</html>
+`))
+
+// oauth-error
+// OAuth endpoints answer with the RFC 6749 error format. cause, when given,
+// is only logged.
+func oauthError(w http.ResponseWriter, code int, errCode, description string, cause ...error) {
+	helpers.RespondWithErrorBody(w, code, map[string]string{
+		"error":             errCode,
+		"error_description": description,
+	}, errCode+": "+description, cause...)
+}
+
+// redirect-with-params
+func redirectWithParams(w http.ResponseWriter, r *http.Request, redirectURI string, params map[string]string) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Invalid redirect_uri", err)
+		return
+	}
+
+	q := u.Query()
+	for k, v := range params {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+// parse-authorize-request
+// Errors found before the redirect URI is validated leave RedirectURI empty
+// and must not be redirected; the rest come with the OAuth error code.
+func (cfg *APIConfig) parseAuthorizeRequest(ctx context.Context, v url.Values) (authorizeRequest, string, error) {
+	var req authorizeRequest
+
+	clientID, err := uuid.Parse(v.Get("client_id"))
+	if err != nil {
+		return req, "invalid_request", errors.New("Invalid client_id")
+	}
+	req.Client, err = cfg.DB.GetOAuthClient(ctx, clientID)
+	if err != nil {
+		return req, "invalid_request", errors.New("Unknown client")
+	}
+
+	redirectURI, ok := auth.MatchRedirectURI(req.Client.RedirectUris, v.Get("redirect_uri"))
+	if !ok {
+		return req, "invalid_request", errors.New("redirect_uri is not registered for this client")
+	}
+
+	// from here on errors go back to the client
+	req.RedirectURI = redirectURI
+	req.State = v.Get("state")
+
+	if v.Get("response_type") != "code" {
+		return req, "unsupported_response_type", errors.New("Only the code response type is supported")
+	}
+
+	// PKCE is required for every client, with S256 only
+	req.CodeChallenge = v.Get("code_challenge")
+	if req.CodeChallenge == "" || v.Get("code_challenge_method") != "S256" {
+		return req, "invalid_request", errors.New("code_challenge with code_challenge_method S256 is required")
+	}
+
+	req.Scopes, ok = auth.GrantScopes(req.Client.Scopes, auth.ParseScope(v.Get("scope")))
+	if !ok {
+		return req, "invalid_scope", errors.New("Requested scope is not allowed for this client")
+	}
+
+	return req, "", nil
+}
+
+// render-consent
+func renderConsent(w http.ResponseWriter, code int, req authorizeRequest, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	consentTemplate.Execute(w, struct {
+		authorizeRequest
+		Scope string
+		Error string
+	}{req, auth.FormatScope(req.Scopes), message})
+}
+
+// authorize
+// Shows the consent screen for an authorization request.
+func (cfg *APIConfig) AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	req, errCode, err := cfg.parseAuthorizeRequest(r.Context(), r.URL.Query())
+	if err != nil {
+		if req.RedirectURI == "" {
+			helpers.RespondWithError(w, 400, err.Error())
+			return
+		}
+		redirectWithParams(w, r, req.RedirectURI, map[string]string{
+			"error":             errCode,
+			"error_description": err.Error(),
+			"state":             req.State,
+		})
+		return
+	}
+
+	renderConsent(w, 200, req, "")
+}
+
+// authorize-consent
+// Handles the consent form. The user signs in either with the form's email
+// and password (plus a 2FA code when enabled) or with a first-party access
+// token in the Authorization header.
+func (cfg *APIConfig) AuthorizeConsentHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if err := r.ParseForm(); err != nil {
+		helpers.RespondWithError(w, 400, "Invalid form", err)
+		return
+	}
+
+	req, errCode, err := cfg.parseAuthorizeRequest(r.Context(), r.PostForm)
+	if err != nil {
+		if req.RedirectURI == "" {
+			helpers.RespondWithError(w, 400, err.Error())
+			return
+		}
+		redirectWithParams(w, r, req.RedirectURI, map[string]string{
+			"error":             errCode,
+			"error_description": err.Error(),
+			"state":             req.State,
+		})
+		return
+	}
+
+	if r.PostForm.Get("decision") != "approve" {
+		redirectWithParams(w, r, req.RedirectURI, map[string]string{
+			"error": "access_denied",
+			"state": req.State,
+		})
+		return
+	}
+
+	// Auth
+	userID, err := cfg.consentUser(r)
+	if err != nil {
+		renderConsent(w, 401, req, err.Error())
+		return
+	}
+
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Error in Code creation", err)
+		return
+	}
+
+	err = cfg.DB.CreateAuthorizationCode(r.Context(), database.CreateAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      req.Client.ID,
+		UserID:        userID,
+		RedirectUri:   req.RedirectURI,
+		Scopes:        req.Scopes,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().UTC().Add(oauthCodeTTL),
+	})
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	redirectWithParams(w, r, req.RedirectURI, map[string]string{
+		"code":  code,
+		"state": req.State,
+	})
+}
+
+// consent-user
+func (cfg *APIConfig) consentUser(r *http.Request) (uuid.UUID, error) {
+	if r.Header.Get("Authorization") != "" {
+		userID, scopes, err := cfg.AuthenticateRequest(r)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		// tokens already delegated to someone else can't delegate further
+		if !scopes.Has(auth.ScopeAll) {
+			return uuid.Nil, errors.New(missingScope(auth.ScopeAll))
+		}
+		return userID, nil
+	}
+
+	user, _, err := cfg.checkLogin(r, r.PostForm.Get("email"), r.PostForm.Get("password"))
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	totp, err := cfg.DB.GetTOTP(r.Context(), user.ID)
+	if err == nil && totp.ConfirmedAt.Valid {
+		if !cfg.MFAAttempts.Allow(user.ID.String()) {
+			return uuid.Nil, errors.New("Too many attempts")
+		}
+		if !cfg.checkSecondFactor(r.Context(), totp, r.PostForm.Get("code"), "") {
+			return uuid.Nil, errors.New("Invalid 2FA code")
+		}
+	}
+
+	return user.ID, nil
+}
+
+// authenticate-oauth-client
+// Confidential clients authenticate with HTTP Basic or client_secret in the
+// form; public clients only identify themselves with client_id.
+func (cfg *APIConfig) authenticateOAuthClient(r *http.Request) (database.OauthClient, error) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	clientID, err := uuid.Parse(id)
+	if err != nil {
+		return database.OauthClient{}, errors.New("Invalid client_id")
+	}
+	client, err := cfg.DB.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, errors.New("Unknown client")
+	}
+
+	if client.SecretHash.Valid {
+		if subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash.String)) != 1 {
+			return database.OauthClient{}, errors.New("Invalid client credentials")
+		}
+	} else if secret != "" {
+		return database.OauthClient{}, errors.New("Public clients have no secret")
+	}
+
+	return client, nil
+}
+
+// oauth-token
+func (cfg *APIConfig) OAuthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	type responseBody struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+		Scope       string `json:"scope"`
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, 400, "invalid_request", "Invalid form", err)
+		return
+	}
+
+	client, err := cfg.authenticateOAuthClient(r)
+	if err != nil {
+		oauthError(w, 401, "invalid_client", err.Error())
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		oauthError(w, 400, "unsupported_grant_type", "Only authorization_code is supported")
+		return
+	}
+
+	code, err := cfg.DB.UseAuthorizationCode(r.Context(), auth.HashToken(r.PostForm.Get("code")))
+	if err != nil {
+		oauthError(w, 400, "invalid_grant", "Invalid or expired code", err)
+		return
+	}
+
+	if code.ClientID != client.ID {
+		oauthError(w, 400, "invalid_grant", "Code was issued to another client")
+		return
+	}
+	if redirectURI := r.PostForm.Get("redirect_uri"); redirectURI != "" && redirectURI != code.RedirectUri {
+		oauthError(w, 400, "invalid_grant", "redirect_uri does not match")
+		return
+	}
+	if !auth.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		oauthError(w, 400, "invalid_grant", "Invalid code_verifier")
+		return
+	}
+
+	tokenID := uuid.New()
+	err = cfg.DB.CreateOAuthAccessToken(r.Context(), database.CreateOAuthAccessTokenParams{
+		ID:        tokenID,
+		ClientID:  client.ID,
+		UserID:    code.UserID,
+		Scopes:    code.Scopes,
+		ExpiresAt: time.Now().UTC().Add(oauthAccessTokenTTL),
+	})
+	if err != nil {
+		oauthError(w, 500, "server_error", "Database error", err)
+		return
+	}
+
+	accessToken, err := cfg.makeOAuthJWT(r.Context(), code.UserID, client.ID, tokenID, code.Scopes, oauthAccessTokenTTL)
+	if err != nil {
+		oauthError(w, 500, "server_error", "Error in Token creation", err)
+		return
+	}
+
+	helpers.RespondWithJSON(w, 200, responseBody{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(oauthAccessTokenTTL.Seconds()),
+		Scope:       auth.FormatScope(code.Scopes)})
+}
+
+// oauth-token-claims
+// Returns the claims of an active access token issued to client, or nil.
+func (cfg *APIConfig) oauthTokenClaims(ctx context.Context, client database.OauthClient, tokenString string) *auth.AccessClaims {
+	claims, err := cfg.validateAccessToken(ctx, tokenString)
+	if err != nil || claims.ClientID != client.ID.String() {
+		return nil
+	}
+
+	tokenID, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return nil
+	}
+	token, err := cfg.DB.GetOAuthAccessToken(ctx, tokenID)
+	if err != nil || token.RevokedAt.Valid {
+		return nil
+	}
+	return claims
+}
+
+// oauth-introspect
+// Clients can only introspect their own tokens; anything else is inactive.
+func (cfg *APIConfig) OAuthIntrospectHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	type responseBody struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope,omitempty"`
+		ClientID  string `json:"client_id,omitempty"`
+		Sub       string `json:"sub,omitempty"`
+		Exp       int64  `json:"exp,omitempty"`
+		Iat       int64  `json:"iat,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+	}
+
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, 400, "invalid_request", "Invalid form", err)
+		return
+	}
+
+	client, err := cfg.authenticateOAuthClient(r)
+	if err != nil {
+		oauthError(w, 401, "invalid_client", err.Error())
+		return
+	}
+
+	claims := cfg.oauthTokenClaims(r.Context(), client, r.PostForm.Get("token"))
+	if claims == nil {
+		helpers.RespondWithJSON(w, 200, responseBody{Active: false})
+		return
+	}
+
+	helpers.RespondWithJSON(w, 200, responseBody{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		Sub:       claims.Subject,
+		Exp:       claims.ExpiresAt.Unix(),
+		Iat:       claims.IssuedAt.Unix(),
+		TokenType: "Bearer"})
+}
+
+// oauth-revoke
+// Always answers 200, whether or not the token was known (RFC 7009).
+func (cfg *APIConfig) OAuthRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, 400, "invalid_request", "Invalid form", err)
+		return
+	}
+
+	client, err := cfg.authenticateOAuthClient(r)
+	if err != nil {
+		oauthError(w, 401, "invalid_client", err.Error())
+		return
+	}
+
+	if claims := cfg.oauthTokenClaims(r.Context(), client, r.PostForm.Get("token")); claims != nil {
+		tokenID, _ := uuid.Parse(claims.ID)
+		if err := cfg.DB.RevokeOAuthAccessToken(r.Context(), tokenID); err != nil {
+			oauthError(w, 503, "temporarily_unavailable", "Database error", err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// oauth-metadata
+func (cfg *APIConfig) OAuthMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	base := cfg.baseURLFor(r)
+	helpers.RespondWithJSON(w, 200, map[string]any{
+		"issuer":                                base,
+		"authorization_endpoint":                base + "/oauth/authorize",
+		"token_endpoint":                        base + "/oauth/token",
+		"introspection_endpoint":                base + "/oauth/introspect",
+		"revocation_endpoint":                   base + "/oauth/revoke",
+		"jwks_uri":                              base + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"scopes_supported":                      auth.PATScopes,
+	})
+}
+
+// create-oauth-client
+func (cfg *APIConfig) CreateOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	type requestBody struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Confidential bool     `json:"confidential"`
+	}
+	type responseBody struct {
+		ClientID     uuid.UUID `json:"client_id"`
+		ClientSecret string    `json:"client_secret,omitempty"`
+		Created_at   time.Time `json:"created_at"`
+		Name         string    `json:"name"`
+		RedirectURIs []string  `json:"redirect_uris"`
+		Scopes       []string  `json:"scopes"`
+	}
+
+	// Parse request
+	params, err := helpers.ParseRequest[requestBody](r)
+	if err != nil {
+		helpers.RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	// Auth
+	userID, scopes, err := cfg.AuthenticateRequest(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+	if !scopes.Has(auth.ScopeAll) {
+		helpers.RespondWithError(w, 403, missingScope(auth.ScopeAll))
+		return
+	}
+
+	// Business logic
+	if params.Name == "" {
+		helpers.RespondWithError(w, 400, "Name is required")
+		return
+	}
+	if len(params.RedirectURIs) == 0 {
+		helpers.RespondWithError(w, 400, "At least one redirect_uri is required")
+		return
+	}
+	for _, uri := range params.RedirectURIs {
+		if err := auth.ValidateRedirectURI(uri); err != nil {
+			helpers.RespondWithError(w, 400, err.Error())
+			return
+		}
+	}
+	if err := auth.ValidatePATScopes(params.Scopes); err != nil {
+		helpers.RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	var secret string
+	var secretHash sql.NullString
+	if params.Confidential {
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			helpers.RespondWithError(w, 500, "Error in Secret creation", err)
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	client, err := cfg.DB.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		OwnerID:      userID,
+		Name:         params.Name,
+		SecretHash:   secretHash,
+		RedirectUris: params.RedirectURIs,
+		Scopes:       params.Scopes,
+	})
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	// the secret is only ever shown once
+	helpers.RespondWithJSON(w, 201, responseBody{
+		ClientID:     client.ID,
+		ClientSecret: secret,
+		Created_at:   client.CreatedAt,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectUris,
+		Scopes:       client.Scopes})
+}
+
+// list-oauth-clients
+func (cfg *APIConfig) ListOAuthClientsHandler(w http.ResponseWriter, r *http.Request) {
+	type responseBody struct {
+		ClientID     uuid.UUID `json:"client_id"`
+		Created_at   time.Time `json:"created_at"`
+		Name         string    `json:"name"`
+		Confidential bool      `json:"confidential"`
+		RedirectURIs []string  `json:"redirect_uris"`
+		Scopes       []string  `json:"scopes"`
+	}
+
+	// Auth
+	userID, scopes, err := cfg.AuthenticateRequest(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+	if !scopes.Has(auth.ScopeAll) {
+		helpers.RespondWithError(w, 403, missingScope(auth.ScopeAll))
+		return
+	}
+
+	clients, err := cfg.DB.ListOAuthClients(r.Context(), userID)
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	responses := make([]responseBody, len(clients))
+	for i, c := range clients {
+		responses[i] = responseBody{
+			ClientID:     c.ID,
+			Created_at:   c.CreatedAt,
+			Name:         c.Name,
+			Confidential: c.SecretHash.Valid,
+			RedirectURIs: c.RedirectUris,
+			Scopes:       c.Scopes,
+		}
+	}
+
+	helpers.RespondWithJSON(w, 200, responses)
+}
+
+// delete-oauth-client
+// Deleting a client also drops its codes and tokens.
+func (cfg *APIConfig) DeleteOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Invalid client ID", err)
+		return
+	}
+
+	// Auth
+	userID, scopes, err := cfg.AuthenticateRequest(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+	if !scopes.Has(auth.ScopeAll) {
+		helpers.RespondWithError(w, 403, missingScope(auth.ScopeAll))
+		return
+	}
+
+	n, err := cfg.DB.DeleteOAuthClient(r.Context(), database.DeleteOAuthClientParams{
+		ID:      clientID,
+		OwnerID: userID,
+	})
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+	if n == 0 {
+		helpers.RespondWithError(w, 404, "Client not found")
+		return
+	}
+
+	helpers.RespondNoContent(w)
+}
@@ -0,0 +1,235 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/helpers"
+	"github.com/Johnermac/http-server/internal/oidc"
+)
+
+const (
+	oidcStateTTL    = 10 * time.Minute
+	oidcStateCookie = "chirpy_oidc_state"
+)
+
+// oidc-redirect-url
+func (cfg *APIConfig) oidcRedirectURL(r *http.Request, provider string) string {
+	return cfg.baseURLFor(r) + "/api/auth/oidc/" + provider + "/callback"
+}
+
+// oidc-start
+// Sends the browser to the provider. The state is also kept in a cookie so
+// the callback only completes in the browser that started the login.
+func (cfg *APIConfig) OIDCStartHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("provider")
+	provider, ok := cfg.OIDCProviders[name]
+	if !ok {
+		helpers.RespondWithError(w, 404, "Unknown provider")
+		return
+	}
+
+	state, err := auth.MakeRefreshToken()
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Error starting login", err)
+		return
+	}
+	nonce, err := auth.MakeRefreshToken()
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Error starting login", err)
+		return
+	}
+	verifier, err := auth.MakeRefreshToken()
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Error starting login", err)
+		return
+	}
+
+	err = cfg.DB.CreateOIDCLoginState(r.Context(), database.CreateOIDCLoginStateParams{
+		StateHash:    auth.HashToken(state),
+		Provider:     name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().UTC().Add(oidcStateTTL),
+	})
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), cfg.oidcRedirectURL(r, name), state, nonce, auth.PKCEChallenge(verifier))
+	if err != nil {
+		helpers.RespondWithError(w, 502, "Identity provider unavailable", fmt.Errorf("oidc %s: %w", name, err))
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc/",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.baseURLFor(r), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidc-callback
+func (cfg *APIConfig) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("provider")
+	provider, ok := cfg.OIDCProviders[name]
+	if !ok {
+		helpers.RespondWithError(w, 404, "Unknown provider")
+		return
+	}
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		helpers.RespondWithError(w, 401, "Identity provider returned "+e)
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		helpers.RespondWithError(w, 400, "Invalid login state")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/auth/oidc/", MaxAge: -1})
+
+	loginState, err := cfg.DB.UseOIDCLoginState(r.Context(), database.UseOIDCLoginStateParams{
+		StateHash: auth.HashToken(state),
+		Provider:  name,
+	})
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Invalid or expired login state", err)
+		return
+	}
+
+	rawIDToken, err := provider.Exchange(r.Context(), cfg.oidcRedirectURL(r, name), query.Get("code"), loginState.CodeVerifier)
+	if err != nil {
+		helpers.RespondWithError(w, 401, "Unauthorized", fmt.Errorf("oidc %s: %w", name, err))
+		return
+	}
+
+	claims, err := provider.VerifyIDToken(r.Context(), rawIDToken, loginState.Nonce)
+	if err != nil {
+		helpers.RespondWithError(w, 401, "Unauthorized", fmt.Errorf("oidc %s: invalid id token: %w", name, err))
+		return
+	}
+
+	user, lerr := cfg.oidcUser(r.Context(), name, claims)
+	if lerr != nil {
+		helpers.RespondWithError(w, lerr.code, lerr.msg, lerr.cause)
+		return
+	}
+
+	cfg.completeLogin(w, r, user)
+}
+
+// oidcLoginError is why an OIDC login was refused: code and msg are what
+// the client gets, cause is only logged.
+type oidcLoginError struct {
+	code  int
+	msg   string
+	cause error
+}
+
+// oidc-user
+// Finds the user linked to the provider identity. An unknown identity is
+// linked to the account with the same email, or gets a new account; both
+// only when the provider vouches for the email.
+func (cfg *APIConfig) oidcUser(ctx context.Context, provider string, claims *oidc.IDTokenClaims) (database.User, *oidcLoginError) {
+	identity, err := cfg.DB.GetUserIdentity(ctx, database.GetUserIdentityParams{
+		Provider: provider,
+		Subject:  claims.Subject,
+	})
+	if err == nil {
+		user, err := cfg.DB.GetUser(ctx, identity.UserID)
+		if err != nil {
+			return user, &oidcLoginError{500, "Database error", err}
+		}
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, &oidcLoginError{500, "Database error", err}
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return database.User{}, &oidcLoginError{403, "Identity provider did not return a verified email", nil}
+	}
+	if err := helpers.ValidateEmail(claims.Email); err != nil {
+		return database.User{}, &oidcLoginError{403, err.Error(), nil}
+	}
+
+	user, err := cfg.DB.GetUserByEmail(ctx, claims.Email)
+	switch {
+	case err == nil:
+		// whoever registered an unverified address may not own it
+		if !user.EmailVerifiedAt.Valid {
+			return user, &oidcLoginError{409, "An account with this email exists but its email is not verified", nil}
+		}
+
+	case errors.Is(err, sql.ErrNoRows):
+		user, err = cfg.createOIDCUser(ctx, claims.Email)
+		if err != nil {
+			return user, &oidcLoginError{500, "Create user error", err}
+		}
+
+	default:
+		return user, &oidcLoginError{500, "Database error", err}
+	}
+
+	err = cfg.DB.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	})
+	if err != nil {
+		return user, &oidcLoginError{500, "Database error", err}
+	}
+
+	return user, nil
+}
+
+// create-oidc-user
+// The account gets a random password nobody knows; the user can set one
+// through the password reset flow.
+func (cfg *APIConfig) createOIDCUser(ctx context.Context, email string) (database.User, error) {
+	password, err := auth.MakeRefreshToken()
+	if err != nil {
+		return database.User{}, err
+	}
+	hash, err := cfg.hashPassword(ctx, password)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	user, err := cfg.DB.CreateUser(ctx, database.CreateUserParams{
+		Email:          email,
+		HashedPassword: hash,
+	})
+	if err != nil {
+		return user, err
+	}
+
+	err = cfg.DB.VerifyUserEmail(ctx, database.VerifyUserEmailParams{
+		ID:    user.ID,
+		Email: email,
+	})
+	if err != nil {
+		return user, err
+	}
+
+	return cfg.DB.GetUser(ctx, user.ID)
+}
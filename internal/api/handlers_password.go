@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/helpers"
+	"github.com/Johnermac/http-server/internal/mailer"
+	"github.com/Johnermac/http-server/internal/passwordpolicy"
+)
+
+// forgot-password
+func (cfg *APIConfig) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	type requestBody struct {
+		Email string `json:"email"`
+	}
+
+	// Parse request
+	params, err := helpers.ParseRequest[requestBody](r)
+	if err != nil {
+		helpers.RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	if !cfg.PasswordResetByIP.Allow(helpers.ClientIP(r)) {
+		helpers.RespondWithError(w, 429, "Too many requests")
+		return
+	}
+
+	// The lookup and the email happen in the background so the response is
+	// identical, in content and timing, whether or not the email exists.
+	email := strings.TrimSpace(params.Email)
+	if cfg.PasswordResetByEmail.Allow(strings.ToLower(email)) {
+		base := cfg.baseURLFor(r)
+		go cfg.sendPasswordReset(context.WithoutCancel(r.Context()), base, email)
+	}
+
+	helpers.RespondWithJSON(w, 202, map[string]string{
+		"message": "If that email is registered, a reset link has been sent",
+	})
+}
+
+// send-password-reset
+// ctx is the request's, kept for logging after the response is sent.
+func (cfg *APIConfig) sendPasswordReset(ctx context.Context, base, email string) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	user, err := cfg.DB.GetUserByEmail(ctx, email)
+	if err != nil {
+		return
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		cfg.logger(ctx).ErrorContext(ctx, "password reset", "user_id", user.ID, "error", err)
+		return
+	}
+
+	err = cfg.DB.CreatePasswordReset(ctx, database.CreatePasswordResetParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+	})
+	if err != nil {
+		cfg.logger(ctx).ErrorContext(ctx, "password reset", "user_id", user.ID, "error", err)
+		return
+	}
+
+	err = cfg.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\n"+
+			"To choose a new password, send this token to %s/api/password/reset:\n\n%s\n\n"+
+			"The token expires in one hour and can only be used once. "+
+			"If you didn't ask for this you can ignore this email.\n", base, token),
+	})
+	if err != nil {
+		cfg.logger(ctx).ErrorContext(ctx, "password reset", "user_id", user.ID, "error", err)
+	}
+}
+
+// reset-password
+func (cfg *APIConfig) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	type requestBody struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	// Parse request
+	params, err := helpers.ParseRequest[requestBody](r)
+	if err != nil {
+		helpers.RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	if !cfg.PasswordResetByIP.Allow(helpers.ClientIP(r)) {
+		helpers.RespondWithError(w, 429, "Too many requests")
+		return
+	}
+
+	// checked before the token is used up, so a rejected password can be retried
+	email, err := cfg.DB.GetPasswordResetEmail(r.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Invalid or expired token", err)
+		return
+	}
+	if !cfg.checkPasswordPolicy(w, params.Password, email) {
+		return
+	}
+
+	// hashed before the token is used up, so a failure here can be retried
+	hash, err := cfg.hashPassword(r.Context(), params.Password)
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Error with Hash Password", err)
+		return
+	}
+
+	// all or nothing: a used token always comes with the new password and
+	// every session revoked
+	err = cfg.withTx(r.Context(), func(q *database.Queries) error {
+		// single-use: fails if the token was already consumed or expired
+		reset, err := q.UsePasswordReset(r.Context(), auth.HashToken(params.Token))
+		if err != nil {
+			return err
+		}
+
+		err = q.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+			ID:             reset.UserID,
+			HashedPassword: hash,
+		})
+		if err != nil {
+			return err
+		}
+
+		// any other outstanding reset links and every session are now stale
+		if err := q.InvalidatePasswordResets(r.Context(), reset.UserID); err != nil {
+			return err
+		}
+		return q.RevokeAllRefreshTokens(r.Context(), reset.UserID)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.RespondWithError(w, 400, "Invalid or expired token", err)
+		return
+	}
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	helpers.RespondNoContent(w)
+}
+
+// check-password-policy
+// Responds with every broken rule and returns false if the password is not acceptable.
+func (cfg *APIConfig) checkPasswordPolicy(w http.ResponseWriter, password, email string) bool {
+	type responseBody struct {
+		Error      string                     `json:"error"`
+		Violations []passwordpolicy.Violation `json:"violations"`
+	}
+
+	violations := cfg.PasswordPolicy.Check(password, email)
+	if len(violations) == 0 {
+		return true
+	}
+
+	helpers.RespondWithJSON(w, 400, responseBody{
+		Error:      "Password does not meet the password policy",
+		Violations: violations})
+	return false
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/helpers"
+	"github.com/google/uuid"
+)
+
+// list-sessions
+func (cfg *APIConfig) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	type responseBody struct {
+		Id           uuid.UUID `json:"id"`
+		Created_at   time.Time `json:"created_at"`
+		Last_used_at time.Time `json:"last_used_at"`
+		Expires_at   time.Time `json:"expires_at"`
+		User_agent   string    `json:"user_agent"`
+		Ip           string    `json:"ip"`
+		Current      bool      `json:"current"`
+	}
+
+	// Auth
+	userID, sessionID, err := cfg.authenticateSession(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+
+	sessions, err := cfg.DB.GetActiveSessions(r.Context(), userID)
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	responses := make([]responseBody, len(sessions))
+	for i, s := range sessions {
+		responses[i] = responseBody{
+			Id:           s.FamilyID,
+			Created_at:   s.CreatedAt,
+			Last_used_at: s.LastUsedAt,
+			Expires_at:   s.ExpiresAt,
+			User_agent:   s.UserAgent,
+			Ip:           s.Ip,
+			Current:      s.FamilyID == sessionID,
+		}
+	}
+
+	helpers.RespondWithJSON(w, 200, responses)
+}
+
+// revoke-session
+func (cfg *APIConfig) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Invalid session ID", err)
+		return
+	}
+
+	// Auth
+	userID, scopes, err := cfg.AuthenticateRequest(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+	if !scopes.Has(auth.ScopeAll) {
+		helpers.RespondWithError(w, 403, missingScope(auth.ScopeAll))
+		return
+	}
+
+	n, err := cfg.DB.RevokeUserSession(r.Context(), database.RevokeUserSessionParams{
+		UserID:   userID,
+		FamilyID: sessionID,
+	})
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+	if n == 0 {
+		helpers.RespondWithError(w, 404, "Session not found")
+		return
+	}
+
+	helpers.RespondNoContent(w)
+}
+
+// revoke-other-sessions
+// "Log out everywhere else": keeps only the session of the calling access token.
+func (cfg *APIConfig) RevokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	// Auth
+	userID, sessionID, err := cfg.authenticateSession(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+	if sessionID == uuid.Nil {
+		helpers.RespondWithError(w, 400, "Access token is not tied to a session")
+		return
+	}
+
+	err = cfg.DB.RevokeOtherSessions(r.Context(), database.RevokeOtherSessionsParams{
+		UserID:   userID,
+		FamilyID: sessionID,
+	})
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	helpers.RespondNoContent(w)
+}
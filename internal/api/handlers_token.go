@@ -1,46 +1,106 @@
 package api
 
 import (
+	"database/sql"
 	"net/http"
+	"time"
 
 	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
 	"github.com/Johnermac/http-server/internal/helpers"
 )
 
 // refresh-token
+// Every call rotates the refresh token. Presenting a token that was already
+// rotated means it leaked, so the whole family is revoked.
 func (cfg *APIConfig) UpdateTokenHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	type responseBody struct {
-		Token string `json:"token"`
+		Token         string `json:"token"`
+		Refresh_token string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		helpers.RespondWithError(w, 401, "Unauthorized")
+		helpers.RespondWithError(w, 401, "Unauthorized", err)
 		return
 	}
 
 	// validate the refreshToken in the databse
-	token, err := cfg.DB.GetRefreshToken(r.Context(), refreshToken)
+	token, err := cfg.DB.GetRefreshToken(r.Context(), auth.HashToken(refreshToken))
 	if err != nil {
+		helpers.RespondWithError(w, 401, "Unauthorized", err)
+		return
+	}
+
+	if token.RevokedAt.Valid {
+		if token.ReplacedBy.Valid {
+			cfg.revokeTokenFamily(r, token)
+		}
+		helpers.RespondWithError(w, 401, "Unauthorized")
+		return
+	}
+	if !token.ExpiresAt.After(time.Now()) {
 		helpers.RespondWithError(w, 401, "Unauthorized")
 		return
 	}
 
-	tokenString, err := auth.MakeJWT(
+	tokenString, err := cfg.makeSessionJWT(
+		r.Context(),
 		token.UserID,
-		cfg.JWTSecret,
+		token.FamilyID,
 	)
 
 	if err != nil {
-		helpers.RespondWithError(w, 500, "Error in Token creation")
+		helpers.RespondWithError(w, 500, "Error in Token creation", err)
+		return
+	}
+
+	// get the next refresh token in the same family
+	newRefreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Error in Refresh Token creation", err)
+		return
+	}
+
+	_, err = cfg.DB.InsertRefreshToken(r.Context(), database.InsertRefreshTokenParams{
+		TokenHash: auth.HashToken(newRefreshToken),
+		UserID:    token.UserID,
+		FamilyID:  token.FamilyID,
+		UserAgent: r.UserAgent(),
+		Ip:        helpers.ClientIP(r),
+	})
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Error in Refresh Token creation", err)
+		return
+	}
+
+	// only succeeds for the first of two concurrent uses of the same token
+	_, err = cfg.DB.UpdateRevokeAt(r.Context(), database.UpdateRevokeAtParams{
+		TokenHash:  token.TokenHash,
+		ReplacedBy: sql.NullString{String: auth.HashToken(newRefreshToken), Valid: true},
+	})
+	if err != nil {
+		cfg.revokeTokenFamily(r, token)
+		helpers.RespondWithError(w, 401, "Unauthorized")
 		return
 	}
 
 	// Do something with responseBody
 	helpers.RespondWithJSON(w, 200, responseBody{
-		Token: tokenString})
+		Token:         tokenString,
+		Refresh_token: newRefreshToken})
+}
+
+// revoke-token-family
+func (cfg *APIConfig) revokeTokenFamily(r *http.Request, token database.RefreshToken) {
+	logger := cfg.logger(r.Context())
+	logger.WarnContext(r.Context(), "refresh token reuse detected, revoking family", "user_id", token.UserID, "family_id", token.FamilyID)
+
+	if err := cfg.DB.RevokeRefreshTokenFamily(r.Context(), token.FamilyID); err != nil {
+		logger.ErrorContext(r.Context(), "revoke refresh token family", "family_id", token.FamilyID, "error", err)
+	}
 }
 
 // revoke-refresh-token
@@ -49,13 +109,15 @@ func (cfg *APIConfig) RevokeTokenHandler(w http.ResponseWriter, r *http.Request)
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		helpers.RespondWithError(w, 401, "Unauthorized")
+		helpers.RespondWithError(w, 401, "Unauthorized", err)
 		return
 	}
 
-	_, err = cfg.DB.UpdateRevokeAt(r.Context(), refreshToken)
+	_, err = cfg.DB.UpdateRevokeAt(r.Context(), database.UpdateRevokeAtParams{
+		TokenHash: auth.HashToken(refreshToken),
+	})
 	if err != nil {
-		helpers.RespondWithError(w, 401, "Unauthorized")
+		helpers.RespondWithError(w, 401, "Unauthorized", err)
 		return
 	}
 
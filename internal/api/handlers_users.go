@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
@@ -18,11 +19,13 @@ func (cfg *APIConfig) CreateUserHandler(w http.ResponseWriter, r *http.Request)
 		Password string `json:"password"`
 	}
 	type responseBody struct {
-		Id          uuid.UUID `json:"id"`
-		Created_at  time.Time `json:"created_at"`
-		Updated_at  time.Time `json:"updated_at"`
-		Email       string    `json:"email"`
-		IsChirpyRed bool      `json:"is_chirpy_red"`
+		Id            uuid.UUID `json:"id"`
+		Created_at    time.Time `json:"created_at"`
+		Updated_at    time.Time `json:"updated_at"`
+		Email         string    `json:"email"`
+		IsChirpyRed   bool      `json:"is_chirpy_red"`
+		EmailVerified bool      `json:"email_verified"`
+		Role          string    `json:"role"`
 	}
 
 	// Parse request
@@ -32,9 +35,17 @@ func (cfg *APIConfig) CreateUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	hash, err := auth.HashPassword(params.Password)
+	if err := helpers.ValidateEmail(params.Email); err != nil {
+		helpers.RespondWithError(w, 400, err.Error())
+		return
+	}
+	if !cfg.checkPasswordPolicy(w, params.Password, params.Email) {
+		return
+	}
+
+	hash, err := cfg.hashPassword(r.Context(), params.Password)
 	if err != nil {
-		helpers.RespondWithError(w, 500, "Error with Hash Password")
+		helpers.RespondWithError(w, 500, "Error with Hash Password", err)
 		return
 	}
 
@@ -43,19 +54,26 @@ func (cfg *APIConfig) CreateUserHandler(w http.ResponseWriter, r *http.Request)
 		HashedPassword: hash,
 	})
 	if err != nil {
-		helpers.RespondWithError(w, 500, "Create user error")
+		helpers.RespondWithError(w, 500, "Create user error", err)
 		return
 	}
 
 	//fmt.Println("User: %v has been created in DB", user)
 
+	// a failed email doesn't fail signup, the user can ask for a resend
+	if err := cfg.sendVerificationEmail(r.Context(), cfg.baseURLFor(r), user); err != nil {
+		cfg.logger(r.Context()).ErrorContext(r.Context(), "send verification email", "user_id", user.ID, "error", err)
+	}
+
 	// Do something with requestBody
 	helpers.RespondWithJSON(w, 201, responseBody{
-		Id:          user.ID,
-		Created_at:  user.CreatedAt,
-		Updated_at:  user.UpdatedAt,
-		Email:       user.Email,
-		IsChirpyRed: user.IsChirpyRed})
+		Id:            user.ID,
+		Created_at:    user.CreatedAt,
+		Updated_at:    user.UpdatedAt,
+		Email:         user.Email,
+		IsChirpyRed:   cfg.isChirpyRed(r.Context(), user.ID),
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		Role:          user.Role})
 }
 
 // update-user
@@ -66,11 +84,13 @@ func (cfg *APIConfig) UpdateUserHandler(w http.ResponseWriter, r *http.Request)
 		Password string `json:"password"`
 	}
 	type responseBody struct {
-		Id          uuid.UUID `json:"id"`
-		Created_at  time.Time `json:"created_at"`
-		Updated_at  time.Time `json:"updated_at"`
-		Email       string    `json:"email"`
-		IsChirpyRed bool      `json:"is_chirpy_red"`
+		Id            uuid.UUID `json:"id"`
+		Created_at    time.Time `json:"created_at"`
+		Updated_at    time.Time `json:"updated_at"`
+		Email         string    `json:"email"`
+		IsChirpyRed   bool      `json:"is_chirpy_red"`
+		EmailVerified bool      `json:"email_verified"`
+		Role          string    `json:"role"`
 	}
 
 	// Parse request
@@ -81,15 +101,34 @@ func (cfg *APIConfig) UpdateUserHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Auth
-	userID, err := cfg.AuthenticateRequest(r)
+	userID, scopes, err := cfg.AuthenticateRequest(r)
 	if err != nil {
 		helpers.RespondWithError(w, 401, err.Error())
 		return
 	}
+	// credentials are the keys to the account, not part of the profile
+	if !scopes.Has(auth.ScopeAll) {
+		helpers.RespondWithError(w, 403, missingScope(auth.ScopeAll))
+		return
+	}
 
-	hash, err := auth.HashPassword(params.Password)
+	if err := helpers.ValidateEmail(params.Email); err != nil {
+		helpers.RespondWithError(w, 400, err.Error())
+		return
+	}
+	if !cfg.checkPasswordPolicy(w, params.Password, params.Email) {
+		return
+	}
+
+	previous, err := cfg.DB.GetUser(r.Context(), userID)
 	if err != nil {
-		helpers.RespondWithError(w, 500, "Error with Hash Password")
+		helpers.RespondWithError(w, 401, "Update user error", err)
+		return
+	}
+
+	hash, err := cfg.hashPassword(r.Context(), params.Password)
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Error with Hash Password", err)
 		return
 	}
 
@@ -99,17 +138,26 @@ func (cfg *APIConfig) UpdateUserHandler(w http.ResponseWriter, r *http.Request)
 		HashedPassword: hash,
 	})
 	if err != nil {
-		helpers.RespondWithError(w, 401, "Update user error")
+		helpers.RespondWithError(w, 401, "Update user error", err)
 		return
 	}
 
+	// changing the email resets verification
+	if user.Email != previous.Email {
+		if err := cfg.sendVerificationEmail(r.Context(), cfg.baseURLFor(r), user); err != nil {
+			cfg.logger(r.Context()).ErrorContext(r.Context(), "send verification email", "user_id", user.ID, "error", err)
+		}
+	}
+
 	// Do something with requestBody
 	helpers.RespondWithJSON(w, 200, responseBody{
-		Id:          user.ID,
-		Created_at:  user.CreatedAt,
-		Updated_at:  user.UpdatedAt,
-		Email:       user.Email,
-		IsChirpyRed: user.IsChirpyRed})
+		Id:            user.ID,
+		Created_at:    user.CreatedAt,
+		Updated_at:    user.UpdatedAt,
+		Email:         user.Email,
+		IsChirpyRed:   cfg.isChirpyRed(r.Context(), user.ID),
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		Role:          user.Role})
 }
 
 // login-user
@@ -120,15 +168,6 @@ func (cfg *APIConfig) LoginUserHandler(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 		// Expires_in_seconds  int `json:"expires_in_seconds "`
 	}
-	type responseBody struct {
-		Id            uuid.UUID `json:"id"`
-		Created_at    time.Time `json:"created_at"`
-		Updated_at    time.Time `json:"updated_at"`
-		Email         string    `json:"email"`
-		Token         string    `json:"token"`
-		Refresh_token string    `json:"refresh_token"`
-		IsChirpyRed   bool      `json:"is_chirpy_red"`
-	}
 
 	// Parse request
 	params, err := helpers.ParseRequest[requestBody](r)
@@ -137,40 +176,97 @@ func (cfg *APIConfig) LoginUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.DB.GetUserByEmail(r.Context(), params.Email)
+	user, wait, err := cfg.checkLogin(r, params.Email, params.Password)
 	if err != nil {
-		helpers.RespondWithError(w, 404, "User not Found")
+		result := "failed"
+		if errors.Is(err, errTooManyAttempts) {
+			result = "blocked"
+		}
+		cfg.Metrics.logins.WithLabelValues(result).Inc()
+
+		respondLoginError(w, wait, err)
 		return
 	}
 
-	err = auth.CheckPasswordHash(params.Password, user.HashedPassword)
-	if err != nil {
-		helpers.RespondWithError(w, 401, "Unauthorized")
+	cfg.completeLogin(w, r, user)
+}
+
+// complete-login
+// Called once the user proved who they are with a first factor: hands out an
+// MFA challenge when 2FA is enabled, the tokens otherwise.
+func (cfg *APIConfig) completeLogin(w http.ResponseWriter, r *http.Request, user database.User) {
+	type mfaResponseBody struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+
+	// 2FA: hand out a challenge instead of tokens
+	totp, err := cfg.DB.GetTOTP(r.Context(), user.ID)
+	if err == nil && totp.ConfirmedAt.Valid {
+		mfaToken, err := auth.MakeMFAToken(user.ID, cfg.JWTSecret, mfaTokenTTL)
+		if err != nil {
+			helpers.RespondWithError(w, 500, "Error in Token creation", err)
+			return
+		}
+		cfg.Metrics.logins.WithLabelValues("mfa_required").Inc()
+		helpers.RespondWithJSON(w, 200, mfaResponseBody{
+			MFARequired: true,
+			MFAToken:    mfaToken})
 		return
 	}
 
-	tokenString, err := auth.MakeJWT(
+	cfg.respondWithLogin(w, r, user)
+}
+
+// respond-with-login
+// Issues an access and a refresh token for a fully authenticated user.
+func (cfg *APIConfig) respondWithLogin(w http.ResponseWriter, r *http.Request, user database.User) {
+	type responseBody struct {
+		Id            uuid.UUID `json:"id"`
+		Created_at    time.Time `json:"created_at"`
+		Updated_at    time.Time `json:"updated_at"`
+		Email         string    `json:"email"`
+		Token         string    `json:"token"`
+		Refresh_token string    `json:"refresh_token"`
+		IsChirpyRed   bool      `json:"is_chirpy_red"`
+		EmailVerified bool      `json:"email_verified"`
+		Role          string    `json:"role"`
+	}
+
+	// a new login starts a new session (refresh token rotation family)
+	sessionID := uuid.New()
+
+	tokenString, err := cfg.makeSessionJWT(
+		r.Context(),
 		user.ID,
-		cfg.JWTSecret,
+		sessionID,
 	)
 
 	if err != nil {
-		helpers.RespondWithError(w, 500, "Error in Token creation")
+		helpers.RespondWithError(w, 500, "Error in Token creation", err)
 		return
 	}
 
 	// get refresh token
 	refreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		helpers.RespondWithError(w, 500, "Error in Refresh Token creation")
+		helpers.RespondWithError(w, 500, "Error in Refresh Token creation", err)
 		return
 	}
 
 	// save-refresh-token in DB
-	cfg.DB.InsertRefreshToken(r.Context(), database.InsertRefreshTokenParams{
-		Token:  refreshToken,
-		UserID: user.ID,
+	_, err = cfg.DB.InsertRefreshToken(r.Context(), database.InsertRefreshTokenParams{
+		TokenHash: auth.HashToken(refreshToken),
+		UserID:    user.ID,
+		FamilyID:  sessionID,
+		UserAgent: r.UserAgent(),
+		Ip:        helpers.ClientIP(r),
 	})
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Error in Refresh Token creation", err)
+		return
+	}
+	cfg.Metrics.logins.WithLabelValues("success").Inc()
 
 	// Do something with requestBody
 	helpers.RespondWithJSON(w, 200, responseBody{
@@ -180,19 +276,18 @@ func (cfg *APIConfig) LoginUserHandler(w http.ResponseWriter, r *http.Request) {
 		Email:         user.Email,
 		Token:         tokenString,
 		Refresh_token: refreshToken,
-		IsChirpyRed:   user.IsChirpyRed})
+		IsChirpyRed:   cfg.isChirpyRed(r.Context(), user.ID),
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		Role:          user.Role})
 }
 
 // delete-all-users
 func (cfg *APIConfig) DeleteAllUsersHandler(w http.ResponseWriter, r *http.Request) {
-	// reset counting
-	cfg.FileserverHits.Swap(0)
-
 	// reset User in DB
 	if cfg.Platform == "dev" {
 		err := cfg.DB.DeleteAllUsers(r.Context())
 		if err != nil {
-			helpers.RespondWithError(w, 500, "Error Deleting Users")
+			helpers.RespondWithError(w, 500, "Error Deleting Users", err)
 			return
 		}
 		helpers.RespondWithJSON(w, 200, "All Users Deleted")
@@ -203,46 +298,3 @@ func (cfg *APIConfig) DeleteAllUsersHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 }
-
-// update-premium-user
-func (cfg *APIConfig) UpdatePremiumUserHandler(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
-	type requestBody struct {
-		Event string `json:"event"`
-		Data  struct {
-			UserID string `json:"user_id"`
-		} `json:"data"`
-	}
-
-	// Parse request
-	params, err := helpers.ParseRequest[requestBody](r)
-	if err != nil {
-		helpers.RespondWithError(w, 400, err.Error())
-		return
-	}
-
-	if params.Event != "user.upgraded" {
-		helpers.RespondNoContent(w)
-		return
-	}
-
-	key, err := auth.GetAPIKey(r.Header)
-	if err != nil || key != cfg.Polka_KEY {
-		helpers.RespondWithError(w, 401, "Unauthorized")
-		return
-	}
-
-	userID, _ := uuid.Parse(params.Data.UserID)
-
-	err = cfg.DB.UpdatePremiumUser(r.Context(), database.UpdatePremiumUserParams{
-		ID:          userID,
-		IsChirpyRed: true,
-	})
-	if err != nil {
-		helpers.RespondWithError(w, 404, "User not found")
-		return
-	}
-
-	// Respond with 204
-	helpers.RespondNoContent(w)
-}
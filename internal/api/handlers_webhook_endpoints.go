@@ -0,0 +1,385 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/helpers"
+	"github.com/Johnermac/http-server/internal/webhook"
+	"github.com/google/uuid"
+)
+
+const (
+	webhookDeliveriesDefaultLimit = 50
+	webhookDeliveriesMaxLimit     = 500
+)
+
+// webhookEndpointResponse is how an endpoint is shown to its owner; the
+// secret only when it's created.
+type webhookEndpointResponse struct {
+	Id         uuid.UUID `json:"id"`
+	Created_at time.Time `json:"created_at"`
+	Url        string    `json:"url"`
+	Events     []string  `json:"events"`
+	All_users  bool      `json:"all_users"`
+	Secret     string    `json:"secret,omitempty"`
+}
+
+// webhook-endpoint-response
+func newWebhookEndpointResponse(e database.WebhookEndpoint) webhookEndpointResponse {
+	return webhookEndpointResponse{
+		Id:         e.ID,
+		Created_at: e.CreatedAt,
+		Url:        e.Url,
+		Events:     e.Events,
+		All_users:  e.AllUsers,
+	}
+}
+
+// webhookDeliveryResponse is an entry of an endpoint's delivery log.
+type webhookDeliveryResponse struct {
+	Id               uuid.UUID       `json:"id"`
+	Created_at       time.Time       `json:"created_at"`
+	Event_id         uuid.UUID       `json:"event_id"`
+	Event_type       string          `json:"event_type"`
+	Payload          json.RawMessage `json:"payload,omitempty"`
+	Status           string          `json:"status"`
+	Attempts         int32           `json:"attempts"`
+	Next_attempt_at  *time.Time      `json:"next_attempt_at"`
+	Last_status_code *int32          `json:"last_status_code"`
+	Last_error       *string         `json:"last_error"`
+	Delivered_at     *time.Time      `json:"delivered_at"`
+}
+
+// webhook-delivery-response
+func newWebhookDeliveryResponse(d database.WebhookDelivery) webhookDeliveryResponse {
+	resp := webhookDeliveryResponse{
+		Id:           d.ID,
+		Created_at:   d.CreatedAt,
+		Event_id:     d.EventID,
+		Event_type:   d.EventType,
+		Status:       d.Status,
+		Attempts:     d.Attempts,
+		Delivered_at: nullTimePtr(d.DeliveredAt),
+	}
+	if d.Status == deliveryStatusPending {
+		resp.Next_attempt_at = &d.NextAttemptAt
+	}
+	if d.LastStatusCode.Valid {
+		resp.Last_status_code = &d.LastStatusCode.Int32
+	}
+	if d.LastError.Valid {
+		resp.Last_error = &d.LastError.String
+	}
+	return resp
+}
+
+// create-webhook-endpoint
+// Any user can subscribe to their own events; only admins can create an
+// endpoint that receives every user's.
+func (cfg *APIConfig) CreateWebhookEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	type requestBody struct {
+		Url      string   `json:"url"`
+		Events   []string `json:"events"`
+		AllUsers bool     `json:"all_users"`
+	}
+
+	// Parse request
+	params, err := helpers.ParseRequest[requestBody](r)
+	if err != nil {
+		helpers.RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	// Auth: only an interactive login can manage webhooks
+	userID, scopes, err := cfg.AuthenticateRequest(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+	if !scopes.Has(auth.ScopeAll) {
+		helpers.RespondWithError(w, 403, "Tokens can't manage webhooks")
+		return
+	}
+	if params.AllUsers {
+		user, err := cfg.DB.GetUser(r.Context(), userID)
+		if err != nil {
+			helpers.RespondWithError(w, 401, "User not found", err)
+			return
+		}
+		if !auth.HasRole(user.Role, auth.RoleAdmin) {
+			helpers.RespondWithError(w, 403, "Only admins can receive all users' events")
+			return
+		}
+	}
+
+	// Business logic
+	if err := webhook.ValidateURL(params.Url, cfg.AllowPrivateWebhooks); err != nil {
+		helpers.RespondWithError(w, 400, err.Error())
+		return
+	}
+	if len(params.Events) == 0 {
+		helpers.RespondWithError(w, 400, "At least one event is required")
+		return
+	}
+	for _, e := range params.Events {
+		if !slices.Contains(outboundWebhookEvents, e) {
+			helpers.RespondWithError(w, 400, fmt.Sprintf("Unknown event %q", e))
+			return
+		}
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Error creating webhook", err)
+		return
+	}
+
+	endpoint, err := cfg.DB.CreateWebhookEndpoint(r.Context(), database.CreateWebhookEndpointParams{
+		OwnerID:  userID,
+		Url:      params.Url,
+		Secret:   secret,
+		Events:   slices.Compact(slices.Sorted(slices.Values(params.Events))),
+		AllUsers: params.AllUsers,
+	})
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Error creating webhook", err)
+		return
+	}
+
+	// the secret is only ever shown once
+	resp := newWebhookEndpointResponse(endpoint)
+	resp.Secret = secret
+	helpers.RespondWithJSON(w, 201, resp)
+}
+
+// list-webhook-endpoints
+func (cfg *APIConfig) ListWebhookEndpointsHandler(w http.ResponseWriter, r *http.Request) {
+	// Auth
+	userID, scopes, err := cfg.AuthenticateRequest(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+	if !scopes.Has(auth.ScopeAll) {
+		helpers.RespondWithError(w, 403, "Tokens can't manage webhooks")
+		return
+	}
+
+	endpoints, err := cfg.DB.ListWebhookEndpoints(r.Context(), userID)
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	responses := make([]webhookEndpointResponse, len(endpoints))
+	for i, e := range endpoints {
+		responses[i] = newWebhookEndpointResponse(e)
+	}
+
+	helpers.RespondWithJSON(w, 200, responses)
+}
+
+// delete-webhook-endpoint
+// Pending deliveries go with it.
+func (cfg *APIConfig) DeleteWebhookEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	endpointID, err := uuid.Parse(r.PathValue("endpointID"))
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Invalid webhook ID", err)
+		return
+	}
+
+	// Auth
+	userID, scopes, err := cfg.AuthenticateRequest(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return
+	}
+	if !scopes.Has(auth.ScopeAll) {
+		helpers.RespondWithError(w, 403, "Tokens can't manage webhooks")
+		return
+	}
+
+	n, err := cfg.DB.DeleteWebhookEndpoint(r.Context(), database.DeleteWebhookEndpointParams{
+		ID:      endpointID,
+		OwnerID: userID,
+	})
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+	if n == 0 {
+		helpers.RespondWithError(w, 404, "Webhook not found")
+		return
+	}
+
+	helpers.RespondNoContent(w)
+}
+
+// owned-webhook-endpoint
+// Authenticates the request and loads the endpoint in the path, which must
+// belong to the caller. Responds and returns false otherwise.
+func (cfg *APIConfig) ownedWebhookEndpoint(w http.ResponseWriter, r *http.Request) (database.WebhookEndpoint, bool) {
+	endpointID, err := uuid.Parse(r.PathValue("endpointID"))
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Invalid webhook ID", err)
+		return database.WebhookEndpoint{}, false
+	}
+
+	// Auth
+	userID, scopes, err := cfg.AuthenticateRequest(r)
+	if err != nil {
+		helpers.RespondWithError(w, 401, err.Error())
+		return database.WebhookEndpoint{}, false
+	}
+	if !scopes.Has(auth.ScopeAll) {
+		helpers.RespondWithError(w, 403, "Tokens can't manage webhooks")
+		return database.WebhookEndpoint{}, false
+	}
+
+	endpoint, err := cfg.DB.GetWebhookEndpoint(r.Context(), endpointID)
+	if err != nil || endpoint.OwnerID != userID {
+		helpers.RespondWithError(w, 404, "Webhook not found")
+		return database.WebhookEndpoint{}, false
+	}
+	return endpoint, true
+}
+
+// list-webhook-deliveries
+// The endpoint's delivery log, newest first. ?limit=50
+func (cfg *APIConfig) ListWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := cfg.ownedWebhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+
+	limit := webhookDeliveriesDefaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > webhookDeliveriesMaxLimit {
+			helpers.RespondWithError(w, 400, fmt.Sprintf("limit must be between 1 and %d", webhookDeliveriesMaxLimit))
+			return
+		}
+		limit = n
+	}
+
+	deliveries, err := cfg.DB.ListWebhookDeliveries(r.Context(), database.ListWebhookDeliveriesParams{
+		EndpointID: endpoint.ID,
+		Limit:      int32(limit),
+	})
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	responses := make([]webhookDeliveryResponse, len(deliveries))
+	for i, d := range deliveries {
+		responses[i] = newWebhookDeliveryResponse(d)
+	}
+
+	helpers.RespondWithJSON(w, 200, responses)
+}
+
+// get-webhook-delivery
+// One delivery with its payload and every attempt.
+func (cfg *APIConfig) GetWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	type attemptBody struct {
+		Attempted_at time.Time `json:"attempted_at"`
+		Status_code  *int32    `json:"status_code"`
+		Response     *string   `json:"response"`
+		Error        *string   `json:"error"`
+		Duration_ms  int32     `json:"duration_ms"`
+	}
+	type responseBody struct {
+		webhookDeliveryResponse
+		Attempt_log []attemptBody `json:"attempt_log"`
+	}
+
+	endpoint, ok := cfg.ownedWebhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+	deliveryID, err := uuid.Parse(r.PathValue("deliveryID"))
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Invalid delivery ID", err)
+		return
+	}
+
+	delivery, err := cfg.DB.GetWebhookDelivery(r.Context(), database.GetWebhookDeliveryParams{
+		ID:         deliveryID,
+		EndpointID: endpoint.ID,
+	})
+	if err != nil {
+		helpers.RespondWithError(w, 404, "Delivery not found", err)
+		return
+	}
+
+	attempts, err := cfg.DB.ListWebhookDeliveryAttempts(r.Context(), delivery.ID)
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	resp := responseBody{
+		webhookDeliveryResponse: newWebhookDeliveryResponse(delivery),
+		Attempt_log:             make([]attemptBody, len(attempts)),
+	}
+	resp.Payload = delivery.Payload
+	for i, a := range attempts {
+		resp.Attempt_log[i] = attemptBody{
+			Attempted_at: a.AttemptedAt,
+			Duration_ms:  a.DurationMs,
+		}
+		if a.StatusCode.Valid {
+			resp.Attempt_log[i].Status_code = &a.StatusCode.Int32
+		}
+		if a.Response.Valid {
+			resp.Attempt_log[i].Response = &a.Response.String
+		}
+		if a.Error.Valid {
+			resp.Attempt_log[i].Error = &a.Error.String
+		}
+	}
+
+	helpers.RespondWithJSON(w, 200, resp)
+}
+
+// redeliver-webhook
+// Queues a delivery again right away, also a succeeded or dead one. A dead
+// delivery gets this one attempt, not a new retry schedule.
+func (cfg *APIConfig) RedeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := cfg.ownedWebhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+	deliveryID, err := uuid.Parse(r.PathValue("deliveryID"))
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Invalid delivery ID", err)
+		return
+	}
+
+	params := database.RedeliverWebhookDeliveryParams{
+		ID:         deliveryID,
+		EndpointID: endpoint.ID,
+	}
+	delivery, err := cfg.DB.RedeliverWebhookDelivery(r.Context(), params)
+	if err != nil {
+		// either it doesn't exist or a worker is sending it right now
+		if _, err := cfg.DB.GetWebhookDelivery(r.Context(), database.GetWebhookDeliveryParams(params)); err != nil {
+			helpers.RespondWithError(w, 404, "Delivery not found", err)
+			return
+		}
+		helpers.RespondWithError(w, 409, "Delivery is in progress")
+		return
+	}
+	cfg.kickWebhookDeliveries(r.Context())
+
+	helpers.RespondWithJSON(w, 202, newWebhookDeliveryResponse(delivery))
+}
@@ -0,0 +1,332 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/billing"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/helpers"
+	"github.com/Johnermac/http-server/internal/outbox"
+	"github.com/Johnermac/http-server/internal/webhook"
+	"github.com/google/uuid"
+)
+
+const (
+	polkaTimestampHeader = "Polka-Timestamp"
+	polkaSignatureHeader = "Polka-Signature"
+
+	webhookProviderPolka = "polka"
+
+	webhookStatusProcessing = "processing"
+	webhookStatusProcessed  = "processed"
+	webhookStatusIgnored    = "ignored"
+	webhookStatusFailed     = "failed"
+
+	// an attempt that hasn't finished by then is presumed dead, and a
+	// redelivery or replay may take the event over
+	webhookEventLease = 5 * time.Minute
+
+	webhookEventsDefaultLimit = 50
+	webhookEventsMaxLimit     = 500
+)
+
+// polkaEvent is the body of a Polka webhook. Subscription events may say
+// which plan and when the paid period ends.
+type polkaEvent struct {
+	ID    string `json:"id"`
+	Event string `json:"event"`
+	Data  struct {
+		UserID           string    `json:"user_id"`
+		Plan             string    `json:"plan"`
+		CurrentPeriodEnd time.Time `json:"current_period_end"`
+	} `json:"data"`
+}
+
+// update-premium-user
+// Polka webhook. The signature is checked over the raw body before anything
+// in it is looked at. Every event is recorded once by its id; a redelivery of
+// an event that was processed (or is being processed) is acknowledged without
+// doing the work again, a redelivery of a failed or stalled one is another
+// attempt.
+func (cfg *APIConfig) UpdatePremiumUserHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	body, err := webhook.ReadBody(r)
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Something went wrong", err)
+		return
+	}
+
+	// Auth
+	err = cfg.PolkaWebhooks.Verify(r.Header.Get(polkaTimestampHeader), r.Header.Get(polkaSignatureHeader), body)
+	if err != nil {
+		helpers.RespondWithError(w, 401, "Unauthorized", fmt.Errorf("polka webhook rejected: %w", err))
+		return
+	}
+
+	// Parse request
+	var params polkaEvent
+	if err := json.Unmarshal(body, &params); err != nil {
+		helpers.RespondWithError(w, 400, "Couldn't unmarshal parameters", err)
+		return
+	}
+
+	if params.ID == "" {
+		helpers.RespondWithError(w, 400, "Missing event id")
+		return
+	}
+	eventID := params.ID
+
+	event, err := cfg.DB.CreateWebhookEvent(r.Context(), database.CreateWebhookEventParams{
+		Provider:  webhookProviderPolka,
+		EventID:   eventID,
+		EventType: params.Event,
+		Payload:   body,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		event, err = cfg.DB.GetWebhookEventByEventID(r.Context(), database.GetWebhookEventByEventIDParams{
+			Provider: webhookProviderPolka,
+			EventID:  eventID,
+		})
+		if err != nil {
+			helpers.RespondWithError(w, 500, "Database error", err)
+			return
+		}
+		if event.Status == webhookStatusProcessed || event.Status == webhookStatusIgnored {
+			helpers.RespondNoContent(w)
+			return
+		}
+
+		event, err = cfg.DB.RetryWebhookEvent(r.Context(), database.RetryWebhookEventParams{
+			ID:          event.ID,
+			StaleBefore: time.Now().Add(-webhookEventLease),
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			// still being processed, or another delivery got to it first
+			helpers.RespondNoContent(w)
+			return
+		}
+	}
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	if code, err := cfg.processWebhookEvent(r.Context(), event); err != nil {
+		if code >= 500 {
+			// Polka retries these; the cause is on the event, not for the sender
+			helpers.RespondWithError(w, code, "Something went wrong", err)
+			return
+		}
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	// Respond with 204
+	helpers.RespondNoContent(w)
+}
+
+// process-webhook-event
+// Runs a claimed event and records the outcome. On failure, returns the
+// status code to answer the provider with.
+func (cfg *APIConfig) processWebhookEvent(ctx context.Context, event database.WebhookEvent) (int, error) {
+	var status string
+	var code int
+	var err error
+
+	switch event.Provider {
+	case webhookProviderPolka:
+		status, code, err = cfg.processPolkaEvent(ctx, event.Payload)
+	default:
+		code, err = 500, fmt.Errorf("Unknown webhook provider %q", event.Provider)
+	}
+
+	params := database.FinishWebhookEventParams{ID: event.ID, Status: status}
+	if err != nil {
+		params.Status = webhookStatusFailed
+		params.LastError = sql.NullString{String: err.Error(), Valid: true}
+		cfg.logger(ctx).ErrorContext(ctx, "webhook event failed", "id", event.ID, "provider", event.Provider, "event_id", event.EventID, "error", err)
+	}
+	cfg.Metrics.webhookEvents.WithLabelValues(event.Provider, params.Status).Inc()
+	if ferr := cfg.DB.FinishWebhookEvent(ctx, params); ferr != nil {
+		cfg.logger(ctx).ErrorContext(ctx, "record webhook event outcome", "id", event.ID, "error", ferr)
+	}
+
+	return code, err
+}
+
+// process-polka-event
+func (cfg *APIConfig) processPolkaEvent(ctx context.Context, payload []byte) (string, int, error) {
+	var params polkaEvent
+	if err := json.Unmarshal(payload, &params); err != nil {
+		return "", 400, errors.New("Couldn't unmarshal parameters")
+	}
+
+	switch billing.NormalizeEventType(params.Event) {
+	case billing.EventUpgraded, billing.EventRenewed, billing.EventDowngraded,
+		billing.EventCanceled, billing.EventPaymentFailed:
+	default:
+		return webhookStatusIgnored, 0, nil
+	}
+
+	userID, err := uuid.Parse(params.Data.UserID)
+	if err != nil {
+		return "", 400, errors.New("Invalid user ID")
+	}
+	if _, err := cfg.DB.GetUser(ctx, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", 404, errors.New("User not found")
+		}
+		return "", 500, fmt.Errorf("get user: %w", err)
+	}
+
+	// the subscription and its event commit together
+	err = cfg.withTx(ctx, func(q *database.Queries) error {
+		sub, err := cfg.applySubscriptionEvent(ctx, q, userID, billing.Event{
+			Type:             params.Event,
+			Plan:             params.Data.Plan,
+			CurrentPeriodEnd: params.Data.CurrentPeriodEnd,
+		})
+		if err != nil {
+			return err
+		}
+
+		if params.Event != billing.EventUpgraded {
+			return nil
+		}
+		return outbox.Write(ctx, q, eventUserUpgraded, userID, userUpgradedEventData{
+			User_id:            userID,
+			Plan:               sub.Plan,
+			Current_period_end: sub.CurrentPeriodEnd,
+		})
+	})
+	if errors.Is(err, billing.ErrInvalidEvent) {
+		return "", 422, err
+	}
+	if err != nil {
+		return "", 500, err
+	}
+
+	return webhookStatusProcessed, 0, nil
+}
+
+// webhookEventResponse is how the admin endpoints show an event.
+type webhookEventResponse struct {
+	Id           uuid.UUID       `json:"id"`
+	Received_at  time.Time       `json:"received_at"`
+	Provider     string          `json:"provider"`
+	Event_id     string          `json:"event_id"`
+	Event_type   string          `json:"event_type"`
+	Payload      json.RawMessage `json:"payload"`
+	Status       string          `json:"status"`
+	Attempts     int32           `json:"attempts"`
+	Last_error   *string         `json:"last_error"`
+	Processed_at *time.Time      `json:"processed_at"`
+}
+
+// webhook-event-response
+func newWebhookEventResponse(e database.WebhookEvent) webhookEventResponse {
+	var lastError *string
+	if e.LastError.Valid {
+		lastError = &e.LastError.String
+	}
+
+	return webhookEventResponse{
+		Id:           e.ID,
+		Received_at:  e.ReceivedAt,
+		Provider:     e.Provider,
+		Event_id:     e.EventID,
+		Event_type:   e.EventType,
+		Payload:      e.Payload,
+		Status:       e.Status,
+		Attempts:     e.Attempts,
+		Last_error:   lastError,
+		Processed_at: nullTimePtr(e.ProcessedAt),
+	}
+}
+
+// list-webhook-events
+// Admin only (see main.go). ?status=failed&limit=50
+func (cfg *APIConfig) ListWebhookEventsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := webhookEventsDefaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > webhookEventsMaxLimit {
+			helpers.RespondWithError(w, 400, fmt.Sprintf("limit must be between 1 and %d", webhookEventsMaxLimit))
+			return
+		}
+		limit = n
+	}
+
+	var events []database.WebhookEvent
+	var err error
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+		events, err = cfg.DB.ListWebhookEvents(r.Context(), int32(limit))
+	case webhookStatusProcessing, webhookStatusProcessed, webhookStatusIgnored, webhookStatusFailed:
+		events, err = cfg.DB.ListWebhookEventsByStatus(r.Context(), database.ListWebhookEventsByStatusParams{
+			Status: status,
+			Limit:  int32(limit),
+		})
+	default:
+		helpers.RespondWithError(w, 400, "Unknown status "+strconv.Quote(status))
+		return
+	}
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	responses := make([]webhookEventResponse, len(events))
+	for i, e := range events {
+		responses[i] = newWebhookEventResponse(e)
+	}
+
+	helpers.RespondWithJSON(w, 200, responses)
+}
+
+// replay-webhook-event
+// Admin only (see main.go). Processes a failed or stalled event again and
+// responds with its new state.
+func (cfg *APIConfig) ReplayWebhookEventHandler(w http.ResponseWriter, r *http.Request) {
+	eventID, err := uuid.Parse(r.PathValue("eventID"))
+	if err != nil {
+		helpers.RespondWithError(w, 400, "Invalid event ID", err)
+		return
+	}
+
+	event, err := cfg.DB.RetryWebhookEvent(r.Context(), database.RetryWebhookEventParams{
+		ID:          eventID,
+		StaleBefore: time.Now().Add(-webhookEventLease),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := cfg.DB.GetWebhookEvent(r.Context(), eventID); err != nil {
+			helpers.RespondWithError(w, 404, "Event not found", err)
+			return
+		}
+		helpers.RespondWithError(w, 409, "Only failed or stalled events can be replayed")
+		return
+	}
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	// the outcome is recorded on the event either way
+	cfg.processWebhookEvent(r.Context(), event)
+
+	event, err = cfg.DB.GetWebhookEvent(r.Context(), eventID)
+	if err != nil {
+		helpers.RespondWithError(w, 500, "Database error", err)
+		return
+	}
+
+	helpers.RespondWithJSON(w, 200, newWebhookEventResponse(event))
+}
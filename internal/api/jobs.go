@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/activitypub"
+	"github.com/Johnermac/http-server/internal/jobs"
+	"github.com/google/uuid"
+)
+
+// finished jobs and published events are kept this long for inspection
+const (
+	jobRetention    = 7 * 24 * time.Hour
+	outboxRetention = 7 * 24 * time.Hour
+)
+
+type expireSubscriptionsJob struct{}
+
+func (expireSubscriptionsJob) Kind() string { return "expire_subscriptions" }
+
+// deliverWebhooksJob sends one batch of due webhook deliveries.
+type deliverWebhooksJob struct{}
+
+func (deliverWebhooksJob) Kind() string { return "deliver_webhooks" }
+
+// a run that is already waiting covers new deliveries too
+var deliverWebhooksOptions = jobs.Options{
+	MaxAttempts: 3,
+	UniqueKey:   deliverWebhooksJob{}.Kind(),
+}
+
+// deliverActivityJob sends one chirp activity to one follower's inbox.
+type deliverActivityJob struct {
+	// Create, Update or Delete
+	Activity string         `json:"activity"`
+	Chirp    chirpEventData `json:"chirp"`
+	Inbox    string         `json:"inbox"`
+}
+
+func (deliverActivityJob) Kind() string { return "deliver_activity" }
+
+// sendActivityJob sends a ready-made activity, like the Accept of a Follow.
+type sendActivityJob struct {
+	UserID uuid.UUID `json:"user_id"`
+	// the server's address as the remote saw it, which the actor's URL
+	// starts with
+	Base     string               `json:"base"`
+	Inbox    string               `json:"inbox"`
+	Activity activitypub.Activity `json:"activity"`
+}
+
+func (sendActivityJob) Kind() string { return "send_activity" }
+
+// federation deliveries are retried for about 20 minutes
+var deliverActivityOptions = jobs.Options{MaxAttempts: 8}
+
+type purgeJobsJob struct{}
+
+func (purgeJobsJob) Kind() string { return "purge_jobs" }
+
+type purgeOutboxJob struct{}
+
+func (purgeOutboxJob) Kind() string { return "purge_outbox" }
+
+// new-worker
+// A worker for every job the app runs, with their schedules.
+func (cfg *APIConfig) NewWorker() (*jobs.Worker, error) {
+	w := jobs.NewWorker(cfg.DB)
+	w.Concurrency = cfg.JobConcurrency
+
+	jobs.Register(w, func(ctx context.Context, _ expireSubscriptionsJob) error {
+		n, err := cfg.ExpireSubscriptions(ctx)
+		if n > 0 {
+			log.Printf("expired %d subscriptions", n)
+		}
+		return err
+	})
+	jobs.Register(w, func(ctx context.Context, _ deliverWebhooksJob) error {
+		n, err := cfg.DeliverWebhooks(ctx)
+		if n == webhookDeliveryBatch {
+			// there may be more
+			cfg.kickWebhookDeliveries(ctx)
+		}
+		return err
+	})
+	jobs.Register(w, cfg.deliverChirpActivity)
+	jobs.Register(w, cfg.sendActivity)
+	jobs.Register(w, func(ctx context.Context, _ purgeJobsJob) error {
+		_, err := cfg.DB.PurgeJobs(ctx, sql.NullTime{Time: time.Now().UTC().Add(-jobRetention), Valid: true})
+		return err
+	})
+	jobs.Register(w, func(ctx context.Context, _ purgeOutboxJob) error {
+		_, err := cfg.DB.PurgeOutboxEvents(ctx, sql.NullTime{Time: time.Now().UTC().Add(-outboxRetention), Valid: true})
+		return err
+	})
+
+	schedules := []struct {
+		name string
+		spec string
+		args jobs.Args
+	}{
+		{"expire-subscriptions", "*/15 * * * *", expireSubscriptionsJob{}},
+		// picks up retries; new events kick off a delivery right away
+		{"deliver-webhooks", "* * * * *", deliverWebhooksJob{}},
+		{"purge-jobs", "@daily", purgeJobsJob{}},
+		{"purge-outbox", "@daily", purgeOutboxJob{}},
+	}
+	for _, s := range schedules {
+		if err := w.Schedule(s.name, s.spec, s.args); err != nil {
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+// kick-webhook-deliveries
+// Queues a delivery run unless one is already waiting.
+func (cfg *APIConfig) kickWebhookDeliveries(ctx context.Context) {
+	_, err := cfg.Jobs.Enqueue(ctx, deliverWebhooksJob{}, deliverWebhooksOptions)
+	if err != nil {
+		log.Printf("enqueue webhook deliveries: %v", err)
+	}
+}
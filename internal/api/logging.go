@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// longest caller-supplied request ID we pass on
+const maxRequestIDLength = 128
+
+type requestInfoKey struct{}
+
+// requestInfo is what the request logger learns while a request runs.
+type requestInfo struct {
+	id     string
+	userID uuid.UUID
+}
+
+// RequestID is the ID of the request ctx belongs to, or "" outside one.
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// set-request-user
+// Records who the request authenticated as, for its log line.
+func setRequestUser(r *http.Request, userID uuid.UUID) {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		info.userID = userID
+	}
+}
+
+// logger
+// cfg.Logger, with the request and trace IDs of the request ctx belongs to,
+// so lines logged while handling it can be matched to its request line.
+func (cfg *APIConfig) logger(ctx context.Context) *slog.Logger {
+	l := cfg.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+	if id := RequestID(ctx); id != "" {
+		l = l.With(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		l = l.With(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return l
+}
+
+// valid-request-id
+// Caller-supplied IDs end up in logs and headers, so only printable ASCII
+// without spaces is kept.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// logRecorder also keeps the error a handler responded with.
+type logRecorder struct {
+	statusRecorder
+	errMsg string
+	cause  error
+}
+
+// record-error
+func (rec *logRecorder) RecordError(msg string, cause error) {
+	rec.errMsg = msg
+	rec.cause = cause
+}
+
+// middleware-logging
+// Gives every request an ID, taken from X-Request-ID when the caller sent a
+// usable one, and logs one line per request when it is done.
+func (cfg *APIConfig) MiddlewareLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		info := &requestInfo{id: id}
+		logged := r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+		rec := &logRecorder{statusRecorder: statusRecorder{ResponseWriter: w}}
+		next.ServeHTTP(rec, logged)
+
+		// the mux set the pattern on the request passed down; hand it back
+		// to the middleware around this one
+		r.Pattern = logged.Pattern
+
+		route := r.Pattern
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := rec.Status()
+
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.Int("status", status),
+			slog.Int("bytes", rec.bytes),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		}
+		if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+		}
+		if info.userID != uuid.Nil {
+			attrs = append(attrs, slog.String("user_id", info.userID.String()))
+		}
+		if rec.errMsg != "" {
+			attrs = append(attrs, slog.String("error", rec.errMsg))
+		}
+		if rec.cause != nil {
+			attrs = append(attrs, slog.String("cause", rec.cause.Error()))
+		}
+
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		cfg.Logger.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
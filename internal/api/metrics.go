@@ -0,0 +1,145 @@
+package api
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/helpers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// label for requests no route matched, so junk paths don't make new series
+const unmatchedRoute = "unmatched"
+
+// password hashing is meant to be slow; these bracket the usual costs
+var passwordHashBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// AppMetrics is what /metrics exposes.
+type AppMetrics struct {
+	handler http.Handler
+
+	requests             *prometheus.CounterVec
+	requestDuration      *prometheus.HistogramVec
+	passwordHashDuration *prometheus.HistogramVec
+
+	chirpsCreated     prometheus.Counter
+	logins            *prometheus.CounterVec
+	webhookEvents     *prometheus.CounterVec
+	webhookDeliveries *prometheus.CounterVec
+}
+
+// new-app-metrics
+// Registers the app's metrics, with db's connection pool stats and the Go
+// runtime's alongside, on a registry of its own.
+func NewAppMetrics(db *sql.DB) *AppMetrics {
+	r := prometheus.NewRegistry()
+	f := promauto.With(r)
+	m := &AppMetrics{
+		handler: promhttp.HandlerFor(r, promhttp.HandlerOpts{Registry: r}),
+		requests: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_http_requests_total",
+			Help: "HTTP requests by route pattern and status code.",
+		}, []string{"route", "code"}),
+		requestDuration: f.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chirpy_http_request_duration_seconds",
+			Help:    "HTTP request latency by route pattern and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "code"}),
+		passwordHashDuration: f.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chirpy_password_hash_duration_seconds",
+			Help:    "Time spent hashing and verifying passwords.",
+			Buckets: passwordHashBuckets,
+		}, []string{"algorithm", "operation"}),
+		chirpsCreated: f.NewCounter(prometheus.CounterOpts{
+			Name: "chirpy_chirps_created_total",
+			Help: "Chirps created.",
+		}),
+		logins: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_logins_total",
+			Help: "Logins by result: success, mfa_required, failed or blocked.",
+		}, []string{"result"}),
+		webhookEvents: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_webhook_events_total",
+			Help: "Inbound webhook events by provider and outcome.",
+		}, []string{"provider", "status"}),
+		webhookDeliveries: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_webhook_deliveries_total",
+			Help: "Outbound webhook delivery attempts by result: succeeded, retrying or dead.",
+		}, []string{"result"}),
+	}
+
+	r.MustRegister(
+		collectors.NewDBStatsCollector(db, "chirpy"),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// metrics-handler
+// The Prometheus scrape endpoint. Scrapers send METRICS_TOKEN as a bearer
+// token; without one configured, only admins can read it.
+func (cfg *APIConfig) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg.MetricsToken == "" {
+		cfg.MiddlewareRequireRole(auth.RoleAdmin, cfg.Metrics.handler).ServeHTTP(w, r)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.MetricsToken)) != 1 {
+		helpers.RespondWithError(w, 401, "Unauthorized")
+		return
+	}
+	cfg.Metrics.handler.ServeHTTP(w, r)
+}
+
+// middleware-metrics
+// Counts and times every request by the pattern it matched. Wraps the mux,
+// which sets r.Pattern while routing.
+func (cfg *APIConfig) MiddlewareMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = unmatchedRoute
+		}
+		code := strconv.Itoa(rec.Status())
+
+		cfg.Metrics.requests.WithLabelValues(route, code).Inc()
+		cfg.Metrics.requestDuration.WithLabelValues(route, code).Observe(time.Since(start).Seconds())
+	})
+}
+
+// timedHasher records how long a password hasher takes.
+type timedHasher struct {
+	auth.PasswordHasher
+	algorithm string
+	durations *prometheus.HistogramVec
+}
+
+// timed-hash
+func (h timedHasher) Hash(password string) (string, error) {
+	defer h.observe("hash", time.Now())
+	return h.PasswordHasher.Hash(password)
+}
+
+// timed-verify
+func (h timedHasher) Verify(password, encoded string) (bool, error) {
+	defer h.observe("verify", time.Now())
+	return h.PasswordHasher.Verify(password, encoded)
+}
+
+// observe
+func (h timedHasher) observe(operation string, start time.Time) {
+	h.durations.WithLabelValues(h.algorithm, operation).Observe(time.Since(start).Seconds())
+}
@@ -2,12 +2,73 @@ package api
 
 import (
 	"net/http"
+
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/helpers"
 )
 
-// midleware-metrics-inc
-func (cfg *APIConfig) MiddlewareMetricsInc(next http.Handler) http.Handler {
+// statusRecorder remembers what a handler answered.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// recorder-write-header
+func (rec *statusRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// recorder-write
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = 200
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// recorder-status
+// 200 when the handler wrote nothing at all, as net/http answers then.
+func (rec *statusRecorder) Status() int {
+	if rec.status == 0 {
+		return 200
+	}
+	return rec.status
+}
+
+// recorder-unwrap
+// Lets http.ResponseController reach the real writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// middleware-require-role
+// Only lets through first-party logins whose user has the role or a more
+// privileged one. The role is read from the database on every request, so a
+// demotion takes effect immediately.
+func (cfg *APIConfig) MiddlewareRequireRole(role string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cfg.FileserverHits.Add(1)
+		userID, _, err := cfg.authenticateSession(r)
+		if err != nil {
+			helpers.RespondWithError(w, 401, err.Error())
+			return
+		}
+
+		user, err := cfg.DB.GetUser(r.Context(), userID)
+		if err != nil {
+			helpers.RespondWithError(w, 401, "Unauthorized", err)
+			return
+		}
+		if !auth.HasRole(user.Role, role) {
+			helpers.RespondWithError(w, 403, "Requires the "+role+" role")
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/jobs"
+	"github.com/Johnermac/http-server/internal/outbox"
+	"github.com/Johnermac/http-server/internal/webhook"
+	"github.com/google/uuid"
+)
+
+// events integrators can subscribe to
+const (
+	eventChirpCreated = "chirp.created"
+	eventChirpUpdated = "chirp.updated"
+	eventChirpDeleted = "chirp.deleted"
+	eventUserUpgraded = "user.upgraded"
+)
+
+var outboundWebhookEvents = []string{eventChirpCreated, eventChirpUpdated, eventChirpDeleted, eventUserUpgraded}
+
+const (
+	webhookDeliveryBatch   = 20
+	webhookDeliveryTimeout = 10 * time.Second
+	// longer than a delivery can take, so a row is only released when its
+	// worker is gone
+	webhookDeliveryLock = 2 * time.Minute
+
+	deliveryStatusPending   = "pending"
+	deliveryStatusSucceeded = "succeeded"
+	deliveryStatusDead      = "dead"
+)
+
+// outboundEvent is the JSON body of every delivery.
+type outboundEvent struct {
+	ID         uuid.UUID `json:"id"`
+	Type       string    `json:"type"`
+	Created_at time.Time `json:"created_at"`
+	Data       any       `json:"data"`
+}
+
+// chirpEventData is the data of chirp events, shaped like the chirps API.
+type chirpEventData struct {
+	Id          uuid.UUID `json:"id"`
+	Created_at  time.Time `json:"created_at"`
+	Updated_at  time.Time `json:"updated_at"`
+	Data        string    `json:"body"`
+	User_id     uuid.UUID `json:"user_id"`
+	Attachments []string  `json:"attachments"`
+}
+
+// chirp-event-data
+func newChirpEventData(c database.Chirp) chirpEventData {
+	return chirpEventData{
+		Id:          c.ID,
+		Created_at:  c.CreatedAt,
+		Updated_at:  c.UpdatedAt,
+		Data:        c.Body,
+		User_id:     c.UserID,
+		Attachments: chirpAttachments(c),
+	}
+}
+
+type userUpgradedEventData struct {
+	User_id            uuid.UUID `json:"user_id"`
+	Plan               string    `json:"plan"`
+	Current_period_end time.Time `json:"current_period_end"`
+}
+
+// subscribe-outbox
+// Outbox subscribers; they run wherever the relay does.
+func (cfg *APIConfig) subscribeOutbox() {
+	for _, eventType := range outboundWebhookEvents {
+		cfg.Outbox.Subscribe(eventType, cfg.enqueueWebhookDeliveries)
+	}
+	for eventType := range chirpActivities {
+		cfg.Outbox.Subscribe(eventType, cfg.federateChirp)
+	}
+}
+
+// enqueue-webhook-deliveries
+// Queues the event for every endpoint subscribed to it, the user's own and
+// the ones receiving all users' events. It runs in the relay's transaction,
+// so each event is queued exactly once.
+func (cfg *APIConfig) enqueueWebhookDeliveries(ctx context.Context, q *database.Queries, ev outbox.Event) error {
+	payload, err := json.Marshal(outboundEvent{
+		ID:         ev.EventID,
+		Type:       ev.Type,
+		Created_at: ev.CreatedAt.UTC(),
+		Data:       ev.Payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	n, err := q.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+		EventID:   ev.EventID,
+		EventType: ev.Type,
+		Payload:   payload,
+		OwnerID:   ev.UserID,
+	})
+	if err != nil || n == 0 {
+		return err
+	}
+
+	_, err = jobs.NewClient(q).Enqueue(ctx, deliverWebhooksJob{}, deliverWebhooksOptions)
+	return err
+}
+
+// deliver-webhooks
+// Claims a batch of due deliveries and sends them; returns how many it
+// claimed.
+func (cfg *APIConfig) DeliverWebhooks(ctx context.Context) (int, error) {
+	rows, err := cfg.DB.ClaimWebhookDeliveries(ctx, database.ClaimWebhookDeliveriesParams{
+		Limit:       webhookDeliveryBatch,
+		LockedUntil: sql.NullTime{Time: time.Now().UTC().Add(webhookDeliveryLock), Valid: true},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	for _, row := range rows {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cfg.deliverWebhook(ctx, row)
+		}()
+	}
+	wg.Wait()
+
+	return len(rows), nil
+}
+
+// deliver-webhook
+// One attempt: logs it, then schedules a retry, or gives up for good after
+// webhook.MaxAttempts.
+func (cfg *APIConfig) deliverWebhook(ctx context.Context, row database.ClaimWebhookDeliveriesRow) {
+	result, err := webhook.Deliver(ctx, cfg.WebhookClient, webhook.Delivery{
+		URL:       row.Url,
+		Secret:    []byte(row.Secret),
+		EventID:   row.EventID.String(),
+		EventType: row.EventType,
+		Body:      row.Payload,
+	})
+
+	attempt := database.RecordWebhookDeliveryAttemptParams{
+		DeliveryID: row.ID,
+		StatusCode: sql.NullInt32{Int32: int32(result.StatusCode), Valid: result.StatusCode != 0},
+		Response:   sql.NullString{String: result.Response, Valid: result.StatusCode != 0},
+		DurationMs: int32(result.Duration.Milliseconds()),
+	}
+	finish := database.FinishWebhookDeliveryParams{
+		ID:             row.ID,
+		Status:         deliveryStatusSucceeded,
+		NextAttemptAt:  time.Now().UTC(),
+		LastStatusCode: attempt.StatusCode,
+	}
+
+	if err != nil {
+		attempt.Error = sql.NullString{String: err.Error(), Valid: true}
+		finish.LastError = attempt.Error
+
+		attempts := int(row.Attempts) + 1
+		if attempts >= webhook.MaxAttempts {
+			finish.Status = deliveryStatusDead
+			log.Printf("webhook delivery %s to %s is dead after %d attempts: %v", row.ID, row.Url, attempts, err)
+		} else {
+			finish.Status = deliveryStatusPending
+			finish.NextAttemptAt = finish.NextAttemptAt.Add(webhook.RetryDelay(attempts))
+		}
+	}
+
+	outcome := finish.Status
+	if outcome == deliveryStatusPending {
+		outcome = "retrying"
+	}
+	cfg.Metrics.webhookDeliveries.WithLabelValues(outcome).Inc()
+
+	if err := cfg.DB.RecordWebhookDeliveryAttempt(ctx, attempt); err != nil {
+		log.Printf("webhook delivery %s: record attempt: %v", row.ID, err)
+	}
+	if err := cfg.DB.FinishWebhookDelivery(ctx, finish); err != nil {
+		log.Printf("webhook delivery %s: record outcome: %v", row.ID, err)
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/billing"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/google/uuid"
+)
+
+// to-billing-subscription
+func toBillingSubscription(s database.Subscription) billing.Subscription {
+	sub := billing.Subscription{
+		Plan:             s.Plan,
+		Status:           s.Status,
+		CurrentPeriodEnd: s.CurrentPeriodEnd,
+	}
+	if s.GracePeriodEnd.Valid {
+		sub.GracePeriodEnd = s.GracePeriodEnd.Time
+	}
+	return sub
+}
+
+// plan-for
+// The plan whose entitlements apply to the user: their subscription's while
+// it is entitled, the free plan otherwise. A database error counts as free.
+func (cfg *APIConfig) planFor(ctx context.Context, userID uuid.UUID) string {
+	s, err := cfg.DB.GetSubscription(ctx, userID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("get subscription %s: %v", userID, err)
+		}
+		return billing.PlanFree
+	}
+	if !cfg.Billing.Entitled(toBillingSubscription(s), time.Now()) {
+		return billing.PlanFree
+	}
+	return s.Plan
+}
+
+// is-chirpy-red
+func (cfg *APIConfig) isChirpyRed(ctx context.Context, userID uuid.UUID) bool {
+	return cfg.planFor(ctx, userID) != billing.PlanFree
+}
+
+// apply-subscription-event
+// Returns the subscription as it is after the event. q may be a
+// transaction.
+func (cfg *APIConfig) applySubscriptionEvent(ctx context.Context, q *database.Queries, userID uuid.UUID, ev billing.Event) (billing.Subscription, error) {
+	var current *billing.Subscription
+	s, err := q.GetSubscription(ctx, userID)
+	switch {
+	case err == nil:
+		sub := toBillingSubscription(s)
+		current = &sub
+	case !errors.Is(err, sql.ErrNoRows):
+		return billing.Subscription{}, err
+	}
+
+	next, err := cfg.Billing.Apply(current, ev, time.Now().UTC())
+	if err != nil {
+		return next, err
+	}
+
+	_, err = q.UpsertSubscription(ctx, database.UpsertSubscriptionParams{
+		UserID:           userID,
+		Plan:             next.Plan,
+		Status:           next.Status,
+		CurrentPeriodEnd: next.CurrentPeriodEnd,
+		GracePeriodEnd:   sql.NullTime{Time: next.GracePeriodEnd, Valid: !next.GracePeriodEnd.IsZero()},
+	})
+	return next, err
+}
+
+// expire-subscriptions
+func (cfg *APIConfig) ExpireSubscriptions(ctx context.Context) (int64, error) {
+	return cfg.DB.ExpireSubscriptions(ctx, time.Now().UTC().Add(-cfg.Billing.GracePeriod))
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -11,67 +12,175 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
-// hash-password
-func HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), 10)
-	if err != nil {
-		return "", err
-	}
-	return string(hash), nil
-}
-
-// check-password-hash
-func CheckPasswordHash(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+// AccessClaims are the claims of an access token. SessionID ties the token
+// to the refresh token family it was issued from, when there is one.
+// ClientID and Scope are only set on tokens issued to OAuth clients.
+type AccessClaims struct {
+	SessionID string `json:"sid,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	jwt.RegisteredClaims
 }
 
 // make-jwt
 func MakeJWT(userID uuid.UUID, tokenSecret string) (string, error) {
+	return MakeSessionJWT(userID, uuid.Nil, tokenSecret)
+}
+
+// make-session-jwt
+func MakeSessionJWT(userID, sessionID uuid.UUID, tokenSecret string) (string, error) {
+	return NewHMACKeyset(tokenSecret).MakeSessionJWT(userID, sessionID)
+}
+
+// keyset-make-session-jwt
+func (ks *Keyset) MakeSessionJWT(userID, sessionID uuid.UUID) (string, error) {
 	now := time.Now().UTC()
 
 	// Create the Claims
-	claims := &jwt.RegisteredClaims{
-		IssuedAt:  jwt.NewNumericDate(now),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
-		Issuer:    "chirpy",
-		Subject:   userID.String(),
+	claims := &AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			Issuer:    "chirpy",
+			Subject:   userID.String(),
+		},
+	}
+	if sessionID != uuid.Nil {
+		claims.SessionID = sessionID.String()
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signed, err := token.SignedString([]byte(tokenSecret))
+	return ks.Sign(claims)
+}
+
+// validate-access-token
+func ValidateAccessToken(tokenString, tokenSecret string) (*AccessClaims, error) {
+	return NewHMACKeyset(tokenSecret).ValidateAccessToken(tokenString)
+}
+
+// keyset-validate-access-token
+func (ks *Keyset) ValidateAccessToken(tokenString string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+
+	token, err := ks.Parse(tokenString, claims, jwt.WithIssuer("chirpy"))
+
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return signed, nil
+
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
 }
 
 // validate-jwt
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claims := &jwt.RegisteredClaims{}
+	claims, err := ValidateAccessToken(tokenString, tokenSecret)
+	if err != nil {
+		return uuid.Nil, err
+	}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, errors.New("Invalid subject claim")
+	}
+
+	return userId, nil
+}
+
+type emailVerificationClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// make-email-verification-token
+// The returned id is the token's jti, stored server side so the token can only be used once.
+func MakeEmailVerificationToken(userID uuid.UUID, email, tokenSecret string, expiresIn time.Duration) (string, uuid.UUID, error) {
+	now := time.Now().UTC()
+	id := uuid.New()
+
+	claims := &emailVerificationClaims{
+		Email: email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Issuer:    "chirpy-email-verification",
+			Subject:   userID.String(),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString([]byte(tokenSecret))
+	if err != nil {
+		return "", uuid.Nil, err
+	}
+	return signed, id, nil
+}
+
+// validate-email-verification-token
+func ValidateEmailVerificationToken(tokenString, tokenSecret string) (userID uuid.UUID, email string, id uuid.UUID, err error) {
+	claims := &emailVerificationClaims{}
+
+	_, err = jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
 		}
 		return []byte(tokenSecret), nil
-	})
+	}, jwt.WithIssuer("chirpy-email-verification"))
+	if err != nil {
+		return uuid.Nil, "", uuid.Nil, err
+	}
 
+	userID, err = uuid.Parse(claims.Subject)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, "", uuid.Nil, errors.New("Invalid subject claim")
+	}
+	id, err = uuid.Parse(claims.ID)
+	if err != nil {
+		return uuid.Nil, "", uuid.Nil, errors.New("Invalid jti claim")
 	}
 
-	if !token.Valid {
-		return uuid.Nil, errors.New("invalid token")
+	return userID, claims.Email, id, nil
+}
+
+// make-mfa-token
+// Issued after a correct password when the user has 2FA enabled; only good for the second login step.
+func MakeMFAToken(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	now := time.Now().UTC()
+
+	claims := &jwt.RegisteredClaims{
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+		Issuer:    "chirpy-mfa",
+		Subject:   userID.String(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(tokenSecret))
+}
+
+// validate-mfa-token
+func ValidateMFAToken(tokenString, tokenSecret string) (uuid.UUID, error) {
+	claims := &jwt.RegisteredClaims{}
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return []byte(tokenSecret), nil
+	}, jwt.WithIssuer("chirpy-mfa"))
+	if err != nil {
+		return uuid.Nil, err
 	}
 
 	userId, err := uuid.Parse(claims.Subject)
 	if err != nil {
 		return uuid.Nil, errors.New("Invalid subject claim")
 	}
-
 	return userId, nil
 }
 
@@ -107,6 +216,13 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(key), nil
 }
 
+// hash-token
+// Opaque tokens are stored as their SHA-256 so a database leak doesn't leak usable tokens.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // get-api-key
 func GetAPIKey(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
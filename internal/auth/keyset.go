@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is one key of a Keyset. Private is nil for keys that are only
+// kept around to validate tokens issued before a rotation.
+type SigningKey struct {
+	ID      string
+	Alg     string // HS256, RS256 or EdDSA
+	Private any    // []byte, *rsa.PrivateKey or ed25519.PrivateKey
+	Public  any    // []byte, *rsa.PublicKey or ed25519.PublicKey
+}
+
+// Keyset signs with its active key and validates with any key, picked by the
+// token's kid header.
+type Keyset struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+	legacy *SigningKey // validates tokens without a kid
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// new-keyset
+// The first key signs; the rest only validate.
+func NewKeyset(keys ...SigningKey) (*Keyset, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keyset needs at least one key")
+	}
+
+	ks := &Keyset{keys: map[string]*SigningKey{}}
+	for i := range keys {
+		k := keys[i]
+		if k.ID == "" {
+			return nil, errors.New("key is missing a kid")
+		}
+		if _, ok := ks.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate kid %q", k.ID)
+		}
+		if signingMethod(k.Alg) == nil {
+			return nil, fmt.Errorf("unsupported alg %q for kid %q", k.Alg, k.ID)
+		}
+		ks.keys[k.ID] = &k
+	}
+
+	ks.active = ks.keys[keys[0].ID]
+	if ks.active.Private == nil {
+		return nil, fmt.Errorf("active key %q has no private key", ks.active.ID)
+	}
+	return ks, nil
+}
+
+// new-hmac-keyset
+func NewHMACKeyset(secret string) *Keyset {
+	key := &SigningKey{ID: "default", Alg: "HS256", Private: []byte(secret), Public: []byte(secret)}
+	return &Keyset{
+		active: key,
+		keys:   map[string]*SigningKey{key.ID: key},
+		legacy: key,
+	}
+}
+
+// with-legacy-secret
+// Tokens signed before kid headers existed were HS256 with JWT_SECRET.
+func (ks *Keyset) WithLegacySecret(secret string) *Keyset {
+	if secret != "" {
+		ks.legacy = &SigningKey{ID: "", Alg: "HS256", Public: []byte(secret)}
+	}
+	return ks
+}
+
+// signing-method
+func signingMethod(alg string) jwt.SigningMethod {
+	switch alg {
+	case "HS256":
+		return jwt.SigningMethodHS256
+	case "RS256":
+		return jwt.SigningMethodRS256
+	case "EdDSA":
+		return jwt.SigningMethodEdDSA
+	}
+	return nil
+}
+
+// sign
+func (ks *Keyset) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(signingMethod(ks.active.Alg), claims)
+	token.Header["kid"] = ks.active.ID
+	return token.SignedString(ks.active.Private)
+}
+
+// parse
+func (ks *Keyset) Parse(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+		key := ks.legacy
+		if kid, ok := token.Header["kid"].(string); ok {
+			key = ks.keys[kid]
+		}
+		if key == nil {
+			return nil, errors.New("unknown signing key")
+		}
+
+		// the key decides the algorithm, never the token
+		if token.Method.Alg() != key.Alg {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key.Public, nil
+	}, opts...)
+}
+
+// jwks
+// Only asymmetric keys are published.
+func (ks *Keyset) JWKS() JWKS {
+	out := JWKS{Keys: []JWK{}}
+	for _, k := range ks.keys {
+		switch pub := k.Public.(type) {
+		case *rsa.PublicKey:
+			out.Keys = append(out.Keys, JWK{
+				Kty: "RSA",
+				Use: "sig",
+				Alg: k.Alg,
+				Kid: k.ID,
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			out.Keys = append(out.Keys, JWK{
+				Kty: "OKP",
+				Use: "sig",
+				Alg: k.Alg,
+				Kid: k.ID,
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return out
+}
+
+// load-keyset
+// spec is a comma separated list of kid:alg:path entries, active key first.
+// path is a PEM file (private key to sign, public key to only validate), or a
+// file holding the secret for HS256.
+func LoadKeyset(spec string) (*Keyset, error) {
+	var keys []SigningKey
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid key entry %q, want kid:alg:path", entry)
+		}
+
+		key, err := loadSigningKey(parts[0], parts[1], parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("kid %q: %w", parts[0], err)
+		}
+		keys = append(keys, key)
+	}
+	return NewKeyset(keys...)
+}
+
+// load-signing-key
+func loadSigningKey(kid, alg, path string) (SigningKey, error) {
+	key := SigningKey{ID: kid, Alg: alg}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return key, err
+	}
+
+	if alg == "HS256" {
+		secret := []byte(strings.TrimSpace(string(data)))
+		if len(secret) < 32 {
+			return key, errors.New("HS256 secret must be at least 32 bytes")
+		}
+		key.Private, key.Public = secret, secret
+		return key, nil
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return key, errors.New("no PEM block found")
+	}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return key, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return key, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Private, key.Public = k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Public = k
+	case ed25519.PrivateKey:
+		key.Private, key.Public = k, k.Public()
+	case ed25519.PublicKey:
+		key.Public = k
+	default:
+		return key, errors.New("unsupported key type")
+	}
+
+	if (alg == "RS256") != isRSA(key.Public) {
+		return key, fmt.Errorf("key type does not match alg %s", alg)
+	}
+	return key, nil
+}
+
+// is-rsa
+func isRSA(k any) bool {
+	_, ok := k.(*rsa.PublicKey)
+	return ok
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// OAuth access tokens are regular access tokens (issuer "chirpy", subject the
+// user) that also carry the client and the granted scopes, so ValidateJWT
+// accepts them as is.
+
+// make-oauth-jwt
+func (ks *Keyset) MakeOAuthJWT(userID, clientID, tokenID uuid.UUID, scopes []string, expiresIn time.Duration) (string, error) {
+	now := time.Now().UTC()
+
+	claims := &AccessClaims{
+		ClientID: clientID.String(),
+		Scope:    FormatScope(scopes),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Issuer:    "chirpy",
+			Subject:   userID.String(),
+		},
+	}
+
+	return ks.Sign(claims)
+}
+
+// parse-scope
+// OAuth scopes travel as a single space separated string.
+func ParseScope(scope string) []string {
+	return strings.Fields(scope)
+}
+
+// format-scope
+func FormatScope(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// pkce-challenge
+// S256 code challenge for a code verifier (RFC 7636).
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// verify-pkce
+func VerifyPKCE(verifier, challenge string) bool {
+	if ValidatePKCEVerifier(verifier) != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(PKCEChallenge(verifier)), []byte(challenge)) == 1
+}
+
+// validate-pkce-verifier
+func ValidatePKCEVerifier(verifier string) error {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return errors.New("code_verifier must be 43 to 128 characters")
+	}
+	for _, c := range verifier {
+		if !isUnreserved(c) {
+			return errors.New("code_verifier has an invalid character")
+		}
+	}
+	return nil
+}
+
+// is-unreserved
+func isUnreserved(c rune) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// validate-redirect-uri
+// Registered redirect URIs must be absolute, without a fragment, and https
+// unless they point at localhost.
+func ValidateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return errors.New("redirect_uri must be an absolute URL")
+	}
+	if u.Fragment != "" {
+		return errors.New("redirect_uri must not have a fragment")
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && isLoopback(u.Hostname())) {
+		return errors.New("redirect_uri must use https")
+	}
+	return nil
+}
+
+// is-loopback
+func isLoopback(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// match-redirect-uri
+// Picks the redirect URI for an authorization request. Matching is exact; an
+// omitted redirect_uri is only allowed when the client registered exactly one.
+func MatchRedirectURI(registered []string, requested string) (string, bool) {
+	if requested == "" {
+		if len(registered) == 1 {
+			return registered[0], true
+		}
+		return "", false
+	}
+	return requested, slices.Contains(registered, requested)
+}
+
+// grant-scopes
+// Requested scopes must be a subset of the client's; none means all of them.
+func GrantScopes(allowed, requested []string) ([]string, bool) {
+	if len(requested) == 0 {
+		return allowed, true
+	}
+	for _, s := range requested {
+		if !slices.Contains(allowed, s) {
+			return nil, false
+		}
+	}
+	return requested, true
+}
@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher produces and checks one kind of hash string.
+type PasswordHasher interface {
+	// Identify reports whether encoded was produced by this kind of hasher.
+	Identify(encoded string) bool
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash reports whether encoded uses other parameters than the hasher's.
+	NeedsRehash(encoded string) bool
+}
+
+// Passwords hashes with its current hasher and verifies with any of its
+// hashers, picked by the hash's prefix. Hashes from an older hasher, or with
+// older parameters, are flagged for rehashing.
+type Passwords struct {
+	current   PasswordHasher
+	hashers   []PasswordHasher
+	dummyHash func() string
+
+	// one slot per hash or check allowed to run at once; nil is no limit
+	slots chan struct{}
+}
+
+// new-passwords
+func NewPasswords(current PasswordHasher, legacy ...PasswordHasher) *Passwords {
+	p := &Passwords{
+		current: current,
+		hashers: append([]PasswordHasher{current}, legacy...),
+	}
+	p.dummyHash = sync.OnceValue(func() string {
+		hash, _ := p.Hash("chirpy-dummy-password")
+		return hash
+	})
+	return p
+}
+
+// with-max-concurrent
+// Caps how many hashes and checks run at once; the rest wait their turn.
+// Each argon2id run allocates its full memory cost, so a burst of logins
+// would otherwise take as much memory as it has requests.
+func (p *Passwords) WithMaxConcurrent(n int) *Passwords {
+	if n > 0 {
+		p.slots = make(chan struct{}, n)
+	}
+	return p
+}
+
+// acquire
+// Waits for a slot and returns the func giving it back.
+func (p *Passwords) acquire() func() {
+	if p.slots == nil {
+		return func() {}
+	}
+	p.slots <- struct{}{}
+	return func() { <-p.slots }
+}
+
+// DefaultPasswords is argon2id with the default parameters, still accepting bcrypt.
+var DefaultPasswords = NewPasswords(Argon2idHasher{Params: DefaultArgon2idParams}, BcryptHasher{Cost: bcrypt.DefaultCost})
+
+// hash
+func (p *Passwords) Hash(password string) (string, error) {
+	defer p.acquire()()
+	return p.current.Hash(password)
+}
+
+// verify
+func (p *Passwords) Verify(password, encoded string) (ok, rehash bool, err error) {
+	for _, h := range p.hashers {
+		if !h.Identify(encoded) {
+			continue
+		}
+
+		release := p.acquire()
+		ok, err := h.Verify(password, encoded)
+		release()
+		if err != nil || !ok {
+			return false, false, err
+		}
+		return true, h != p.current || h.NeedsRehash(encoded), nil
+	}
+	return false, false, errors.New("unknown password hash format")
+}
+
+// burn-check
+// Does the work of a password check for a user that doesn't exist, so the
+// response time doesn't tell whether an email is registered.
+func (p *Passwords) BurnCheck(password string) {
+	dummy := p.dummyHash()
+	defer p.acquire()()
+	p.current.Verify(password, dummy)
+}
+
+// hash-password
+func HashPassword(password string) (string, error) {
+	return DefaultPasswords.Hash(password)
+}
+
+// check-password-hash
+func CheckPasswordHash(password, hash string) error {
+	ok, _, err := DefaultPasswords.Verify(password, hash)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("password does not match")
+	}
+	return nil
+}
+
+type Argon2idParams struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// OWASP's recommended minimum is 19 MiB, 2 iterations; this is comfortably above.
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Argon2idHasher writes PHC strings:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type Argon2idHasher struct {
+	Params Argon2idParams
+}
+
+// argon2id-identify
+func (h Argon2idHasher) Identify(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+// argon2id-hash
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.Params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Params.Iterations, h.Params.Memory, h.Params.Parallelism, h.Params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Params.Memory, h.Params.Iterations, h.Params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// argon2id-verify
+// Uses the parameters stored in the hash, not the hasher's.
+func (h Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// argon2id-needs-rehash
+func (h Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, _, _, err := parseArgon2id(encoded)
+	return err != nil || params != h.Params
+}
+
+// parse-argon2id
+func parseArgon2id(encoded string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errors.New("unsupported argon2 version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, errors.New("invalid argon2id parameters")
+	}
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, errors.New("invalid argon2id parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errors.New("invalid argon2id salt")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// BcryptHasher only looks at the first 72 bytes of a password; it is kept
+// to verify (and upgrade) hashes written before argon2id.
+type BcryptHasher struct {
+	Cost int
+}
+
+// bcrypt-identify
+func (h BcryptHasher) Identify(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// bcrypt-hash
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// bcrypt-verify
+func (h BcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// bcrypt-needs-rehash
+func (h BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// roles from least to most privileged; each role can do what the ones
+// before it can
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+// validate-role
+func ValidateRole(role string) error {
+	if !slices.Contains(Roles, role) {
+		return fmt.Errorf("Unknown role %q", role)
+	}
+	return nil
+}
+
+// has-role
+// Reports whether role is required or a more privileged one. Unknown roles
+// have no privileges.
+func HasRole(role, required string) bool {
+	have := slices.Index(Roles, role)
+	need := slices.Index(Roles, required)
+	return have >= 0 && need >= 0 && have >= need
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Reading chirps needs no token, so there is no read scope.
+const (
+	ScopeChirpsWrite  = "chirps:write"
+	ScopeProfileWrite = "profile:write"
+
+	// ScopeAll is only held by interactive logins (JWT access tokens). It is
+	// needed for account security endpoints, including changing the email or
+	// password, so a PAT can never take over the account or mint more PATs.
+	ScopeAll = "*"
+)
+
+// scopes a personal access token may be granted
+var PATScopes = []string{ScopeChirpsWrite, ScopeProfileWrite}
+
+const patPrefix = "chirpy_pat_"
+
+type Scopes []string
+
+// has
+func (s Scopes) Has(scope string) bool {
+	return slices.Contains(s, ScopeAll) || slices.Contains(s, scope)
+}
+
+// validate-pat-scopes
+func ValidatePATScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("At least one scope is required")
+	}
+	for _, s := range scopes {
+		if !slices.Contains(PATScopes, s) {
+			return fmt.Errorf("Unknown scope %q", s)
+		}
+	}
+	return nil
+}
+
+// make-personal-access-token
+func MakePersonalAccessToken() (string, error) {
+	key := make([]byte, 32) // 256-bit
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate personal access token: %w", err)
+	}
+
+	return patPrefix + hex.EncodeToString(key), nil
+}
+
+// is-personal-access-token
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, patPrefix)
+}
@@ -0,0 +1,165 @@
+// Package billing keeps the subscription state machine, apart from the
+// webhooks that drive it and the database that stores it.
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	PlanFree      = "free"
+	PlanChirpyRed = "chirpy_red"
+
+	// paid and current
+	StatusActive = "active"
+	// a renewal payment failed; entitled until the grace period ends
+	StatusPastDue = "past_due"
+	// won't renew; entitled until the current period ends
+	StatusCanceled = "canceled"
+	// no longer entitled
+	StatusExpired = "expired"
+)
+
+// ErrInvalidEvent is returned for an event that can't apply to the
+// subscription as it stands.
+var ErrInvalidEvent = errors.New("invalid event")
+
+// Polka event types
+const (
+	EventUpgraded      = "user.upgraded"
+	EventRenewed       = "user.renewed"
+	EventDowngraded    = "user.downgraded"
+	EventCanceled      = "user.canceled"
+	EventPaymentFailed = "user.payment_failed"
+)
+
+// Subscription is a user's plan. GracePeriodEnd is zero unless past due.
+type Subscription struct {
+	Plan             string
+	Status           string
+	CurrentPeriodEnd time.Time
+	GracePeriodEnd   time.Time
+}
+
+// Event is a billing event as sent by the provider. Plan and
+// CurrentPeriodEnd are optional.
+type Event struct {
+	Type             string
+	Plan             string
+	CurrentPeriodEnd time.Time
+}
+
+// Policy holds the durations the provider doesn't send.
+type Policy struct {
+	// billing period assumed when an event doesn't say when it ends
+	Period time.Duration
+	// how long a past due subscription stays entitled, and how late a
+	// renewal may arrive after the period ended
+	GracePeriod time.Duration
+}
+
+var DefaultPolicy = Policy{
+	Period:      30 * 24 * time.Hour,
+	GracePeriod: 7 * 24 * time.Hour,
+}
+
+// normalize-event-type
+// Polka spells it both ways.
+func NormalizeEventType(t string) string {
+	if t == "user.cancelled" {
+		return EventCanceled
+	}
+	return t
+}
+
+// apply
+// The subscription after the event; current is nil for a user without one.
+// Returns an error for events that don't apply to the subscription.
+func (p Policy) Apply(current *Subscription, ev Event, now time.Time) (Subscription, error) {
+	var sub Subscription
+	if current != nil {
+		sub = *current
+	}
+	hasSub := current != nil && sub.Status != StatusExpired
+
+	periodEnd := ev.CurrentPeriodEnd
+	if periodEnd.IsZero() {
+		periodEnd = now.Add(p.Period)
+	}
+
+	switch NormalizeEventType(ev.Type) {
+	case EventUpgraded:
+		sub.Plan = ev.Plan
+		if sub.Plan == "" {
+			sub.Plan = PlanChirpyRed
+		}
+		sub.Status = StatusActive
+		sub.GracePeriodEnd = time.Time{}
+		// an upgrade mid-period doesn't shorten the period already paid for
+		if !hasSub || periodEnd.After(sub.CurrentPeriodEnd) {
+			sub.CurrentPeriodEnd = periodEnd
+		}
+
+	case EventRenewed:
+		if current == nil {
+			return sub, fmt.Errorf("%w: no subscription to renew", ErrInvalidEvent)
+		}
+		sub.Status = StatusActive
+		sub.GracePeriodEnd = time.Time{}
+		if periodEnd.After(sub.CurrentPeriodEnd) {
+			sub.CurrentPeriodEnd = periodEnd
+		}
+
+	case EventDowngraded:
+		if !hasSub {
+			return sub, fmt.Errorf("%w: no subscription to downgrade", ErrInvalidEvent)
+		}
+		if ev.Plan == "" || ev.Plan == PlanFree {
+			sub.Status = StatusExpired
+			sub.CurrentPeriodEnd = now
+			sub.GracePeriodEnd = time.Time{}
+			break
+		}
+		sub.Plan = ev.Plan
+
+	case EventCanceled:
+		if !hasSub {
+			return sub, fmt.Errorf("%w: no subscription to cancel", ErrInvalidEvent)
+		}
+		sub.Status = StatusCanceled
+		sub.GracePeriodEnd = time.Time{}
+
+	case EventPaymentFailed:
+		if !hasSub {
+			return sub, fmt.Errorf("%w: no subscription to bill", ErrInvalidEvent)
+		}
+		// repeated failures don't extend the grace period
+		if sub.Status != StatusPastDue {
+			sub.Status = StatusPastDue
+			sub.GracePeriodEnd = now.Add(p.GracePeriod)
+		}
+
+	default:
+		return sub, fmt.Errorf("%w: unknown event %q", ErrInvalidEvent, ev.Type)
+	}
+
+	return sub, nil
+}
+
+// entitled
+// Whether the subscription unlocks its plan at now. The expiry job moves
+// lapsed subscriptions to StatusExpired; this also covers the time between
+// two runs.
+func (p Policy) Entitled(sub Subscription, now time.Time) bool {
+	switch sub.Status {
+	case StatusActive:
+		return now.Before(sub.CurrentPeriodEnd.Add(p.GracePeriod))
+	case StatusPastDue:
+		return now.Before(sub.GracePeriodEnd)
+	case StatusCanceled:
+		return now.Before(sub.CurrentPeriodEnd)
+	}
+	return false
+}
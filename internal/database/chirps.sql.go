@@ -9,27 +9,30 @@ import (
 	"context"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, attachments)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1, -- body
-    $2  -- user_id
+    $2, -- user_id
+    $3  -- attachments
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, attachments
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body        string
+	UserID      uuid.UUID
+	Attachments []string
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID, pq.Array(arg.Attachments))
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -37,6 +40,7 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		pq.Array(&i.Attachments),
 	)
 	return i, err
 }
@@ -60,7 +64,7 @@ func (q *Queries) DeleteChirp(ctx context.Context, arg DeleteChirpParams) error
 }
 
 const getAllChirps = `-- name: GetAllChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+SELECT id, created_at, updated_at, body, user_id, attachments FROM chirps
 ORDER BY created_at ASC
 `
 
@@ -79,6 +83,7 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			pq.Array(&i.Attachments),
 		); err != nil {
 			return nil, err
 		}
@@ -94,7 +99,7 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 }
 
 const getChirp = `-- name: GetChirp :one
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, attachments
 FROM chirps
 WHERE id = $1
 `
@@ -108,12 +113,13 @@ func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		pq.Array(&i.Attachments),
 	)
 	return i, err
 }
 
 const getChirpsByAuthor = `-- name: GetChirpsByAuthor :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+SELECT id, created_at, updated_at, body, user_id, attachments FROM chirps
 WHERE user_id = $1 -- user_id
 ORDER BY created_at ASC
 `
@@ -133,6 +139,7 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			pq.Array(&i.Attachments),
 		); err != nil {
 			return nil, err
 		}
@@ -146,3 +153,42 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 	}
 	return items, nil
 }
+
+const updateChirp = `-- name: UpdateChirp :one
+
+UPDATE chirps
+SET
+    updated_at = NOW(),
+    body = $3,
+    attachments = $4
+WHERE id = $1
+  AND user_id = $2
+RETURNING id, created_at, updated_at, body, user_id, attachments
+`
+
+type UpdateChirpParams struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Body        string
+	Attachments []string
+}
+
+// chirp_id
+func (q *Queries) UpdateChirp(ctx context.Context, arg UpdateChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirp,
+		arg.ID,
+		arg.UserID,
+		arg.Body,
+		pq.Array(arg.Attachments),
+	)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		pq.Array(&i.Attachments),
+	)
+	return i, err
+}
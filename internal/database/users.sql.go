@@ -11,17 +11,29 @@ import (
 	"github.com/google/uuid"
 )
 
+const countUsersByRole = `-- name: CountUsersByRole :one
+SELECT COUNT(*)
+FROM users
+WHERE role = $1
+`
+
+func (q *Queries) CountUsersByRole(ctx context.Context, role string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUsersByRole, role)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password, is_chirpy_red)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1, -- email
-    $2,  -- password
-    false
+    $2  -- password
 )
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, email_verified_at, role
 `
 
 type CreateUserParams struct {
@@ -38,7 +50,8 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
-		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.Role,
 	)
 	return i, err
 }
@@ -52,8 +65,29 @@ func (q *Queries) DeleteAllUsers(ctx context.Context) error {
 	return err
 }
 
+const getUser = `-- name: GetUser :one
+SELECT id, created_at, updated_at, email, hashed_password, email_verified_at, role
+FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.EmailVerifiedAt,
+		&i.Role,
+	)
+	return i, err
+}
+
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red
+SELECT id, created_at, updated_at, email, hashed_password, email_verified_at, role
 FROM users
 WHERE email = $1
 `
@@ -67,27 +101,43 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
-		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.Role,
 	)
 	return i, err
 }
 
-const updatePremiumUser = `-- name: UpdatePremiumUser :exec
-UPDATE users
-SET
-    updated_at = NOW(),
-    is_chirpy_red = $2 -- is_chirpy_red    
-WHERE id = $1
+const lockUsersByRole = `-- name: LockUsersByRole :many
+SELECT id
+FROM users
+WHERE role = $1
+ORDER BY id
+FOR UPDATE
 `
 
-type UpdatePremiumUserParams struct {
-	ID          uuid.UUID
-	IsChirpyRed bool
-}
-
-func (q *Queries) UpdatePremiumUser(ctx context.Context, arg UpdatePremiumUserParams) error {
-	_, err := q.db.ExecContext(ctx, updatePremiumUser, arg.ID, arg.IsChirpyRed)
-	return err
+// Locks the rows until the transaction ends, in id order so that concurrent
+// callers queue up instead of deadlocking.
+func (q *Queries) LockUsersByRole(ctx context.Context, role string) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, lockUsersByRole, role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
 const updateUser = `-- name: UpdateUser :one
@@ -95,10 +145,11 @@ const updateUser = `-- name: UpdateUser :one
 UPDATE users
 SET
     updated_at = NOW(),
+    email_verified_at = CASE WHEN email = $2 THEN email_verified_at ELSE NULL END,
     email = $2, -- email
     hashed_password = $3 -- password
 WHERE id = $1 -- user_id
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, email_verified_at, role
 `
 
 type UpdateUserParams struct {
@@ -117,7 +168,78 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
-		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.Role,
+	)
+	return i, err
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+
+UPDATE users
+SET
+    updated_at = NOW(),
+    hashed_password = $2 -- password
+WHERE id = $1
+`
+
+type UpdateUserPasswordParams struct {
+	ID             uuid.UUID
+	HashedPassword string
+}
+
+// email
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.ID, arg.HashedPassword)
+	return err
+}
+
+const updateUserRole = `-- name: UpdateUserRole :one
+UPDATE users
+SET
+    updated_at = NOW(),
+    role = $2
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, email_verified_at, role
+`
+
+type UpdateUserRoleParams struct {
+	ID   uuid.UUID
+	Role string
+}
+
+func (q *Queries) UpdateUserRole(ctx context.Context, arg UpdateUserRoleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserRole, arg.ID, arg.Role)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.EmailVerifiedAt,
+		&i.Role,
 	)
 	return i, err
 }
+
+const verifyUserEmail = `-- name: VerifyUserEmail :exec
+
+UPDATE users
+SET
+    updated_at = NOW(),
+    email_verified_at = NOW()
+WHERE id = $1 -- user_id
+AND email = $2
+`
+
+type VerifyUserEmailParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+// user_id
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) error {
+	_, err := q.db.ExecContext(ctx, verifyUserEmail, arg.ID, arg.Email)
+	return err
+}
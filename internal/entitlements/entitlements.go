@@ -0,0 +1,151 @@
+// Package entitlements maps subscription plans to what they unlock. Plans
+// are data: the defaults can be replaced with a JSON file, so limits change
+// without a code change.
+package entitlements
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/billing"
+	"github.com/Johnermac/http-server/internal/ratelimit"
+)
+
+// Capabilities is what a plan allows.
+type Capabilities struct {
+	MaxChirpLength int `json:"max_chirp_length"`
+	ChirpsPerHour  int `json:"chirps_per_hour"`
+	// links per chirp; 0 allows none
+	MaxAttachments int `json:"max_attachments"`
+	// how long after posting a chirp can be edited; 0 disables editing
+	EditWindow Duration `json:"edit_window"`
+}
+
+// Duration reads and writes JSON as a Go duration string ("15m").
+type Duration time.Duration
+
+// marshal-json
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// unmarshal-json
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"15m\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Plans are capabilities by plan name. The free plan applies to everyone
+// without an entitled subscription, and to plans missing from the map.
+type Plans map[string]Capabilities
+
+var DefaultPlans = Plans{
+	billing.PlanFree: {
+		MaxChirpLength: 140,
+		ChirpsPerHour:  30,
+		MaxAttachments: 1,
+	},
+	billing.PlanChirpyRed: {
+		MaxChirpLength: 1000,
+		ChirpsPerHour:  300,
+		MaxAttachments: 4,
+		EditWindow:     Duration(15 * time.Minute),
+	},
+}
+
+// load-plans
+// Reads plans from a JSON file of the same shape as DefaultPlans:
+//
+//	{"free": {"max_chirp_length": 140, "chirps_per_hour": 30, "max_attachments": 1, "edit_window": "0s"}, ...}
+func LoadPlans(path string) (Plans, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var plans Plans
+	if err := json.Unmarshal(data, &plans); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := plans.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return plans, nil
+}
+
+// validate
+func (p Plans) Validate() error {
+	if _, ok := p[billing.PlanFree]; !ok {
+		return fmt.Errorf("the %q plan is required", billing.PlanFree)
+	}
+	for name, c := range p {
+		if c.MaxChirpLength <= 0 {
+			return fmt.Errorf("plan %q: max_chirp_length must be positive", name)
+		}
+		if c.ChirpsPerHour <= 0 {
+			return fmt.Errorf("plan %q: chirps_per_hour must be positive", name)
+		}
+		if c.MaxAttachments < 0 {
+			return fmt.Errorf("plan %q: max_attachments can't be negative", name)
+		}
+		if c.EditWindow < 0 {
+			return fmt.Errorf("plan %q: edit_window can't be negative", name)
+		}
+	}
+	return nil
+}
+
+// Engine answers what a plan may do. Handlers ask it instead of checking
+// plans themselves.
+type Engine struct {
+	plans  Plans
+	chirps map[string]*ratelimit.Limiter
+}
+
+// new-engine
+func NewEngine(plans Plans) *Engine {
+	e := &Engine{
+		plans:  plans,
+		chirps: map[string]*ratelimit.Limiter{},
+	}
+	for name, c := range plans {
+		e.chirps[name] = ratelimit.New(c.ChirpsPerHour, time.Hour)
+	}
+	return e
+}
+
+// plan-name
+// The plan that applies: unknown plans get the free plan's capabilities.
+func (e *Engine) planName(plan string) string {
+	if _, ok := e.plans[plan]; ok {
+		return plan
+	}
+	return billing.PlanFree
+}
+
+// for
+func (e *Engine) For(plan string) Capabilities {
+	return e.plans[e.planName(plan)]
+}
+
+// allow-chirp
+// Takes one chirp from the key's (user's) hourly allowance on the plan.
+func (e *Engine) AllowChirp(plan, key string) bool {
+	return e.chirps[e.planName(plan)].Allow(key)
+}
+
+// can-edit
+// Whether a chirp posted at createdAt can still be edited at now.
+func (c Capabilities) CanEdit(createdAt, now time.Time) bool {
+	return c.EditWindow > 0 && now.Sub(createdAt) <= time.Duration(c.EditWindow)
+}
@@ -0,0 +1,174 @@
+package feeds
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Entry is a single chirp as it appears in a feed.
+type Entry struct {
+	ID        string
+	URL       string
+	Author    string
+	Body      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Feed is the format-independent description of a feed.
+type Feed struct {
+	ID          string
+	Title       string
+	Description string
+	SelfURL     string
+	SiteURL     string
+	Updated     time.Time
+	Entries     []Entry
+}
+
+// atom
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published"`
+	Author    atomAuthor  `xml:"author"`
+	Link      atomLink    `xml:"link"`
+	Content   atomContent `xml:"content"`
+}
+
+// rss
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Self          rssSelf   `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssSelf struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	Author      string  `xml:"author,omitempty"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+// entry-title
+func entryTitle(body string) string {
+	const max = 60
+	runes := []rune(body)
+	if len(runes) <= max {
+		return body
+	}
+	return string(runes[:max]) + "…"
+}
+
+// render-atom
+func RenderAtom(f Feed) ([]byte, error) {
+	out := atomFeed{
+		ID:      f.ID,
+		Title:   f.Title,
+		Updated: f.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: f.SelfURL},
+			{Rel: "alternate", Href: f.SiteURL},
+		},
+	}
+
+	for _, e := range f.Entries {
+		out.Entries = append(out.Entries, atomEntry{
+			ID:        e.ID,
+			Title:     entryTitle(e.Body),
+			Updated:   e.UpdatedAt.UTC().Format(time.RFC3339),
+			Published: e.CreatedAt.UTC().Format(time.RFC3339),
+			Author:    atomAuthor{Name: e.Author},
+			Link:      atomLink{Rel: "alternate", Href: e.URL},
+			Content:   atomContent{Type: "text", Body: e.Body},
+		})
+	}
+
+	return marshal(out)
+}
+
+// render-rss
+func RenderRSS(f Feed) ([]byte, error) {
+	out := rssFeed{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.SiteURL,
+			Description:   f.Description,
+			LastBuildDate: f.Updated.UTC().Format(time.RFC1123Z),
+			Self:          rssSelf{Href: f.SelfURL, Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+
+	for _, e := range f.Entries {
+		out.Channel.Items = append(out.Channel.Items, rssItem{
+			Title:       entryTitle(e.Body),
+			Link:        e.URL,
+			Description: e.Body,
+			GUID:        rssGUID{IsPermaLink: false, Value: e.ID},
+			PubDate:     e.CreatedAt.UTC().Format(time.RFC1123Z),
+		})
+	}
+
+	return marshal(out)
+}
+
+// marshal
+func marshal(v any) ([]byte, error) {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
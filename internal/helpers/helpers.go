@@ -2,9 +2,12 @@ package helpers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/mail"
 	"slices"
 	"strings"
 )
@@ -27,6 +30,19 @@ func BadWordReplacement(payload string) string {
 	return strings.Join(out, " ")
 }
 
+// validate-email
+
+func ValidateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return fmt.Errorf("Invalid email address")
+	}
+	if _, domain, _ := strings.Cut(email, "@"); !strings.Contains(domain, ".") {
+		return fmt.Errorf("Invalid email address")
+	}
+	return nil
+}
+
 // respond-with-JSON
 
 func RespondWithJSON(w http.ResponseWriter, code int, payload any) error {
@@ -43,9 +59,48 @@ func RespondWithJSON(w http.ResponseWriter, code int, payload any) error {
 }
 
 // respond-with-error
+// msg is what the client sees; cause, when given, is only logged. A traced
+// request's trace ID is included, so a user report can be matched to it.
 
-func RespondWithError(w http.ResponseWriter, code int, msg string) error {
-	return RespondWithJSON(w, code, map[string]string{"error": msg})
+func RespondWithError(w http.ResponseWriter, code int, msg string, cause ...error) error {
+	return RespondWithErrorBody(w, code, map[string]string{"error": msg}, msg, cause...)
+}
+
+// respond-with-error-body
+// RespondWithError for error formats other than {"error": msg}, like
+// OAuth's; msg is what gets recorded.
+
+func RespondWithErrorBody(w http.ResponseWriter, code int, body map[string]string, msg string, cause ...error) error {
+	err := errors.Join(cause...)
+	for _, wrapper := range writers(w) {
+		if rec, ok := wrapper.(ErrorRecorder); ok {
+			rec.RecordError(msg, err)
+		}
+		if t, ok := wrapper.(interface{ TraceID() string }); ok && t.TraceID() != "" {
+			body["trace_id"] = t.TraceID()
+		}
+	}
+	return RespondWithJSON(w, code, body)
+}
+
+// ErrorRecorder is implemented by response writers that want to know why a
+// request failed, like the request logger's.
+type ErrorRecorder interface {
+	RecordError(msg string, cause error)
+}
+
+// writers
+// w and every writer it wraps.
+func writers(w http.ResponseWriter) []http.ResponseWriter {
+	all := []http.ResponseWriter{w}
+	for {
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return all
+		}
+		w = u.Unwrap()
+		all = append(all, w)
+	}
 }
 
 // respond-no-content
@@ -67,3 +122,13 @@ func ParseRequest[T any](r *http.Request) (T, error) {
 	}
 	return params, nil
 }
+
+// client-ip
+
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,130 @@
+// Package jobs is a durable background job queue in Postgres.
+//
+// A job is a kind and JSON arguments. Workers claim due jobs with
+// FOR UPDATE SKIP LOCKED, so any number of them can share the table; a
+// failed job is retried with exponential backoff until it runs out of
+// attempts and is marked dead. Jobs can be deduplicated by a unique key and
+// enqueued on cron schedules.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/ratelimit"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead"
+
+	DefaultMaxAttempts = 10
+
+	// 10s, 20s, 40s, ... capped at an hour: about 3 hours for ten attempts
+	retryBase = 10 * time.Second
+	retryMax  = time.Hour
+)
+
+// Args are the arguments of one kind of job. Kind must not depend on the
+// receiver's value, since it is called on the zero value to route jobs.
+type Args interface {
+	Kind() string
+}
+
+// Store is the part of database.Queries the queue uses.
+type Store interface {
+	EnqueueJob(ctx context.Context, arg database.EnqueueJobParams) (int64, error)
+	ClaimJobs(ctx context.Context, arg database.ClaimJobsParams) ([]database.Job, error)
+	FinishJob(ctx context.Context, arg database.FinishJobParams) error
+	UpsertJobSchedule(ctx context.Context, arg database.UpsertJobScheduleParams) error
+	EnqueueScheduledJob(ctx context.Context, arg database.EnqueueScheduledJobParams) (int64, error)
+}
+
+// Options tune one enqueued job; the zero value runs it now with
+// DefaultMaxAttempts.
+type Options struct {
+	RunAt       time.Time
+	MaxAttempts int
+	// while a job with this key waits for its first try, enqueueing
+	// another one does nothing
+	UniqueKey string
+}
+
+// Client enqueues jobs.
+type Client struct {
+	store Store
+}
+
+// new-client
+func NewClient(store Store) *Client {
+	return &Client{store: store}
+}
+
+// enqueue
+// Reports false when the job was dropped for its unique key.
+func (c *Client) Enqueue(ctx context.Context, args Args, opts Options) (bool, error) {
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return false, err
+	}
+
+	runAt := opts.RunAt
+	if runAt.IsZero() {
+		runAt = time.Now()
+	}
+
+	n, err := c.store.EnqueueJob(ctx, database.EnqueueJobParams{
+		Kind:        args.Kind(),
+		Args:        raw,
+		MaxAttempts: int32(maxAttempts(opts.MaxAttempts)),
+		RunAt:       runAt.UTC(),
+		UniqueKey:   sql.NullString{String: opts.UniqueKey, Valid: opts.UniqueKey != ""},
+	})
+	return n > 0, err
+}
+
+// max-attempts
+func maxAttempts(n int) int {
+	if n <= 0 {
+		return DefaultMaxAttempts
+	}
+	return n
+}
+
+// retry-delay
+// How long to wait before trying a job again after its nth failed attempt.
+func RetryDelay(attempts int) time.Duration {
+	return ratelimit.BackoffDelay(attempts, 1, retryBase, retryMax)
+}
+
+type permanentError struct {
+	err error
+}
+
+// permanent-error
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// permanent-error-unwrap
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// permanent
+// Wraps a handler error so the job is marked dead instead of retried.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// is-permanent
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
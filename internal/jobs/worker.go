@@ -0,0 +1,262 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/database"
+)
+
+const (
+	DefaultConcurrency  = 4
+	DefaultPollInterval = time.Second
+	// longer than a job may run; a job that takes longer is canceled, since
+	// another worker may take it over
+	DefaultLockDuration = 5 * time.Minute
+	DefaultDrainTimeout = 30 * time.Second
+)
+
+type handlerFunc func(ctx context.Context, args json.RawMessage) error
+
+type schedule struct {
+	name     string
+	spec     string
+	schedule Schedule
+	kind     string
+	args     json.RawMessage
+	// when this worker checks the schedule again; zero checks right away,
+	// in case a run was missed while no worker was up
+	next time.Time
+}
+
+// Worker runs the jobs it has handlers for, and enqueues its schedules.
+type Worker struct {
+	store     Store
+	handlers  map[string]handlerFunc
+	schedules []*schedule
+
+	Concurrency  int
+	PollInterval time.Duration
+	LockDuration time.Duration
+	// how long Run waits for running jobs once its context is done, before
+	// canceling them
+	DrainTimeout time.Duration
+}
+
+// new-worker
+func NewWorker(store Store) *Worker {
+	return &Worker{
+		store:        store,
+		handlers:     map[string]handlerFunc{},
+		Concurrency:  DefaultConcurrency,
+		PollInterval: DefaultPollInterval,
+		LockDuration: DefaultLockDuration,
+		DrainTimeout: DefaultDrainTimeout,
+	}
+}
+
+// register
+// Routes jobs of T's kind to fn. Arguments that don't decode are a permanent
+// failure.
+func Register[T Args](w *Worker, fn func(ctx context.Context, args T) error) {
+	var zero T
+	w.handlers[zero.Kind()] = func(ctx context.Context, raw json.RawMessage) error {
+		var args T
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return Permanent(fmt.Errorf("decode %s args: %w", zero.Kind(), err))
+		}
+		return fn(ctx, args)
+	}
+}
+
+// schedule
+// Enqueues args on a cron spec, in UTC. The name identifies the schedule
+// across workers; at most one run of it waits in the queue at a time.
+func (w *Worker) Schedule(name, spec string, args Args) error {
+	sched, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	w.schedules = append(w.schedules, &schedule{
+		name:     name,
+		spec:     spec,
+		schedule: sched,
+		kind:     args.Kind(),
+		args:     raw,
+	})
+	return nil
+}
+
+// run
+// Claims and runs jobs until ctx is done, then stops claiming and waits up
+// to DrainTimeout for the running ones; jobs still running after that are
+// canceled and retried right away by the next worker.
+func (w *Worker) Run(ctx context.Context) error {
+	now := time.Now().UTC()
+	for _, s := range w.schedules {
+		err := w.store.UpsertJobSchedule(ctx, database.UpsertJobScheduleParams{
+			Name:      s.name,
+			Spec:      s.spec,
+			NextRunAt: s.schedule.Next(now),
+		})
+		if err != nil {
+			return fmt.Errorf("schedule %s: %w", s.name, err)
+		}
+	}
+
+	kinds := make([]string, 0, len(w.handlers))
+	for kind := range w.handlers {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+
+	// running jobs outlive ctx until the drain timeout
+	jobCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelJobs()
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, max(w.Concurrency, 1))
+	// a finished job frees a slot; look for more work right away
+	wake := make(chan struct{}, 1)
+
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		w.enqueueScheduled(ctx)
+
+		free := cap(slots) - len(slots)
+		if free > 0 && len(kinds) > 0 {
+			jobs, err := w.store.ClaimJobs(ctx, database.ClaimJobsParams{
+				LockedUntil: sql.NullTime{Time: time.Now().UTC().Add(w.LockDuration), Valid: true},
+				Kinds:       kinds,
+				MaxJobs:     int32(free),
+			})
+			if err != nil && ctx.Err() == nil {
+				log.Printf("claim jobs: %v", err)
+			}
+
+			for _, job := range jobs {
+				slots <- struct{}{}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					w.run(jobCtx, job)
+					<-slots
+					select {
+					case wake <- struct{}{}:
+					default:
+					}
+				}()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(w.DrainTimeout):
+		log.Printf("jobs: drain timed out after %v, canceling %d running jobs", w.DrainTimeout, len(slots))
+		cancelJobs()
+		<-drained
+	}
+	return nil
+}
+
+// enqueue-scheduled
+func (w *Worker) enqueueScheduled(ctx context.Context) {
+	now := time.Now().UTC()
+	for _, s := range w.schedules {
+		if now.Before(s.next) {
+			continue
+		}
+		s.next = s.schedule.Next(now)
+
+		_, err := w.store.EnqueueScheduledJob(ctx, database.EnqueueScheduledJobParams{
+			NextRunAt:   s.next,
+			Name:        s.name,
+			Kind:        s.kind,
+			Args:        s.args,
+			MaxAttempts: DefaultMaxAttempts,
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("schedule %s: %v", s.name, err)
+		}
+	}
+}
+
+// run-job
+// Runs one claimed job and records the outcome: done, retry later, or dead.
+func (w *Worker) run(ctx context.Context, job database.Job) {
+	runCtx, cancel := context.WithTimeout(ctx, w.LockDuration)
+	err := w.call(runCtx, job)
+	cancel()
+
+	finish := database.FinishJobParams{
+		ID:     job.ID,
+		Status: StatusSucceeded,
+		RunAt:  job.RunAt,
+	}
+
+	if err != nil {
+		finish.LastError = sql.NullString{String: err.Error(), Valid: true}
+
+		switch {
+		case ctx.Err() != nil:
+			// interrupted by shutdown, not the job's fault
+			finish.Status = StatusPending
+			finish.RunAt = time.Now().UTC()
+		case IsPermanent(err) || job.Attempts >= job.MaxAttempts:
+			finish.Status = StatusDead
+			log.Printf("job %s (%s) is dead after %d attempts: %v", job.ID, job.Kind, job.Attempts, err)
+		default:
+			finish.Status = StatusPending
+			finish.RunAt = time.Now().UTC().Add(RetryDelay(int(job.Attempts)))
+			log.Printf("job %s (%s) failed, attempt %d of %d: %v", job.ID, job.Kind, job.Attempts, job.MaxAttempts, err)
+		}
+	}
+
+	// the outcome is recorded even when the job was canceled
+	if err := w.store.FinishJob(context.WithoutCancel(ctx), finish); err != nil {
+		log.Printf("job %s: record outcome: %v", job.ID, err)
+	}
+}
+
+// call
+// A panicking handler fails its job instead of the worker.
+func (w *Worker) call(ctx context.Context, job database.Job) (err error) {
+	handler, ok := w.handlers[job.Kind]
+	if !ok {
+		return Permanent(errors.New("no handler for " + job.Kind))
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, job.Args)
+}
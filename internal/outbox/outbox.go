@@ -0,0 +1,229 @@
+// Package outbox is a transactional outbox for domain events.
+//
+// A handler writes its change and the event describing it in one
+// transaction, so there is never a change without its event or an event
+// without its change. A relay then hands events to in-process subscribers
+// one at a time, in id order. Subscribers get the relay's transaction, which
+// also marks the event published: whatever they write with it happens
+// exactly once per event. An event whose subscribers keep failing is retried
+// MaxAttempts times and then set aside as dead, so it doesn't hold up the
+// events after it for good.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/ratelimit"
+	"github.com/google/uuid"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusPublished = "published"
+	StatusDead      = "dead"
+
+	DefaultPollInterval = time.Second
+	DefaultMaxAttempts  = 10
+	maxRetryDelay       = time.Minute
+)
+
+// Event is a published outbox row.
+type Event struct {
+	ID        int64
+	EventID   uuid.UUID
+	Type      string
+	UserID    uuid.UUID
+	Payload   json.RawMessage
+	CreatedAt time.Time
+}
+
+// write
+// Records an event in the transaction q belongs to.
+func Write(ctx context.Context, q *database.Queries, eventType string, userID uuid.UUID, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("outbox %s: %w", eventType, err)
+	}
+
+	_, err = q.CreateOutboxEvent(ctx, database.CreateOutboxEventParams{
+		EventType: eventType,
+		UserID:    userID,
+		Payload:   payload,
+	})
+	return err
+}
+
+// Handler reacts to an event. Writes through q commit together with the
+// relay's progress; an error rolls both back and the event is retried.
+type Handler func(ctx context.Context, q *database.Queries, ev Event) error
+
+type subscription struct {
+	eventType string
+	handler   Handler
+}
+
+// Relay publishes outbox events to its subscribers. Any number of relays
+// can run; one at a time publishes.
+type Relay struct {
+	db            *sql.DB
+	queries       *database.Queries
+	subscriptions []subscription
+	wake          chan struct{}
+
+	PollInterval time.Duration
+	// attempts at an event before it is marked dead
+	MaxAttempts int
+}
+
+// new-relay
+func NewRelay(db *sql.DB, queries *database.Queries) *Relay {
+	return &Relay{
+		db:           db,
+		queries:      queries,
+		wake:         make(chan struct{}, 1),
+		PollInterval: DefaultPollInterval,
+		MaxAttempts:  DefaultMaxAttempts,
+	}
+}
+
+// subscribe
+// Handlers of an event run in the order they subscribed.
+func (r *Relay) Subscribe(eventType string, h Handler) {
+	r.subscriptions = append(r.subscriptions, subscription{eventType: eventType, handler: h})
+}
+
+// notify
+// Tells a relay running in this process that there may be new events.
+func (r *Relay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// publish-next
+// Publishes the oldest pending event. Reports false when there is none, or
+// when another relay is publishing.
+//
+// Event ids come from a sequence, and writers don't wait on each other, so
+// an event can commit after one with a larger id was published. It is
+// published when it shows up, instead of being skipped.
+func (r *Relay) PublishNext(ctx context.Context) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	q := r.queries.WithTx(tx)
+
+	locked, err := q.TryLockOutboxRelay(ctx)
+	if err != nil || !locked {
+		return false, err
+	}
+
+	row, err := q.NextOutboxEvent(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	ev := Event{
+		ID:        row.ID,
+		EventID:   row.EventID,
+		Type:      row.EventType,
+		UserID:    row.UserID,
+		Payload:   row.Payload,
+		CreatedAt: row.CreatedAt,
+	}
+	if err := r.publish(ctx, q, ev); err != nil {
+		// nothing the subscribers wrote is kept
+		tx.Rollback()
+		return r.fail(ctx, row, err)
+	}
+
+	if err := q.MarkOutboxEventPublished(ctx, ev.ID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// publish
+func (r *Relay) publish(ctx context.Context, q *database.Queries, ev Event) error {
+	for _, s := range r.subscriptions {
+		if s.eventType != ev.Type {
+			continue
+		}
+		if err := s.handler(ctx, q, ev); err != nil {
+			return fmt.Errorf("outbox event %d (%s): %w", ev.ID, ev.Type, err)
+		}
+	}
+	return nil
+}
+
+// fail
+// Records a failed attempt. The event is tried again, before any later one,
+// until it runs out of attempts; then it is dead and the relay moves on.
+func (r *Relay) fail(ctx context.Context, row database.OutboxEvent, cause error) (bool, error) {
+	params := database.FailOutboxEventParams{
+		ID:        row.ID,
+		Status:    StatusPending,
+		LastError: sql.NullString{String: cause.Error(), Valid: true},
+	}
+	dead := int(row.Attempts)+1 >= r.MaxAttempts
+	if dead {
+		params.Status = StatusDead
+	}
+
+	if err := r.queries.FailOutboxEvent(ctx, params); err != nil {
+		return false, errors.Join(cause, err)
+	}
+	if !dead {
+		return false, cause
+	}
+
+	log.Printf("%v (dead after %d attempts)", cause, row.Attempts+1)
+	return true, nil
+}
+
+// run
+// Publishes events as they come until ctx is done. A failing event holds up
+// the ones after it, to keep the order; it is retried with backoff until it
+// is dead.
+func (r *Relay) Run(ctx context.Context) {
+	failures := 0
+	for {
+		wait := r.PollInterval
+
+		ok, err := r.PublishNext(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			failures++
+			wait = ratelimit.BackoffDelay(failures, 1, r.PollInterval, maxRetryDelay)
+			log.Printf("%v (retrying in %v)", err, wait)
+		case ok:
+			failures = 0
+			continue
+		default:
+			failures = 0
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-r.wake:
+			timer.Stop()
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// prefixLength is how much of the SHA-1 picks a range, as in the Pwned
+// Passwords range API.
+const prefixLength = 5
+
+// Corpus is a local breached-password corpus in the k-anonymity layout of the
+// Pwned Passwords range API: a directory of files named after the first five
+// hex characters of a password's SHA-1 ("5BAA6.txt"), each holding
+// "SUFFIX:COUNT" lines for the hashes in that range. A check only ever reads
+// the one range file its prefix points at.
+type Corpus struct {
+	dir string
+}
+
+// open-corpus
+func OpenCorpus(dir string) (*Corpus, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &Corpus{dir: dir}, nil
+}
+
+// hash-prefix
+// Splits a password's uppercase SHA-1 into range prefix and suffix.
+func HashPrefix(password string) (string, string) {
+	sum := sha1.Sum([]byte(password))
+	h := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return h[:prefixLength], h[prefixLength:]
+}
+
+// count
+// How often the password appears in the corpus; 0 if it doesn't.
+func (c *Corpus) Count(password string) (int, error) {
+	prefix, suffix := HashPrefix(password)
+
+	f, err := os.Open(filepath.Join(c.dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		s, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || !strings.EqualFold(s, suffix) {
+			continue
+		}
+
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 0, fmt.Errorf("corpus range %s: invalid count %q", prefix, count)
+		}
+		return n, nil
+	}
+	return 0, scanner.Err()
+}
@@ -0,0 +1,228 @@
+package passwordpolicy
+
+import (
+	_ "embed"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Violation is one rule a password failed.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Policy decides whether a new password is acceptable. A zero value field
+// disables its rule.
+type Policy struct {
+	MinLength      int
+	MaxLength      int
+	MinEntropyBits float64
+	Breached       *Corpus
+}
+
+// DefaultPolicy follows NIST SP 800-63B: a length floor, no composition
+// rules, and a check against known weak and breached passwords.
+var DefaultPolicy = Policy{
+	MinLength:      8,
+	MaxLength:      256,
+	MinEntropyBits: 40,
+}
+
+//go:embed common.txt
+var commonList string
+
+// the most common passwords, most common first, normalized like the
+// passwords they are compared with
+var commonPasswords = normalizeAll(strings.Fields(commonList))
+
+// normalize-all
+func normalizeAll(words []string) []string {
+	for i, w := range words {
+		words[i] = normalize(w)
+	}
+	return words
+}
+
+// normalize
+// Undoes case and leetspeak, so "P@ssw0rd" and "password" compare equal.
+func normalize(s string) string {
+	runes := []rune(strings.ToLower(s))
+	for i, c := range runes {
+		runes[i] = unleet(c)
+	}
+	return string(runes)
+}
+
+// check
+// Returns every rule the password breaks; none means it is acceptable.
+func (p Policy) Check(password, email string) []Violation {
+	var violations []Violation
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{
+			Rule:    "min_length",
+			Message: fmt.Sprintf("Password must be at least %d characters", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{
+			Rule:    "max_length",
+			Message: fmt.Sprintf("Password must be at most %d characters", p.MaxLength),
+		})
+	}
+
+	if matchesEmail(password, email) {
+		violations = append(violations, Violation{
+			Rule:    "not_email",
+			Message: "Password must not be your email address",
+		})
+	}
+
+	if p.MinEntropyBits > 0 && EstimateEntropy(password) < p.MinEntropyBits {
+		violations = append(violations, Violation{
+			Rule:    "entropy",
+			Message: "Password is too easy to guess; avoid common words, repeats and sequences",
+		})
+	}
+
+	if p.Breached != nil && password != "" {
+		count, err := p.Breached.Count(password)
+		if err != nil {
+			// a broken corpus shouldn't block every signup
+			log.Printf("breached password check: %v", err)
+		} else if count > 0 {
+			violations = append(violations, Violation{
+				Rule:    "breached",
+				Message: "Password has appeared in a data breach; choose another one",
+			})
+		}
+	}
+
+	return violations
+}
+
+// matches-email
+func matchesEmail(password, email string) bool {
+	if email == "" || password == "" {
+		return false
+	}
+
+	password = strings.ToLower(password)
+	email = strings.ToLower(email)
+	local, _, _ := strings.Cut(email, "@")
+	return password == email || password == local
+}
+
+// estimate-entropy
+// A rough, zxcvbn-style estimate in bits: a common password prefix is worth
+// only its rank in the list, a repeated or sequential character about one
+// bit, and any other character the size of the character classes in use.
+func EstimateEntropy(password string) float64 {
+	if password == "" {
+		return 0
+	}
+
+	bits := 0.0
+	rest := []rune(password)
+
+	if n, rank := commonPrefix(password); n > 0 {
+		// rank plus a bit for capitalization or leetspeak
+		bits += math.Log2(float64(rank+1)) + 1
+		rest = rest[n:]
+	}
+
+	perChar := math.Log2(float64(poolSize(password)))
+	for i, c := range rest {
+		if i > 0 && isPredictable(rest[i-1], c) {
+			bits++
+			continue
+		}
+		bits += perChar
+	}
+	return bits
+}
+
+// common-prefix
+// Length in runes and rank of the longest common password the password starts
+// with, after undoing case and leetspeak.
+func commonPrefix(password string) (int, int) {
+	s := normalize(password)
+
+	bestLen, bestRank := 0, 0
+	for rank, word := range commonPasswords {
+		n := utf8.RuneCountInString(word)
+		if n >= 4 && n > bestLen && strings.HasPrefix(s, word) {
+			bestLen, bestRank = n, rank
+		}
+	}
+	return bestLen, bestRank
+}
+
+// unleet
+func unleet(c rune) rune {
+	switch c {
+	case '0':
+		return 'o'
+	case '1', '!':
+		return 'i'
+	case '3':
+		return 'e'
+	case '4', '@':
+		return 'a'
+	case '5', '$':
+		return 's'
+	case '7':
+		return 't'
+	}
+	return c
+}
+
+// is-predictable
+// Repeats ("aaa") and sequences ("abc", "321").
+func isPredictable(prev, c rune) bool {
+	d := c - prev
+	return d == 0 || d == 1 || d == -1
+}
+
+// pool-size
+func poolSize(password string) int {
+	var lower, upper, digit, symbol, other bool
+	for _, c := range password {
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		case c < unicode.MaxASCII && unicode.IsPrint(c):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	size := 0
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if symbol {
+		size += 33
+	}
+	if other {
+		size += 100
+	}
+	return size
+}
@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Backoff counts consecutive failures per key. Once threshold failures pile
+// up the key is blocked, for base at first and twice as long with every
+// further failure, up to max. Failures are forgotten after max without one.
+type Backoff struct {
+	mu        sync.Mutex
+	threshold int
+	base      time.Duration
+	max       time.Duration
+	entries   map[string]*backoffEntry
+	lastGC    time.Time
+}
+
+type backoffEntry struct {
+	failures     int
+	last         time.Time
+	blockedUntil time.Time
+}
+
+// new-backoff
+func NewBackoff(threshold int, base, max time.Duration) *Backoff {
+	return &Backoff{
+		threshold: threshold,
+		base:      base,
+		max:       max,
+		entries:   map[string]*backoffEntry{},
+	}
+}
+
+// backoff-delay
+// How long to block after the given number of consecutive failures.
+func BackoffDelay(failures, threshold int, base, max time.Duration) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+
+	delay := base
+	for i := threshold; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	return min(delay, max)
+}
+
+// blocked
+// Reports whether key is blocked, and for how much longer.
+func (b *Backoff) Blocked(key string) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e, ok := b.entries[key]
+	if !ok {
+		return 0, false
+	}
+	wait := time.Until(e.blockedUntil)
+	return wait, wait > 0
+}
+
+// fail
+// Records a failure and returns how long key is now blocked for.
+func (b *Backoff) Fail(key string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.gc(now)
+
+	e, ok := b.entries[key]
+	if !ok || now.Sub(e.last) >= b.max {
+		e = &backoffEntry{}
+		b.entries[key] = e
+	}
+	e.failures++
+	e.last = now
+
+	delay := BackoffDelay(e.failures, b.threshold, b.base, b.max)
+	if delay > 0 {
+		e.blockedUntil = now.Add(delay)
+	}
+	return delay
+}
+
+// reset
+func (b *Backoff) Reset(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.entries, key)
+}
+
+// gc
+func (b *Backoff) gc(now time.Time) {
+	if now.Sub(b.lastGC) < b.max {
+		return
+	}
+	b.lastGC = now
+
+	for key, e := range b.entries {
+		if now.Sub(e.last) >= b.max {
+			delete(b.entries, key)
+		}
+	}
+}
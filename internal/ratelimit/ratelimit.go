@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter is an in-memory token bucket per key. A bucket holds up to Limit
+// tokens and refills completely over Window.
+type Limiter struct {
+	mu      sync.Mutex
+	limit   float64
+	window  time.Duration
+	buckets map[string]*bucket
+	lastGC  time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// new-limiter
+func New(limit int, window time.Duration) *Limiter {
+	return &Limiter{
+		limit:   float64(limit),
+		window:  window,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// allow
+func (l *Limiter) Allow(key string) bool {
+	return l.AllowN(key, 1)
+}
+
+// allow-n
+func (l *Limiter) AllowN(key string, n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.gc(now)
+
+	b := l.refill(key, now)
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// refill
+func (l *Limiter) refill(key string, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.limit, last: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	elapsed := now.Sub(b.last)
+	b.tokens += l.limit * float64(elapsed) / float64(l.window)
+	if b.tokens > l.limit {
+		b.tokens = l.limit
+	}
+	b.last = now
+	return b
+}
+
+// gc
+// Full buckets carry no state, so they can be dropped.
+func (l *Limiter) gc(now time.Time) {
+	if now.Sub(l.lastGC) < l.window {
+		return
+	}
+	l.lastGC = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.window {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/Johnermac/http-server/internal/database"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// db gives every query a span named after its sqlc query.
+type db struct {
+	database.DBTX
+}
+
+// wrap-db
+// Traces queries through db, a *sql.DB or a *sql.Tx.
+func WrapDB(d database.DBTX) database.DBTX {
+	return db{d}
+}
+
+// query-name
+// sqlc starts every query with "-- name: GetUser :one".
+func queryName(query string) string {
+	if rest, ok := strings.CutPrefix(query, "-- name: "); ok {
+		if name, _, ok := strings.Cut(rest, " "); ok {
+			return name
+		}
+	}
+	return "query"
+}
+
+// start-query
+func startQuery(ctx context.Context, query string) (context.Context, trace.Span) {
+	return Start(ctx, queryName(query),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNamePostgreSQL,
+			semconv.DBQueryText(query),
+		),
+	)
+}
+
+// end-query
+// No rows is an answer, not a failure.
+func endQuery(span trace.Span, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	End(span, err)
+}
+
+// exec-context
+func (d db) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := startQuery(ctx, query)
+	res, err := d.DBTX.ExecContext(ctx, query, args...)
+	endQuery(span, err)
+	return res, err
+}
+
+// prepare-context
+func (d db) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	ctx, span := startQuery(ctx, query)
+	stmt, err := d.DBTX.PrepareContext(ctx, query)
+	endQuery(span, err)
+	return stmt, err
+}
+
+// query-context
+// The span covers running the query, not reading the rows.
+func (d db) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := startQuery(ctx, query)
+	rows, err := d.DBTX.QueryContext(ctx, query, args...)
+	endQuery(span, err)
+	return rows, err
+}
+
+// query-row-context
+func (d db) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := startQuery(ctx, query)
+	row := d.DBTX.QueryRowContext(ctx, query, args...)
+	endQuery(span, row.Err())
+	return row
+}
@@ -0,0 +1,166 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/ratelimit"
+)
+
+// headers on outbound deliveries; receivers verify them like we verify Polka
+const (
+	EventIDHeader   = "Chirpy-Event-Id"
+	EventTypeHeader = "Chirpy-Event-Type"
+	TimestampHeader = "Chirpy-Timestamp"
+	SignatureHeader = "Chirpy-Signature"
+
+	maxResponseLog = 1 << 10
+)
+
+// retry schedule: 30s, 1m, 2m, ... capped at 6h; all attempts span about 15 hours
+const (
+	MaxAttempts = 12
+	retryBase   = 30 * time.Second
+	retryMax    = 6 * time.Hour
+)
+
+var ErrPrivateAddress = errors.New("webhook URL resolves to a private address")
+
+// Delivery is one signed POST to a receiver.
+type Delivery struct {
+	URL       string
+	Secret    []byte
+	EventID   string
+	EventType string
+	Body      []byte
+}
+
+// Result is what the receiver answered, for the delivery log.
+type Result struct {
+	StatusCode int
+	// the start of the response body
+	Response string
+	Duration time.Duration
+}
+
+// retry-delay
+// How long to wait after the given number of failed attempts.
+func RetryDelay(attempts int) time.Duration {
+	return ratelimit.BackoffDelay(attempts, 1, retryBase, retryMax)
+}
+
+// new-secret
+func NewSecret() (string, error) {
+	key := make([]byte, 32) // 256-bit
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(key), nil
+}
+
+// validate-url
+// Receivers must be https, unless allowPrivate is set for local
+// development, which also allows http.
+func ValidateURL(raw string, allowPrivate bool) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return errors.New("invalid webhook URL")
+	}
+	if u.User != nil {
+		return errors.New("webhook URL can't contain credentials")
+	}
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && allowPrivate:
+	default:
+		return errors.New("webhook URL must use https")
+	}
+	return nil
+}
+
+// new-client
+// An HTTP client for deliveries. Unless allowPrivate is set it refuses to
+// connect to loopback, private and link-local addresses, checked on the
+// resolved IP so DNS can't be used to reach internal services. Redirects are
+// not followed.
+func NewClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || isPrivate(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// is-private
+func isPrivate(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+// deliver
+// Posts the delivery; anything but a 2xx answer is an error.
+func Deliver(ctx context.Context, client *http.Client, d Delivery) (Result, error) {
+	var result Result
+
+	req, err := http.NewRequestWithContext(ctx, "POST", d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return result, err
+	}
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set(EventIDHeader, d.EventID)
+	req.Header.Set(EventTypeHeader, d.EventType)
+	req.Header.Set(TimestampHeader, fmt.Sprint(now.Unix()))
+	req.Header.Set(SignatureHeader, Sign(d.Secret, now, d.Body))
+
+	resp, err := client.Do(req)
+	result.Duration = time.Since(now)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseLog))
+	result.StatusCode = resp.StatusCode
+	// it ends up in a Postgres TEXT column
+	result.Response = strings.ReplaceAll(strings.ToValidUTF8(string(body), "\uFFFD"), "\x00", "")
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return result, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return result, nil
+}
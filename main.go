@@ -1,47 +1,165 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/Johnermac/http-server/internal/api"
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/tracing"
 	_ "github.com/lib/pq"
 )
 
 var port = ":8080"
 
+// how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	cfg := api.NewAPIConfig()
 
+	// subcommands, e.g. `chirpy create-admin -email ...`
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(cfg, os.Args[1], os.Args[2:]))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx)
+	if err != nil {
+		log.Fatal("cannot set up tracing: ", err)
+	}
+	defer func() {
+		// send the spans still buffered
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			log.Printf("tracing shutdown: %v", err)
+		}
+	}()
+
+	var workers sync.WaitGroup
+	if cfg.RunJobsInServer {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			if err := runWorker(ctx, cfg); err != nil {
+				log.Printf("worker: %v", err)
+			}
+		}()
+	}
+
 	mux := http.NewServeMux()
 
 	// app
-	mux.Handle("GET /app/", cfg.MiddlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir("")))))
+	mux.Handle("GET /app/", http.StripPrefix("/app", http.FileServer(http.Dir(""))))
 
 	// misc
 	mux.HandleFunc("GET /api/healthz", api.HealthHandler)
-	mux.HandleFunc("GET /admin/metrics", cfg.MetricsHandler)
+	mux.HandleFunc("GET /metrics", cfg.MetricsHandler)
 
 	// chirps
 	mux.HandleFunc("GET /api/chirps/{chirpID}", cfg.GetChirpHandler)
 	mux.HandleFunc("GET /api/chirps", cfg.GetAllChirpsHandler)
 	mux.HandleFunc("POST /api/chirps", cfg.CreateChirpHandler)
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", cfg.UpdateChirpHandler)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.DeleteChirpHandler)
 
+	// feeds
+	mux.HandleFunc("GET /feeds/{file}", cfg.GlobalFeedHandler)
+	mux.HandleFunc("GET /feeds/users/{file}", cfg.UserFeedHandler)
+
+	// activitypub
+	mux.HandleFunc("GET /.well-known/webfinger", cfg.WebFingerHandler)
+	mux.HandleFunc("GET /ap/users/{userID}", cfg.ActorHandler)
+	mux.HandleFunc("GET /ap/users/{userID}/outbox", cfg.OutboxHandler)
+	mux.HandleFunc("GET /ap/users/{userID}/followers", cfg.FollowersHandler)
+	mux.HandleFunc("POST /ap/users/{userID}/inbox", cfg.InboxHandler)
+	mux.HandleFunc("GET /ap/chirps/{chirpID}", cfg.NoteHandler)
+
 	// users
 	mux.HandleFunc("POST /api/users", cfg.CreateUserHandler)
 	mux.HandleFunc("PUT /api/users", cfg.UpdateUserHandler)
+	mux.HandleFunc("GET /api/me/entitlements", cfg.GetEntitlementsHandler)
 	mux.HandleFunc("POST /api/login", cfg.LoginUserHandler)
-	mux.HandleFunc("POST /admin/reset", cfg.DeleteAllUsersHandler)
+	mux.HandleFunc("POST /api/login/mfa", cfg.LoginMFAHandler)
+	mux.HandleFunc("GET /api/auth/oidc/{provider}/start", cfg.OIDCStartHandler)
+	mux.HandleFunc("GET /api/auth/oidc/{provider}/callback", cfg.OIDCCallbackHandler)
+	mux.HandleFunc("GET /api/verify-email", cfg.VerifyEmailHandler)
+	mux.HandleFunc("POST /api/verify-email/resend", cfg.ResendVerificationHandler)
+	mux.HandleFunc("POST /api/password/forgot", cfg.ForgotPasswordHandler)
+	mux.HandleFunc("POST /api/password/reset", cfg.ResetPasswordHandler)
+	mux.Handle("POST /admin/reset", cfg.MiddlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.DeleteAllUsersHandler)))
+	mux.Handle("POST /admin/users/{userID}/unlock", cfg.MiddlewareRequireRole(auth.RoleModerator, http.HandlerFunc(cfg.UnlockUserHandler)))
+	mux.Handle("PUT /admin/users/{userID}/role", cfg.MiddlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.SetUserRoleHandler)))
 	mux.HandleFunc("POST /api/polka/webhooks", cfg.UpdatePremiumUserHandler)
+	mux.Handle("GET /admin/webhooks", cfg.MiddlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.ListWebhookEventsHandler)))
+	mux.Handle("POST /admin/webhooks/{eventID}/replay", cfg.MiddlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.ReplayWebhookEventHandler)))
+
+	// sessions
+	mux.HandleFunc("GET /api/me/sessions", cfg.ListSessionsHandler)
+	mux.HandleFunc("DELETE /api/me/sessions", cfg.RevokeOtherSessionsHandler)
+	mux.HandleFunc("DELETE /api/me/sessions/{sessionID}", cfg.RevokeSessionHandler)
+
+	// personal access tokens
+	mux.HandleFunc("POST /api/me/tokens", cfg.CreateAccessTokenHandler)
+	mux.HandleFunc("GET /api/me/tokens", cfg.ListAccessTokensHandler)
+	mux.HandleFunc("DELETE /api/me/tokens/{tokenID}", cfg.RevokeAccessTokenHandler)
+
+	// oauth
+	mux.HandleFunc("GET /.well-known/oauth-authorization-server", cfg.OAuthMetadataHandler)
+	mux.HandleFunc("GET /oauth/authorize", cfg.AuthorizeHandler)
+	mux.HandleFunc("POST /oauth/authorize", cfg.AuthorizeConsentHandler)
+	mux.HandleFunc("POST /oauth/token", cfg.OAuthTokenHandler)
+	mux.HandleFunc("POST /oauth/introspect", cfg.OAuthIntrospectHandler)
+	mux.HandleFunc("POST /oauth/revoke", cfg.OAuthRevokeHandler)
+	mux.HandleFunc("POST /api/oauth/clients", cfg.CreateOAuthClientHandler)
+	mux.HandleFunc("GET /api/oauth/clients", cfg.ListOAuthClientsHandler)
+	mux.HandleFunc("DELETE /api/oauth/clients/{clientID}", cfg.DeleteOAuthClientHandler)
+
+	// outbound webhooks
+	mux.HandleFunc("POST /api/webhooks", cfg.CreateWebhookEndpointHandler)
+	mux.HandleFunc("GET /api/webhooks", cfg.ListWebhookEndpointsHandler)
+	mux.HandleFunc("DELETE /api/webhooks/{endpointID}", cfg.DeleteWebhookEndpointHandler)
+	mux.HandleFunc("GET /api/webhooks/{endpointID}/deliveries", cfg.ListWebhookDeliveriesHandler)
+	mux.HandleFunc("GET /api/webhooks/{endpointID}/deliveries/{deliveryID}", cfg.GetWebhookDeliveryHandler)
+	mux.HandleFunc("POST /api/webhooks/{endpointID}/deliveries/{deliveryID}/redeliver", cfg.RedeliverWebhookHandler)
+
+	// 2fa
+	mux.HandleFunc("POST /api/2fa/enroll", cfg.EnrollTOTPHandler)
+	mux.HandleFunc("POST /api/2fa/confirm", cfg.ConfirmTOTPHandler)
+	mux.HandleFunc("POST /api/2fa/disable", cfg.DisableTOTPHandler)
 
 	// token
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.JWKSHandler)
 	mux.HandleFunc("POST /api/refresh", cfg.UpdateTokenHandler)
 	mux.HandleFunc("POST /api/revoke", cfg.RevokeTokenHandler)
 
 	server := &http.Server{
 		Addr:    port,
-		Handler: mux,
+		Handler: cfg.MiddlewareTracing(cfg.MiddlewareLogging(cfg.MiddlewareMetrics(mux))),
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}()
+
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
 	}
-	log.Fatal(server.ListenAndServe())
+	// let running jobs finish
+	workers.Wait()
 }
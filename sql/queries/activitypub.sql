@@ -0,0 +1,39 @@
+-- name: InsertActorKey :exec
+INSERT INTO actor_keys (user_id, public_key_pem, private_key_pem)
+VALUES (
+    $1, -- user_id
+    $2, -- public_key_pem
+    $3  -- private_key_pem
+)
+ON CONFLICT (user_id) DO NOTHING;
+
+-- name: GetActorKey :one
+SELECT user_id, created_at, public_key_pem, private_key_pem
+FROM actor_keys
+WHERE user_id = $1; -- user_id
+
+-- name: InsertFollower :exec
+INSERT INTO followers (user_id, actor_url, inbox_url)
+VALUES (
+    $1, -- user_id
+    $2, -- actor_url
+    $3  -- inbox_url
+)
+ON CONFLICT (user_id, actor_url) DO UPDATE
+SET inbox_url = EXCLUDED.inbox_url;
+
+-- name: DeleteFollower :exec
+DELETE FROM followers
+WHERE user_id = $1 -- user_id
+AND actor_url = $2; -- actor_url
+
+-- name: GetFollowers :many
+SELECT id, created_at, user_id, actor_url, inbox_url
+FROM followers
+WHERE user_id = $1 -- user_id
+ORDER BY created_at ASC;
+
+-- name: CountFollowers :one
+SELECT COUNT(*)
+FROM followers
+WHERE user_id = $1; -- user_id
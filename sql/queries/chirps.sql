@@ -8,22 +8,33 @@ WHERE user_id = $1 -- user_id
 ORDER BY created_at ASC;
 
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, attachments)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1, -- body
-    $2  -- user_id
+    $2, -- user_id
+    $3  -- attachments
 )
 RETURNING *;
 
 -- name: GetChirp :one
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, attachments
 FROM chirps
 WHERE id = $1; -- chirp_id
 
 -- name: DeleteChirp :exec
 DELETE FROM chirps
 WHERE user_id = $1 -- user_id
-AND id = $2; -- chirp_id
\ No newline at end of file
+AND id = $2; -- chirp_id
+
+-- name: UpdateChirp :one
+UPDATE chirps
+SET
+    updated_at = NOW(),
+    body = $3,
+    attachments = $4
+WHERE id = $1
+  AND user_id = $2
+RETURNING *;
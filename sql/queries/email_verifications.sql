@@ -0,0 +1,17 @@
+-- name: CreateEmailVerification :one
+INSERT INTO email_verifications (id, user_id, email, expires_at)
+VALUES (
+    $1, -- id (token jti)
+    $2, -- user_id
+    $3, -- email
+    $4  -- expires_at
+)
+RETURNING *;
+
+-- name: UseEmailVerification :one
+UPDATE email_verifications
+SET used_at = NOW()
+WHERE id = $1 -- id (token jti)
+  AND used_at IS NULL
+  AND expires_at > NOW()
+RETURNING *;
@@ -0,0 +1,64 @@
+-- name: EnqueueJob :execrows
+-- Does nothing when an untried job with the same unique key is waiting.
+INSERT INTO jobs (kind, args, max_attempts, run_at, unique_key)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (unique_key) WHERE status = 'pending' AND attempts = 0 DO NOTHING;
+
+-- name: ClaimJobs :many
+-- Locks due jobs of the given kinds for one worker; concurrent workers skip
+-- them.
+UPDATE jobs
+SET
+    status = 'running',
+    attempts = attempts + 1,
+    locked_until = sqlc.arg(locked_until)
+WHERE id IN (
+    SELECT id FROM jobs
+    WHERE kind = ANY(sqlc.arg(kinds)::TEXT[])
+      AND ((status = 'pending' AND run_at <= NOW())
+        OR (status = 'running' AND locked_until <= NOW()))
+    ORDER BY run_at
+    LIMIT sqlc.arg(max_jobs)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: FinishJob :exec
+UPDATE jobs
+SET
+    status = $2,
+    run_at = $3,
+    locked_until = NULL,
+    last_error = $4,
+    finished_at = CASE WHEN $2 IN ('succeeded', 'dead') THEN NOW() ELSE NULL END
+WHERE id = $1;
+
+-- name: PurgeJobs :execrows
+DELETE FROM jobs
+WHERE status IN ('succeeded', 'dead')
+  AND finished_at < $1;
+
+-- name: UpsertJobSchedule :exec
+-- A changed spec starts over from its own next run.
+INSERT INTO job_schedules (name, spec, next_run_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (name) DO UPDATE
+SET
+    spec = EXCLUDED.spec,
+    next_run_at = EXCLUDED.next_run_at
+WHERE job_schedules.spec <> EXCLUDED.spec;
+
+-- name: EnqueueScheduledJob :execrows
+-- Moves a due schedule on to its next run and enqueues the job in one
+-- statement, so only one of several workers gets each run.
+WITH due AS (
+    UPDATE job_schedules
+    SET next_run_at = sqlc.arg(next_run_at)
+    WHERE name = sqlc.arg(name)
+      AND next_run_at <= NOW()
+    RETURNING name
+)
+INSERT INTO jobs (kind, args, max_attempts, unique_key)
+SELECT sqlc.arg(kind), sqlc.arg(args), sqlc.arg(max_attempts), 'schedule:' || due.name
+FROM due
+ON CONFLICT (unique_key) WHERE status = 'pending' AND attempts = 0 DO NOTHING;
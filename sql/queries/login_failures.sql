@@ -0,0 +1,29 @@
+-- name: GetLoginFailures :one
+SELECT *
+FROM login_failures
+WHERE user_id = $1;
+
+-- name: RecordLoginFailure :one
+-- Counts consecutive failures; a day without one starts the count over.
+INSERT INTO login_failures (user_id, failed_attempts, last_failed_at)
+VALUES (
+    $1, -- user_id
+    1,
+    NOW()
+)
+ON CONFLICT (user_id) DO UPDATE
+SET failed_attempts = CASE
+        WHEN login_failures.last_failed_at < NOW() - INTERVAL '24 hours' THEN 1
+        ELSE login_failures.failed_attempts + 1
+    END,
+    last_failed_at = NOW()
+RETURNING failed_attempts;
+
+-- name: LockAccount :exec
+UPDATE login_failures
+SET locked_until = $2 -- locked_until
+WHERE user_id = $1;
+
+-- name: ClearLoginFailures :execrows
+DELETE FROM login_failures
+WHERE user_id = $1;
@@ -0,0 +1,68 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (owner_id, name, secret_hash, redirect_uris, scopes)
+VALUES (
+    $1, -- owner_id
+    $2, -- name
+    $3, -- secret_hash
+    $4, -- redirect_uris
+    $5  -- scopes
+)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT *
+FROM oauth_clients
+WHERE id = $1;
+
+-- name: ListOAuthClients :many
+SELECT *
+FROM oauth_clients
+WHERE owner_id = $1
+ORDER BY created_at DESC;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients
+WHERE id = $1 -- id
+  AND owner_id = $2;
+
+-- name: CreateAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at)
+VALUES (
+    $1, -- code_hash
+    $2, -- client_id
+    $3, -- user_id
+    $4, -- redirect_uri
+    $5, -- scopes
+    $6, -- code_challenge
+    $7  -- expires_at
+);
+
+-- name: UseAuthorizationCode :one
+-- Codes are single use; a second exchange finds no row.
+UPDATE oauth_authorization_codes
+SET used_at = NOW()
+WHERE code_hash = $1
+  AND used_at IS NULL
+  AND expires_at > NOW()
+RETURNING *;
+
+-- name: CreateOAuthAccessToken :exec
+INSERT INTO oauth_access_tokens (id, client_id, user_id, scopes, expires_at)
+VALUES (
+    $1, -- id
+    $2, -- client_id
+    $3, -- user_id
+    $4, -- scopes
+    $5  -- expires_at
+);
+
+-- name: GetOAuthAccessToken :one
+SELECT *
+FROM oauth_access_tokens
+WHERE id = $1;
+
+-- name: RevokeOAuthAccessToken :exec
+UPDATE oauth_access_tokens
+SET revoked_at = NOW()
+WHERE id = $1
+  AND revoked_at IS NULL;
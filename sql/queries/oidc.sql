@@ -0,0 +1,34 @@
+-- name: CreateOIDCLoginState :exec
+INSERT INTO oidc_login_states (state_hash, provider, nonce, code_verifier, expires_at)
+VALUES (
+    $1, -- state_hash
+    $2, -- provider
+    $3, -- nonce
+    $4, -- code_verifier
+    $5  -- expires_at
+);
+
+-- name: UseOIDCLoginState :one
+-- A state can only complete one login, and only for the provider it was started with.
+UPDATE oidc_login_states
+SET used_at = NOW()
+WHERE state_hash = $1 -- state_hash
+  AND provider = $2 -- provider
+  AND used_at IS NULL
+  AND expires_at > NOW()
+RETURNING *;
+
+-- name: GetUserIdentity :one
+SELECT *
+FROM user_identities
+WHERE provider = $1 -- provider
+  AND subject = $2;
+
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (user_id, provider, subject, email)
+VALUES (
+    $1, -- user_id
+    $2, -- provider
+    $3, -- subject
+    $4  -- email
+);
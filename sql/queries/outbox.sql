@@ -0,0 +1,43 @@
+-- name: CreateOutboxEvent :one
+INSERT INTO outbox_events (event_type, user_id, payload)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: TryLockOutboxRelay :one
+-- Only one relay publishes at a time, holding this until its transaction
+-- ends; the others get false.
+SELECT pg_try_advisory_xact_lock(7243);
+
+-- name: NextOutboxEvent :one
+-- The oldest pending event, by id.
+SELECT * FROM outbox_events
+WHERE status = 'pending'
+ORDER BY id
+LIMIT 1;
+
+-- name: MarkOutboxEventPublished :exec
+UPDATE outbox_events
+SET
+    status = 'published',
+    attempts = attempts + 1,
+    last_error = NULL,
+    finished_at = NOW()
+WHERE id = $1;
+
+-- name: FailOutboxEvent :exec
+-- Records a failed attempt: status is 'pending' to try again, or 'dead' to
+-- give up on the event.
+UPDATE outbox_events
+SET
+    status = $2,
+    attempts = attempts + 1,
+    last_error = $3,
+    finished_at = CASE WHEN $2 = 'dead' THEN NOW() ELSE NULL END
+WHERE id = $1
+  AND status = 'pending';
+
+-- name: PurgeOutboxEvents :execrows
+-- Published and dead events only.
+DELETE FROM outbox_events
+WHERE status IN ('published', 'dead')
+  AND finished_at < $1;
@@ -0,0 +1,29 @@
+-- name: CreatePasswordReset :exec
+INSERT INTO password_resets (token_hash, user_id)
+VALUES (
+    $1, -- token_hash
+    $2  -- user_id
+);
+
+-- name: UsePasswordReset :one
+UPDATE password_resets
+SET used_at = NOW()
+WHERE token_hash = $1 -- token_hash
+  AND used_at IS NULL
+  AND expires_at > NOW()
+RETURNING *;
+
+-- name: InvalidatePasswordResets :exec
+UPDATE password_resets
+SET used_at = NOW()
+WHERE user_id = $1 -- user_id
+  AND used_at IS NULL;
+
+-- name: GetPasswordResetEmail :one
+-- Looks at a reset without using it up.
+SELECT u.email
+FROM password_resets r
+JOIN users u ON u.id = r.user_id
+WHERE r.token_hash = $1 -- token_hash
+  AND r.used_at IS NULL
+  AND r.expires_at > NOW();
@@ -0,0 +1,37 @@
+-- name: CreatePersonalAccessToken :one
+INSERT INTO personal_access_tokens (user_id, name, token_hash, scopes, expires_at)
+VALUES (
+    $1, -- user_id
+    $2, -- name
+    $3, -- token_hash
+    $4, -- scopes
+    $5  -- expires_at
+)
+RETURNING *;
+
+-- name: GetPersonalAccessToken :one
+-- Only usable tokens: not revoked and not expired.
+SELECT *
+FROM personal_access_tokens
+WHERE token_hash = $1 -- token_hash
+  AND revoked_at IS NULL
+  AND (expires_at IS NULL OR expires_at > NOW());
+
+-- name: ListPersonalAccessTokens :many
+SELECT *
+FROM personal_access_tokens
+WHERE user_id = $1 -- user_id
+  AND revoked_at IS NULL
+ORDER BY created_at DESC;
+
+-- name: TouchPersonalAccessToken :exec
+UPDATE personal_access_tokens
+SET last_used_at = NOW()
+WHERE id = $1; -- id
+
+-- name: RevokePersonalAccessToken :execrows
+UPDATE personal_access_tokens
+SET revoked_at = NOW()
+WHERE id = $1 -- id
+  AND user_id = $2 -- user_id
+  AND revoked_at IS NULL;
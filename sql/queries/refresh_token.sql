@@ -1,26 +1,106 @@
 -- name: InsertRefreshToken :one
-INSERT INTO refresh_tokens (token, updated_at, user_id)
+-- A refresh is a use of the session, so the new token starts out used.
+INSERT INTO refresh_tokens (token_hash, updated_at, last_used_at, user_id, family_id, user_agent, ip)
 VALUES (
-    $1, -- token
+    $1, -- token_hash
     NOW(), 
-    $2 -- user_id
+    NOW(),
+    $2, -- user_id
+    $3, -- family_id
+    $4, -- user_agent
+    $5 -- ip
 )
 RETURNING *;
 
 
 -- name: GetRefreshToken :one
-SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+-- Revoked tokens are returned too, so callers can detect reuse of a rotated token.
+SELECT *
 FROM refresh_tokens
-WHERE token = $1 -- token
-  AND revoked_at IS NULL
-  AND expires_at > NOW();
+WHERE token_hash = $1; -- token_hash
 
 
 
 -- name: UpdateRevokeAt :one
 UPDATE refresh_tokens
+SET
+    revoked_at = NOW(),
+    updated_at = NOW(),
+    replaced_by = $2
+WHERE token_hash = $1
+  AND revoked_at IS NULL
+RETURNING *;
+
+
+-- name: RevokeAllRefreshTokens :exec
+UPDATE refresh_tokens
+SET
+    revoked_at = NOW(),
+    updated_at = NOW()
+WHERE user_id = $1
+  AND revoked_at IS NULL;
+
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET
+    revoked_at = NOW(),
+    updated_at = NOW()
+WHERE family_id = $1
+  AND revoked_at IS NULL;
+
+
+-- name: GetActiveSessions :many
+-- A session is a rotation family; only its current token is active.
+SELECT
+    t.family_id,
+    (SELECT MIN(f.created_at) FROM refresh_tokens f WHERE f.family_id = t.family_id)::TIMESTAMPTZ AS created_at,
+    t.last_used_at,
+    t.expires_at,
+    t.user_agent,
+    t.ip
+FROM refresh_tokens t
+WHERE t.user_id = $1
+  AND t.revoked_at IS NULL
+  AND t.expires_at > NOW()
+ORDER BY t.last_used_at DESC;
+
+
+-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens
+SET
+    revoked_at = NOW(),
+    updated_at = NOW()
+WHERE user_id = $1
+  AND family_id = $2
+  AND revoked_at IS NULL;
+
+
+-- name: TouchSession :one
+-- Whether the session is still active. It is marked used at most once a
+-- minute, so authenticated requests don't all write.
+WITH touched AS (
+    UPDATE refresh_tokens
+    SET last_used_at = NOW()
+    WHERE family_id = $1
+      AND revoked_at IS NULL
+      AND expires_at > NOW()
+      AND last_used_at < NOW() - INTERVAL '1 minute'
+)
+SELECT EXISTS (
+    SELECT 1
+    FROM refresh_tokens
+    WHERE family_id = $1
+      AND revoked_at IS NULL
+      AND expires_at > NOW()
+)::BOOLEAN AS active;
+
+
+-- name: RevokeOtherSessions :exec
+UPDATE refresh_tokens
 SET
     revoked_at = NOW(),
     updated_at = NOW()
-WHERE token = $1
-RETURNING *;
\ No newline at end of file
+WHERE user_id = $1
+  AND family_id <> $2
+  AND revoked_at IS NULL;
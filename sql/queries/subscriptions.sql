@@ -0,0 +1,27 @@
+-- name: GetSubscription :one
+SELECT * FROM subscriptions
+WHERE user_id = $1;
+
+-- name: UpsertSubscription :one
+INSERT INTO subscriptions (user_id, plan, status, current_period_end, grace_period_end)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (user_id) DO UPDATE
+SET
+    updated_at = NOW(),
+    plan = EXCLUDED.plan,
+    status = EXCLUDED.status,
+    current_period_end = EXCLUDED.current_period_end,
+    grace_period_end = EXCLUDED.grace_period_end
+RETURNING *;
+
+-- name: ExpireSubscriptions :execrows
+-- Active subscriptions lapse once a renewal is overdue by the grace period
+-- ($1 is now minus the grace period).
+UPDATE subscriptions
+SET
+    updated_at = NOW(),
+    status = 'expired',
+    grace_period_end = NULL
+WHERE (status = 'active' AND current_period_end <= $1)
+   OR (status = 'canceled' AND current_period_end <= NOW())
+   OR (status = 'past_due' AND grace_period_end <= NOW());
@@ -0,0 +1,49 @@
+-- name: UpsertPendingTOTP :exec
+INSERT INTO user_totp (user_id, secret)
+VALUES (
+    $1, -- user_id
+    $2  -- secret
+)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret,
+    created_at = NOW(),
+    last_used_step = 0
+WHERE user_totp.confirmed_at IS NULL;
+
+-- name: GetTOTP :one
+SELECT user_id, created_at, secret, confirmed_at, last_used_step
+FROM user_totp
+WHERE user_id = $1; -- user_id
+
+-- name: ConfirmTOTP :exec
+UPDATE user_totp
+SET confirmed_at = NOW()
+WHERE user_id = $1; -- user_id
+
+-- name: UseTOTPStep :execrows
+UPDATE user_totp
+SET last_used_step = $2 -- step
+WHERE user_id = $1 -- user_id
+  AND last_used_step < $2;
+
+-- name: DeleteTOTP :exec
+DELETE FROM user_totp
+WHERE user_id = $1; -- user_id
+
+-- name: InsertRecoveryCode :exec
+INSERT INTO recovery_codes (user_id, code_hash)
+VALUES (
+    $1, -- user_id
+    $2  -- code_hash
+);
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1 -- user_id
+  AND code_hash = $2 -- code_hash
+  AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = $1; -- user_id
@@ -1,12 +1,11 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password, is_chirpy_red)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1, -- email
-    $2,  -- password
-    false
+    $2  -- password
 )
 RETURNING *;
 
@@ -14,7 +13,7 @@ RETURNING *;
 DELETE FROM users;
 
 -- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red
+SELECT id, created_at, updated_at, email, hashed_password, email_verified_at, role
 FROM users
 WHERE email = $1; -- email
 
@@ -22,14 +21,50 @@ WHERE email = $1; -- email
 UPDATE users
 SET
     updated_at = NOW(),
+    email_verified_at = CASE WHEN email = $2 THEN email_verified_at ELSE NULL END,
     email = $2, -- email
     hashed_password = $3 -- password
 WHERE id = $1 -- user_id
 RETURNING *;
 
--- name: UpdatePremiumUser :exec
+-- name: GetUser :one
+SELECT id, created_at, updated_at, email, hashed_password, email_verified_at, role
+FROM users
+WHERE id = $1; -- user_id
+
+-- name: VerifyUserEmail :exec
 UPDATE users
 SET
     updated_at = NOW(),
-    is_chirpy_red = $2 -- is_chirpy_red    
+    email_verified_at = NOW()
+WHERE id = $1 -- user_id
+AND email = $2; -- email
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET
+    updated_at = NOW(),
+    hashed_password = $2 -- password
 WHERE id = $1; -- user_id
+
+-- name: UpdateUserRole :one
+UPDATE users
+SET
+    updated_at = NOW(),
+    role = $2
+WHERE id = $1
+RETURNING *;
+
+-- name: CountUsersByRole :one
+SELECT COUNT(*)
+FROM users
+WHERE role = $1;
+
+-- name: LockUsersByRole :many
+-- Locks the rows until the transaction ends, in id order so that concurrent
+-- callers queue up instead of deadlocking.
+SELECT id
+FROM users
+WHERE role = $1
+ORDER BY id
+FOR UPDATE;
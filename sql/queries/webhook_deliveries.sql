@@ -0,0 +1,70 @@
+-- name: EnqueueWebhookDeliveries :execrows
+-- One delivery per endpoint subscribed to the event: the user's own, and
+-- the ones receiving every user's events.
+INSERT INTO webhook_deliveries (endpoint_id, event_id, event_type, payload)
+SELECT e.id, $1, $2, $3
+FROM webhook_endpoints e
+WHERE $2 = ANY(e.events)
+  AND (e.owner_id = $4 OR e.all_users);
+
+-- name: ClaimWebhookDeliveries :many
+-- Locks due deliveries for one worker; concurrent workers skip them.
+UPDATE webhook_deliveries d
+SET
+    status = 'delivering',
+    locked_until = $2
+FROM webhook_endpoints e
+WHERE e.id = d.endpoint_id
+  AND d.id IN (
+    SELECT id FROM webhook_deliveries
+    WHERE (status = 'pending' AND next_attempt_at <= NOW())
+       OR (status = 'delivering' AND locked_until <= NOW())
+    ORDER BY next_attempt_at
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+  )
+RETURNING d.id, d.event_id, d.event_type, d.payload, d.attempts, e.url, e.secret;
+
+-- name: RecordWebhookDeliveryAttempt :exec
+INSERT INTO webhook_delivery_attempts (delivery_id, status_code, response, error, duration_ms)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: FinishWebhookDelivery :exec
+UPDATE webhook_deliveries
+SET
+    status = $2,
+    attempts = attempts + 1,
+    next_attempt_at = $3,
+    locked_until = NULL,
+    last_status_code = $4,
+    last_error = $5,
+    delivered_at = CASE WHEN $2 = 'succeeded' THEN NOW() ELSE delivered_at END
+WHERE id = $1;
+
+-- name: GetWebhookDelivery :one
+SELECT * FROM webhook_deliveries
+WHERE id = $1
+  AND endpoint_id = $2;
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_deliveries
+WHERE endpoint_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- name: ListWebhookDeliveryAttempts :many
+SELECT * FROM webhook_delivery_attempts
+WHERE delivery_id = $1
+ORDER BY attempted_at ASC;
+
+-- name: RedeliverWebhookDelivery :one
+-- Queues a delivery again right away, whatever its state, unless a worker
+-- has it.
+UPDATE webhook_deliveries
+SET
+    status = 'pending',
+    next_attempt_at = NOW()
+WHERE id = $1
+  AND endpoint_id = $2
+  AND status <> 'delivering'
+RETURNING *;
@@ -0,0 +1,18 @@
+-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (owner_id, url, secret, events, all_users)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetWebhookEndpoint :one
+SELECT * FROM webhook_endpoints
+WHERE id = $1;
+
+-- name: ListWebhookEndpoints :many
+SELECT * FROM webhook_endpoints
+WHERE owner_id = $1
+ORDER BY created_at DESC;
+
+-- name: DeleteWebhookEndpoint :execrows
+DELETE FROM webhook_endpoints
+WHERE id = $1
+  AND owner_id = $2;
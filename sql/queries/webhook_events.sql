@@ -0,0 +1,47 @@
+-- name: CreateWebhookEvent :one
+-- Returns no rows when the event was already received.
+INSERT INTO webhook_events (provider, event_id, event_type, payload)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (provider, event_id) DO NOTHING
+RETURNING *;
+
+-- name: GetWebhookEvent :one
+SELECT * FROM webhook_events
+WHERE id = $1;
+
+-- name: GetWebhookEventByEventID :one
+SELECT * FROM webhook_events
+WHERE provider = $1
+  AND event_id = $2;
+
+-- name: RetryWebhookEvent :one
+-- Claims a failed event, or one whose attempt has held it since before
+-- stale_before, for another attempt; no rows if it's neither.
+UPDATE webhook_events
+SET
+    status = 'processing',
+    claimed_at = NOW()
+WHERE id = sqlc.arg(id)
+  AND (status = 'failed'
+    OR (status = 'processing' AND claimed_at < sqlc.arg(stale_before)))
+RETURNING *;
+
+-- name: FinishWebhookEvent :exec
+UPDATE webhook_events
+SET
+    status = $2,
+    attempts = attempts + 1,
+    last_error = $3,
+    processed_at = CASE WHEN $2 = 'failed' THEN processed_at ELSE NOW() END
+WHERE id = $1;
+
+-- name: ListWebhookEvents :many
+SELECT * FROM webhook_events
+ORDER BY received_at DESC
+LIMIT $1;
+
+-- name: ListWebhookEventsByStatus :many
+SELECT * FROM webhook_events
+WHERE status = $1
+ORDER BY received_at DESC
+LIMIT $2;
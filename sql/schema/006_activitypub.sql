@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE actor_keys (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    public_key_pem TEXT NOT NULL,
+    private_key_pem TEXT NOT NULL
+);
+
+CREATE TABLE followers (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    actor_url TEXT NOT NULL,
+    inbox_url TEXT NOT NULL,
+    UNIQUE (user_id, actor_url)
+);
+
+-- +goose Down
+DROP TABLE followers;
+DROP TABLE actor_keys;
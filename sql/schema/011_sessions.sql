@@ -0,0 +1,41 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+DROP CONSTRAINT refresh_tokens_pkey;
+
+ALTER TABLE refresh_tokens
+RENAME COLUMN token TO token_hash;
+
+-- tokens are only ever stored as their SHA-256 from now on
+UPDATE refresh_tokens
+SET
+    token_hash = encode(sha256(token_hash::bytea), 'hex'),
+    replaced_by = CASE WHEN replaced_by IS NULL THEN NULL ELSE encode(sha256(replaced_by::bytea), 'hex') END;
+
+ALTER TABLE refresh_tokens
+ADD COLUMN id UUID NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+ADD COLUMN last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+ADD COLUMN ip TEXT NOT NULL DEFAULT '',
+ADD CONSTRAINT refresh_tokens_token_hash_key UNIQUE (token_hash);
+
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+-- +goose Down
+-- plaintext tokens can't be recovered, so every session is dropped
+DELETE FROM refresh_tokens;
+
+DROP INDEX refresh_tokens_user_id_idx;
+
+ALTER TABLE refresh_tokens
+DROP CONSTRAINT refresh_tokens_token_hash_key,
+DROP CONSTRAINT refresh_tokens_pkey,
+DROP COLUMN ip,
+DROP COLUMN user_agent,
+DROP COLUMN last_used_at,
+DROP COLUMN id;
+
+ALTER TABLE refresh_tokens
+RENAME COLUMN token_hash TO token;
+
+ALTER TABLE refresh_tokens
+ADD PRIMARY KEY (token);
@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE login_failures (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    failed_attempts INT NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMPTZ NULL DEFAULT NULL
+);
+
+-- +goose Down
+DROP TABLE login_failures;
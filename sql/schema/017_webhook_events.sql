@@ -0,0 +1,21 @@
+-- +goose Up
+-- every inbound webhook, so redeliveries are processed once and failures can be replayed
+CREATE TABLE webhook_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    received_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    provider TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'processing'
+        CHECK (status IN ('processing', 'processed', 'ignored', 'failed')),
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NULL DEFAULT NULL,
+    processed_at TIMESTAMPTZ NULL DEFAULT NULL,
+    UNIQUE (provider, event_id)
+);
+
+CREATE INDEX webhook_events_status_idx ON webhook_events (status, received_at);
+
+-- +goose Down
+DROP TABLE webhook_events;
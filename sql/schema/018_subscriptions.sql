@@ -0,0 +1,36 @@
+-- +goose Up
+CREATE TABLE subscriptions (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    plan TEXT NOT NULL,
+    status TEXT NOT NULL
+        CHECK (status IN ('active', 'past_due', 'canceled', 'expired')),
+    current_period_end TIMESTAMPTZ NOT NULL,
+    -- set while past_due
+    grace_period_end TIMESTAMPTZ NULL DEFAULT NULL
+);
+
+CREATE INDEX subscriptions_status_idx ON subscriptions (status);
+
+-- existing Chirpy Red users get a period to be renewed in
+INSERT INTO subscriptions (user_id, plan, status, current_period_end)
+SELECT id, 'chirpy_red', 'active', NOW() + INTERVAL '30 days'
+FROM users
+WHERE is_chirpy_red;
+
+ALTER TABLE users
+DROP COLUMN is_chirpy_red;
+
+-- +goose Down
+ALTER TABLE users
+ADD COLUMN is_chirpy_red BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE users
+SET is_chirpy_red = true
+WHERE id IN (
+    SELECT user_id FROM subscriptions
+    WHERE status IN ('active', 'past_due', 'canceled')
+);
+
+DROP TABLE subscriptions;
@@ -0,0 +1,54 @@
+-- +goose Up
+CREATE TABLE webhook_endpoints (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    -- HMAC key, kept in the clear since every delivery is signed with it
+    secret TEXT NOT NULL,
+    events TEXT[] NOT NULL,
+    -- admin endpoints receive every user's events, not just the owner's
+    all_users BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE INDEX webhook_endpoints_owner_idx ON webhook_endpoints (owner_id);
+
+-- the delivery queue: one row per event and endpoint
+CREATE TABLE webhook_deliveries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    endpoint_id UUID NOT NULL REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+    event_id UUID NOT NULL,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'delivering', 'succeeded', 'dead')),
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- a worker that dies mid-delivery releases the row when this passes
+    locked_until TIMESTAMPTZ NULL DEFAULT NULL,
+    last_status_code INT NULL DEFAULT NULL,
+    last_error TEXT NULL DEFAULT NULL,
+    delivered_at TIMESTAMPTZ NULL DEFAULT NULL
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at)
+    WHERE status IN ('pending', 'delivering');
+CREATE INDEX webhook_deliveries_endpoint_idx ON webhook_deliveries (endpoint_id, created_at);
+
+CREATE TABLE webhook_delivery_attempts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    attempted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delivery_id UUID NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+    status_code INT NULL DEFAULT NULL,
+    response TEXT NULL DEFAULT NULL,
+    error TEXT NULL DEFAULT NULL,
+    duration_ms INT NOT NULL
+);
+
+CREATE INDEX webhook_delivery_attempts_delivery_idx ON webhook_delivery_attempts (delivery_id, attempted_at);
+
+-- +goose Down
+DROP TABLE webhook_delivery_attempts;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhook_endpoints;
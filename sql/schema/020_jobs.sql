@@ -0,0 +1,37 @@
+-- +goose Up
+CREATE TABLE jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    kind TEXT NOT NULL,
+    args JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'running', 'succeeded', 'dead')),
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL,
+    run_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- a worker that dies mid-job releases the row when this passes
+    locked_until TIMESTAMPTZ NULL DEFAULT NULL,
+    unique_key TEXT NULL DEFAULT NULL,
+    last_error TEXT NULL DEFAULT NULL,
+    finished_at TIMESTAMPTZ NULL DEFAULT NULL
+);
+
+CREATE INDEX jobs_due_idx ON jobs (run_at)
+    WHERE status IN ('pending', 'running');
+-- a unique key only dedupes jobs that haven't been tried yet, so a retry
+-- never collides with a newer copy
+CREATE UNIQUE INDEX jobs_unique_key_idx ON jobs (unique_key)
+    WHERE status = 'pending' AND attempts = 0;
+CREATE INDEX jobs_finished_idx ON jobs (finished_at)
+    WHERE finished_at IS NOT NULL;
+
+-- cron schedules, shared by every worker so each run is enqueued once
+CREATE TABLE job_schedules (
+    name TEXT PRIMARY KEY,
+    spec TEXT NOT NULL,
+    next_run_at TIMESTAMPTZ NOT NULL
+);
+
+-- +goose Down
+DROP TABLE job_schedules;
+DROP TABLE jobs;
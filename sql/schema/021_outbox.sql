@@ -0,0 +1,26 @@
+-- +goose Up
+-- domain events, written in the same transaction as the change they describe
+CREATE TABLE outbox_events (
+    -- publish order: ids are taken under an advisory lock held until commit
+    -- (see LockOutbox), so a relay never sees an id before a smaller one
+    id BIGSERIAL PRIMARY KEY,
+    event_id UUID NOT NULL UNIQUE DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    event_type TEXT NOT NULL,
+    -- the user the event is about; no foreign key, events outlive users
+    user_id UUID NOT NULL,
+    payload JSONB NOT NULL
+);
+
+-- how far each relay has published
+CREATE TABLE outbox_cursors (
+    name TEXT PRIMARY KEY,
+    last_event_id BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO outbox_cursors (name) VALUES ('default');
+
+-- +goose Down
+DROP TABLE outbox_cursors;
+DROP TABLE outbox_events;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- when the current attempt started: one that never finishes (the process
+-- died) can be taken over once it's stale
+ALTER TABLE webhook_events ADD COLUMN claimed_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+-- +goose Down
+ALTER TABLE webhook_events DROP COLUMN claimed_at;
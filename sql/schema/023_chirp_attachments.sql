@@ -0,0 +1,8 @@
+-- +goose Up
+-- links to media hosted elsewhere; how many a chirp may have depends on the plan
+ALTER TABLE chirps
+ADD COLUMN attachments TEXT[] NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE chirps
+DROP COLUMN attachments;
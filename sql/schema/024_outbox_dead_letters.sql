@@ -0,0 +1,45 @@
+-- +goose Up
+-- events are published by id, one relay at a time (see TryLockOutboxRelay),
+-- and each keeps its own state instead of a cursor: writers no longer wait on
+-- each other, so an event can commit after one with a larger id, and it is
+-- still published when it shows up
+ALTER TABLE outbox_events
+    ADD COLUMN status TEXT NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'published', 'dead')),
+    ADD COLUMN attempts INT NOT NULL DEFAULT 0,
+    ADD COLUMN last_error TEXT NULL DEFAULT NULL,
+    ADD COLUMN finished_at TIMESTAMPTZ NULL DEFAULT NULL;
+
+UPDATE outbox_events
+SET
+    status = 'published',
+    finished_at = NOW()
+WHERE id <= (SELECT last_event_id FROM outbox_cursors WHERE name = 'default');
+
+CREATE INDEX outbox_events_pending_idx ON outbox_events (id)
+    WHERE status = 'pending';
+CREATE INDEX outbox_events_finished_idx ON outbox_events (finished_at)
+    WHERE finished_at IS NOT NULL;
+
+DROP TABLE outbox_cursors;
+
+-- +goose Down
+CREATE TABLE outbox_cursors (
+    name TEXT PRIMARY KEY,
+    last_event_id BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO outbox_cursors (name, last_event_id)
+SELECT 'default', COALESCE(MIN(id) - 1, (SELECT COALESCE(MAX(id), 0) FROM outbox_events))
+FROM outbox_events
+WHERE status = 'pending';
+
+DROP INDEX outbox_events_finished_idx;
+DROP INDEX outbox_events_pending_idx;
+
+ALTER TABLE outbox_events
+    DROP COLUMN finished_at,
+    DROP COLUMN last_error,
+    DROP COLUMN attempts,
+    DROP COLUMN status;
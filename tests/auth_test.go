@@ -196,3 +196,32 @@ Pass
 	fmt.Println("---------------------------------")
 	fmt.Printf("%d passed, %d failed\n", passCount, failCount)
 }
+
+func TestMakeSessionJWT(t *testing.T) {
+	secret := "supersecret"
+	userID := uuid.New()
+	sessionID := uuid.New()
+
+	tokenString, err := auth.MakeSessionJWT(userID, sessionID, secret)
+	if err != nil {
+		t.Fatalf("MakeSessionJWT error: %v", err)
+	}
+
+	claims, err := auth.ValidateAccessToken(tokenString, secret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims.Subject != userID.String() || claims.SessionID != sessionID.String() {
+		t.Errorf("unexpected claims: sub %q sid %q", claims.Subject, claims.SessionID)
+	}
+
+	// plain access tokens carry no session
+	tokenString, _ = auth.MakeJWT(userID, secret)
+	claims, err = auth.ValidateAccessToken(tokenString, secret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims.SessionID != "" {
+		t.Errorf("expected no session claim, got %q", claims.SessionID)
+	}
+}
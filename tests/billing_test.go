@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/billing"
+)
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	policy := billing.Policy{Period: 30 * 24 * time.Hour, GracePeriod: 7 * 24 * time.Hour}
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	// upgrade without a period end from the provider
+	sub, err := policy.Apply(nil, billing.Event{Type: billing.EventUpgraded}, now)
+	if err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	if sub.Plan != billing.PlanChirpyRed || sub.Status != billing.StatusActive || !sub.CurrentPeriodEnd.Equal(now.Add(30*day)) {
+		t.Fatalf("upgrade: got %+v", sub)
+	}
+	if !policy.Entitled(sub, now) {
+		t.Error("active subscription should be entitled")
+	}
+
+	// payment fails: past due with a grace period that repeated failures don't extend
+	now = now.Add(30 * day)
+	sub, _ = policy.Apply(&sub, billing.Event{Type: billing.EventPaymentFailed}, now)
+	if sub.Status != billing.StatusPastDue || !sub.GracePeriodEnd.Equal(now.Add(7*day)) {
+		t.Fatalf("payment failed: got %+v", sub)
+	}
+	sub, _ = policy.Apply(&sub, billing.Event{Type: billing.EventPaymentFailed}, now.Add(2*day))
+	if !sub.GracePeriodEnd.Equal(now.Add(7 * day)) {
+		t.Errorf("second failure moved the grace period to %v", sub.GracePeriodEnd)
+	}
+	if !policy.Entitled(sub, now.Add(6*day)) || policy.Entitled(sub, now.Add(7*day)) {
+		t.Error("past due subscription should be entitled until the grace period ends")
+	}
+
+	// renewal with an explicit period end clears the grace period
+	periodEnd := now.Add(31 * day)
+	sub, _ = policy.Apply(&sub, billing.Event{Type: billing.EventRenewed, CurrentPeriodEnd: periodEnd}, now.Add(3*day))
+	if sub.Status != billing.StatusActive || !sub.GracePeriodEnd.IsZero() || !sub.CurrentPeriodEnd.Equal(periodEnd) {
+		t.Fatalf("renewal: got %+v", sub)
+	}
+
+	// cancellation keeps the plan until the period ends
+	sub, _ = policy.Apply(&sub, billing.Event{Type: "user.cancelled"}, now.Add(4*day))
+	if sub.Status != billing.StatusCanceled {
+		t.Fatalf("cancel: got %+v", sub)
+	}
+	if !policy.Entitled(sub, periodEnd.Add(-time.Second)) || policy.Entitled(sub, periodEnd) {
+		t.Error("canceled subscription should be entitled until the period ends")
+	}
+
+	// downgrade to free ends it now
+	sub, _ = policy.Apply(&sub, billing.Event{Type: billing.EventUpgraded}, now.Add(5*day))
+	sub, _ = policy.Apply(&sub, billing.Event{Type: billing.EventDowngraded, Plan: billing.PlanFree}, now.Add(6*day))
+	if sub.Status != billing.StatusExpired || policy.Entitled(sub, now.Add(6*day)) {
+		t.Fatalf("downgrade: got %+v", sub)
+	}
+}
+
+func TestSubscriptionEventsWithoutSubscription(t *testing.T) {
+	policy := billing.DefaultPolicy
+	now := time.Now()
+	expired := &billing.Subscription{Plan: billing.PlanChirpyRed, Status: billing.StatusExpired, CurrentPeriodEnd: now.Add(-time.Hour)}
+
+	for _, typ := range []string{billing.EventDowngraded, billing.EventCanceled, billing.EventPaymentFailed} {
+		if _, err := policy.Apply(nil, billing.Event{Type: typ}, now); !errors.Is(err, billing.ErrInvalidEvent) {
+			t.Errorf("%s without a subscription: error = %v, want %v", typ, err, billing.ErrInvalidEvent)
+		}
+		if _, err := policy.Apply(expired, billing.Event{Type: typ}, now); !errors.Is(err, billing.ErrInvalidEvent) {
+			t.Errorf("%s on an expired subscription: error = %v, want %v", typ, err, billing.ErrInvalidEvent)
+		}
+	}
+
+	if _, err := policy.Apply(nil, billing.Event{Type: billing.EventRenewed}, now); !errors.Is(err, billing.ErrInvalidEvent) {
+		t.Errorf("renewal without a subscription: error = %v, want %v", err, billing.ErrInvalidEvent)
+	}
+	if sub, err := policy.Apply(expired, billing.Event{Type: billing.EventRenewed}, now); err != nil || sub.Status != billing.StatusActive {
+		t.Errorf("renewal of an expired subscription = %+v, %v", sub, err)
+	}
+	if _, err := policy.Apply(nil, billing.Event{Type: "user.exploded"}, now); !errors.Is(err, billing.ErrInvalidEvent) {
+		t.Errorf("unknown event: error = %v, want %v", err, billing.ErrInvalidEvent)
+	}
+}
+
+func TestSubscriptionLateRenewal(t *testing.T) {
+	policy := billing.Policy{Period: 30 * 24 * time.Hour, GracePeriod: 24 * time.Hour}
+	periodEnd := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	sub := billing.Subscription{Plan: billing.PlanChirpyRed, Status: billing.StatusActive, CurrentPeriodEnd: periodEnd}
+
+	if !policy.Entitled(sub, periodEnd.Add(23*time.Hour)) {
+		t.Error("an overdue renewal should be tolerated for the grace period")
+	}
+	if policy.Entitled(sub, periodEnd.Add(24*time.Hour)) {
+		t.Error("an active subscription should lapse after the grace period")
+	}
+}
@@ -0,0 +1,227 @@
+package tests
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/api"
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/billing"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/entitlements"
+	"github.com/Johnermac/http-server/internal/outbox"
+	"github.com/google/uuid"
+)
+
+// memoryChirps answers the queries writing a chirp runs.
+type memoryChirps struct {
+	mu     sync.Mutex
+	users  map[uuid.UUID]database.User
+	chirps map[uuid.UUID]*database.Chirp
+}
+
+func newMemoryChirps(db *fakeDB) *memoryChirps {
+	s := &memoryChirps{
+		users:  map[uuid.UUID]database.User{},
+		chirps: map[uuid.UUID]*database.Chirp{},
+	}
+
+	db.handle("GetUser", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if u, ok := s.users[argUUID(args[0])]; ok {
+			return fakeRows(u), nil
+		}
+		return fakeResult{}, nil
+	})
+	// nobody subscribed: everyone is on the free plan
+	db.handle("GetSubscription", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	db.handle("GetChirp", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if c, ok := s.chirps[argUUID(args[0])]; ok {
+			return fakeRows(*c), nil
+		}
+		return fakeResult{}, nil
+	})
+	db.handle("CreateChirp", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		c := &database.Chirp{
+			ID:          uuid.New(),
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+			Body:        argString(args[0]),
+			UserID:      argUUID(args[1]),
+			Attachments: argStrings(args[2]),
+		}
+		s.chirps[c.ID] = c
+		return fakeRows(*c), nil
+	})
+	db.handle("UpdateChirp", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		c, ok := s.chirps[argUUID(args[0])]
+		if !ok || c.UserID != argUUID(args[1]) {
+			return fakeResult{}, nil
+		}
+		c.UpdatedAt = time.Now()
+		c.Body = argString(args[2])
+		c.Attachments = argStrings(args[3])
+		return fakeRows(*c), nil
+	})
+	db.handle("CreateOutboxEvent", func(args []driver.Value) (fakeResult, error) {
+		return fakeRows(database.OutboxEvent{ID: 1, EventID: uuid.New(), CreatedAt: time.Now(), EventType: argString(args[0]), UserID: argUUID(args[1]), Payload: json.RawMessage(argString(args[2]))}), nil
+	})
+	return s
+}
+
+// addUser stores a user and returns a session token for them.
+func (s *memoryChirps) addUser(t *testing.T, ks *auth.Keyset, verified bool) (uuid.UUID, string) {
+	t.Helper()
+	u := database.User{ID: uuid.New(), CreatedAt: time.Now(), UpdatedAt: time.Now(), Email: uuid.NewString() + "@example.com", Role: auth.RoleUser}
+	if verified {
+		u.EmailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+	s.mu.Lock()
+	s.users[u.ID] = u
+	s.mu.Unlock()
+
+	token, err := ks.MakeSessionJWT(u.ID, uuid.Nil)
+	if err != nil {
+		t.Fatalf("MakeSessionJWT: %v", err)
+	}
+	return u.ID, token
+}
+
+// addChirp stores a chirp as if userID had just posted it.
+func (s *memoryChirps) addChirp(userID uuid.UUID, attachments ...string) uuid.UUID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := &database.Chirp{ID: uuid.New(), CreatedAt: time.Now(), UpdatedAt: time.Now(), Body: "hello", UserID: userID, Attachments: attachments}
+	s.chirps[c.ID] = c
+	return c.ID
+}
+
+func newChirpsConfig(t *testing.T) (*api.APIConfig, *memoryChirps) {
+	db := newFakeDB(t)
+	chirps := newMemoryChirps(db)
+	return &api.APIConfig{
+		DB:     fakeQueries(db),
+		SQLDB:  db.sqlDB(),
+		Outbox: outbox.NewRelay(nil, nil),
+		Keyset: auth.NewHMACKeyset("supersecret"),
+		Entitlements: entitlements.NewEngine(entitlements.Plans{
+			billing.PlanFree: {MaxChirpLength: 140, ChirpsPerHour: 100, MaxAttachments: 2, EditWindow: entitlements.Duration(15 * time.Minute)},
+		}),
+		Billing: billing.DefaultPolicy,
+		Metrics: api.NewAppMetrics(db.sqlDB()),
+		BaseURL: "https://chirpy.example.com",
+	}, chirps
+}
+
+type chirpResponse struct {
+	Id          uuid.UUID `json:"id"`
+	Body        string    `json:"body"`
+	Attachments []string  `json:"attachments"`
+	Error       string    `json:"error"`
+}
+
+// writeChirp posts a new chirp, or edits chirpID if it isn't uuid.Nil.
+func writeChirp(cfg *api.APIConfig, token string, chirpID uuid.UUID, body string) (int, chirpResponse) {
+	rec := httptest.NewRecorder()
+	if chirpID == uuid.Nil {
+		req := httptest.NewRequest("POST", "/api/chirps", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		cfg.CreateChirpHandler(rec, req)
+	} else {
+		req := httptest.NewRequest("PUT", "/api/chirps/"+chirpID.String(), strings.NewReader(body))
+		req.SetPathValue("chirpID", chirpID.String())
+		req.Header.Set("Authorization", "Bearer "+token)
+		cfg.UpdateChirpHandler(rec, req)
+	}
+
+	var res chirpResponse
+	json.Unmarshal(rec.Body.Bytes(), &res)
+	return rec.Code, res
+}
+
+func TestChirpAttachments(t *testing.T) {
+	cfg, chirps := newChirpsConfig(t)
+	_, token := chirps.addUser(t, cfg.Keyset, true)
+
+	code, res := writeChirp(cfg, token, uuid.Nil, `{"body": "no pictures"}`)
+	if code != 201 || res.Attachments == nil || len(res.Attachments) != 0 {
+		t.Errorf("chirp without attachments = %d %+v, want 201 with []", code, res)
+	}
+
+	two := []string{"https://img.example.com/a.png", "https://img.example.com/b.png"}
+	code, res = writeChirp(cfg, token, uuid.Nil, `{"body": "two pictures", "attachments": ["`+strings.Join(two, `", "`)+`"]}`)
+	if code != 201 || !slices.Equal(res.Attachments, two) {
+		t.Errorf("chirp with two attachments = %d %+v", code, res)
+	}
+
+	invalid := map[string]string{
+		"more than the plan allows": `["https://img.example.com/a.png", "https://img.example.com/b.png", "https://img.example.com/c.png"]`,
+		"plain http":                `["http://img.example.com/a.png"]`,
+		"relative":                  `["/a.png"]`,
+		"not a URL":                 `["javascript:alert(1)"]`,
+	}
+	for name, attachments := range invalid {
+		if code, res := writeChirp(cfg, token, uuid.Nil, `{"body": "hi", "attachments": `+attachments+`}`); code != 400 {
+			t.Errorf("%s = %d %+v, want 400", name, code, res)
+		}
+	}
+}
+
+func TestUpdateChirpAttachments(t *testing.T) {
+	cfg, chirps := newChirpsConfig(t)
+	userID, token := chirps.addUser(t, cfg.Keyset, true)
+	chirpID := chirps.addChirp(userID, "https://img.example.com/a.png")
+
+	// left out: kept
+	code, res := writeChirp(cfg, token, chirpID, `{"body": "edited"}`)
+	if code != 200 || res.Body != "edited" || !slices.Equal(res.Attachments, []string{"https://img.example.com/a.png"}) {
+		t.Errorf("edit without attachments = %d %+v, want them kept", code, res)
+	}
+
+	// given: replaced
+	code, res = writeChirp(cfg, token, chirpID, `{"body": "edited", "attachments": []}`)
+	if code != 200 || len(res.Attachments) != 0 {
+		t.Errorf("edit with no attachments = %d %+v, want them removed", code, res)
+	}
+	if code, res := writeChirp(cfg, token, chirpID, `{"body": "edited", "attachments": ["ftp://img.example.com/a.png"]}`); code != 400 {
+		t.Errorf("edit with an invalid attachment = %d %+v, want 400", code, res)
+	}
+}
+
+func TestWritingChirpsRequiresVerifiedEmail(t *testing.T) {
+	cfg, chirps := newChirpsConfig(t)
+	cfg.RequireVerifiedEmail = true
+	unverifiedID, unverified := chirps.addUser(t, cfg.Keyset, false)
+	verifiedID, verified := chirps.addUser(t, cfg.Keyset, true)
+
+	if code, res := writeChirp(cfg, unverified, uuid.Nil, `{"body": "hello"}`); code != 403 || res.Error != "Email address not verified" {
+		t.Errorf("create by an unverified user = %d %+v, want 403", code, res)
+	}
+	// a chirp from before verification was required can't be edited either
+	if code, res := writeChirp(cfg, unverified, chirps.addChirp(unverifiedID), `{"body": "edited"}`); code != 403 || res.Error != "Email address not verified" {
+		t.Errorf("edit by an unverified user = %d %+v, want 403", code, res)
+	}
+
+	if code, res := writeChirp(cfg, verified, uuid.Nil, `{"body": "hello"}`); code != 201 {
+		t.Errorf("create by a verified user = %d %+v", code, res)
+	}
+	if code, res := writeChirp(cfg, verified, chirps.addChirp(verifiedID), `{"body": "edited"}`); code != 200 {
+		t.Errorf("edit by a verified user = %d %+v", code, res)
+	}
+}
@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/helpers"
+)
+
+func TestValidateEmail(t *testing.T) {
+	tests := []struct {
+		email     string
+		expectErr bool
+	}{
+		{"user@example.com", false},
+		{"first.last+tag@sub.example.org", false},
+		{"", true},
+		{"not-an-email", true},
+		{"user@localhost", true},
+		{"User <user@example.com>", true},
+		{"user@example.com\r\nBcc: x@example.com", true},
+	}
+
+	for _, tc := range tests {
+		err := helpers.ValidateEmail(tc.email)
+		if (err != nil) != tc.expectErr {
+			t.Errorf("ValidateEmail(%q): expected error %v, got %v", tc.email, tc.expectErr, err)
+		}
+	}
+}
+
+func TestEmailVerificationToken(t *testing.T) {
+	secret := "supersecret"
+	userID := uuid.New()
+
+	token, id, err := auth.MakeEmailVerificationToken(userID, "user@example.com", secret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeEmailVerificationToken error: %v", err)
+	}
+
+	gotUser, gotEmail, gotID, err := auth.ValidateEmailVerificationToken(token, secret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotUser != userID || gotEmail != "user@example.com" || gotID != id {
+		t.Errorf("unexpected claims: %v %q %v", gotUser, gotEmail, gotID)
+	}
+
+	if _, _, _, err := auth.ValidateEmailVerificationToken(token, "wrongsecret"); err == nil {
+		t.Errorf("expected error for wrong secret")
+	}
+
+	// a verification token must never work as an access token
+	if _, err := auth.ValidateJWT(token, secret); err == nil {
+		t.Errorf("expected ValidateJWT to reject verification token")
+	}
+}
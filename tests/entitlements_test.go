@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/billing"
+	"github.com/Johnermac/http-server/internal/entitlements"
+)
+
+func TestEntitlementsDefaults(t *testing.T) {
+	e := entitlements.NewEngine(entitlements.DefaultPlans)
+
+	free := e.For(billing.PlanFree)
+	if free.MaxChirpLength != 140 || free.EditWindow != 0 {
+		t.Errorf("free plan = %+v", free)
+	}
+	red := e.For(billing.PlanChirpyRed)
+	if red.MaxChirpLength <= free.MaxChirpLength || red.ChirpsPerHour <= free.ChirpsPerHour || red.MaxAttachments <= free.MaxAttachments || red.EditWindow <= 0 {
+		t.Errorf("chirpy_red plan = %+v should unlock more than free", red)
+	}
+	if got := e.For("no_such_plan"); got != free {
+		t.Errorf("unknown plan = %+v, want the free plan", got)
+	}
+}
+
+func TestEntitlementsChirpRateLimit(t *testing.T) {
+	e := entitlements.NewEngine(entitlements.Plans{
+		billing.PlanFree:      {MaxChirpLength: 140, ChirpsPerHour: 2},
+		billing.PlanChirpyRed: {MaxChirpLength: 500, ChirpsPerHour: 3},
+	})
+
+	for i := 0; i < 2; i++ {
+		if !e.AllowChirp(billing.PlanFree, "alice") {
+			t.Fatalf("chirp %d should be allowed", i+1)
+		}
+	}
+	if e.AllowChirp(billing.PlanFree, "alice") {
+		t.Error("third free chirp within the hour should be limited")
+	}
+	if !e.AllowChirp(billing.PlanFree, "bob") {
+		t.Error("limits should be per user")
+	}
+	for i := 0; i < 3; i++ {
+		if !e.AllowChirp(billing.PlanChirpyRed, "carol") {
+			t.Fatalf("chirpy_red chirp %d should be allowed", i+1)
+		}
+	}
+	if e.AllowChirp(billing.PlanChirpyRed, "carol") {
+		t.Error("fourth chirpy_red chirp within the hour should be limited")
+	}
+}
+
+func TestEntitlementsEditWindow(t *testing.T) {
+	posted := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := entitlements.Capabilities{EditWindow: entitlements.Duration(15 * time.Minute)}
+
+	if !c.CanEdit(posted, posted.Add(15*time.Minute)) {
+		t.Error("should be editable at the end of the window")
+	}
+	if c.CanEdit(posted, posted.Add(15*time.Minute+time.Second)) {
+		t.Error("should not be editable after the window")
+	}
+	if (entitlements.Capabilities{}).CanEdit(posted, posted) {
+		t.Error("a zero edit window should disable editing")
+	}
+}
+
+func TestLoadPlans(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	plans, err := entitlements.LoadPlans(write("plans.json", `{
+		"free": {"max_chirp_length": 200, "chirps_per_hour": 10},
+		"chirpy_red": {"max_chirp_length": 2000, "chirps_per_hour": 100, "edit_window": "1h"}
+	}`))
+	if err != nil {
+		t.Fatalf("LoadPlans error: %v", err)
+	}
+	if plans[billing.PlanFree].MaxChirpLength != 200 || plans[billing.PlanChirpyRed].EditWindow != entitlements.Duration(time.Hour) {
+		t.Errorf("LoadPlans = %+v", plans)
+	}
+
+	invalid := map[string]string{
+		"missing free plan":    `{"chirpy_red": {"max_chirp_length": 2000, "chirps_per_hour": 100}}`,
+		"zero length":          `{"free": {"max_chirp_length": 0, "chirps_per_hour": 10}}`,
+		"negative attachments": `{"free": {"max_chirp_length": 140, "chirps_per_hour": 10, "max_attachments": -1}}`,
+		"bad duration":         `{"free": {"max_chirp_length": 140, "chirps_per_hour": 10, "edit_window": "soon"}}`,
+		"numeric duration":     `{"free": {"max_chirp_length": 140, "chirps_per_hour": 10, "edit_window": 60}}`,
+	}
+	for name, data := range invalid {
+		if _, err := entitlements.LoadPlans(write("invalid.json", data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestEntitlementsJSON(t *testing.T) {
+	b, err := json.Marshal(entitlements.Capabilities{MaxChirpLength: 140, ChirpsPerHour: 30, MaxAttachments: 2, EditWindow: entitlements.Duration(90 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"max_chirp_length":140,"chirps_per_hour":30,"max_attachments":2,"edit_window":"1m30s"}`
+	if string(b) != want {
+		t.Errorf("Marshal = %s, want %s", b, want)
+	}
+}
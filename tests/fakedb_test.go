@@ -0,0 +1,261 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// fakeDB is an in-memory database/sql driver for handler tests. Queries are
+// dispatched on their sqlc name; a test answers the ones its handlers run,
+// usually from maps it keeps, and any other query fails the test.
+type fakeDB struct {
+	t       *testing.T
+	mu      sync.Mutex
+	queries map[string]fakeQuery
+	// see atomic
+	snapshot func() (restore func())
+}
+
+// fakeQuery answers one sqlc query. Rows are the values of sqlc's row
+// struct, or model, in field order; see fakeRows.
+type fakeQuery func(args []driver.Value) (fakeResult, error)
+
+type fakeResult struct {
+	rows     [][]driver.Value
+	affected int64
+}
+
+func newFakeDB(t *testing.T) *fakeDB {
+	return &fakeDB{t: t, queries: map[string]fakeQuery{}}
+}
+
+// handle answers the named query from now on.
+func (db *fakeDB) handle(name string, q fakeQuery) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.queries[name] = q
+}
+
+// atomic makes transactions roll back: snapshot is called when one begins
+// and returns what undoes everything since. Tests that don't need this get
+// transactions that keep every write.
+func (db *fakeDB) atomic(snapshot func() (restore func())) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.snapshot = snapshot
+}
+
+// sqlDB opens a *sql.DB over the fake.
+func (db *fakeDB) sqlDB() *sql.DB {
+	return sql.OpenDB(fakeConnector{db})
+}
+
+func (db *fakeDB) run(query string, args []driver.NamedValue) (fakeResult, error) {
+	name := "?"
+	if rest, ok := strings.CutPrefix(query, "-- name: "); ok {
+		name, _, _ = strings.Cut(rest, " ")
+	}
+
+	db.mu.Lock()
+	q, ok := db.queries[name]
+	db.mu.Unlock()
+	if !ok {
+		db.t.Errorf("unexpected query %s", name)
+		return fakeResult{}, fmt.Errorf("fakedb: unexpected query %s", name)
+	}
+
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		values[i] = a.Value
+	}
+	return q(values)
+}
+
+// fakeRows turns models or sqlc row structs into result rows.
+func fakeRows(structs ...any) fakeResult {
+	var res fakeResult
+	for _, s := range structs {
+		v := reflect.ValueOf(s)
+		row := make([]driver.Value, v.NumField())
+		for i := range row {
+			row[i] = fakeValue(v.Field(i).Interface())
+		}
+		res.rows = append(res.rows, row)
+	}
+	return res
+}
+
+// fakeAffected is the result of an :exec or :execrows query.
+func fakeAffected(n int64) fakeResult {
+	return fakeResult{affected: n}
+}
+
+// fakeValue converts a struct field the way database/sql converts an
+// argument.
+func fakeValue(v any) driver.Value {
+	if s, ok := v.([]string); ok {
+		v = pq.Array(s)
+	}
+	if valuer, ok := v.(driver.Valuer); ok {
+		dv, err := valuer.Value()
+		if err != nil {
+			panic(err)
+		}
+		return dv
+	}
+	dv, err := driver.DefaultParameterConverter.ConvertValue(v)
+	if err != nil {
+		panic(err)
+	}
+	return dv
+}
+
+// argUUID reads a UUID argument.
+func argUUID(v driver.Value) uuid.UUID {
+	switch v := v.(type) {
+	case string:
+		return uuid.MustParse(v)
+	case []byte:
+		return uuid.MustParse(string(v))
+	}
+	return uuid.Nil
+}
+
+// argString reads a string argument, "" for NULL.
+func argString(v driver.Value) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// argStrings reads a text[] argument.
+func argStrings(v driver.Value) []string {
+	var a pq.StringArray
+	if err := a.Scan(v); err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// argTime reads a timestamp argument, zero for NULL.
+func argTime(v driver.Value) time.Time {
+	t, _ := v.(time.Time)
+	return t
+}
+
+type fakeConnector struct {
+	db *fakeDB
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return fakeConn{c.db}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, fmt.Errorf("fakedb: use sql.OpenDB")
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("fakedb: prepared statements aren't supported")
+}
+
+func (c fakeConn) Close() error { return nil }
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	c.db.mu.Lock()
+	snapshot := c.db.snapshot
+	c.db.mu.Unlock()
+	if snapshot == nil {
+		return fakeTx{}, nil
+	}
+	return fakeTx{restore: snapshot()}, nil
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRowSet{rows: res.rows}, nil
+}
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(res.affected), nil
+}
+
+// transactions aren't isolated, and only roll back on an atomic fakeDB
+type fakeTx struct {
+	restore func()
+}
+
+func (fakeTx) Commit() error { return nil }
+
+func (tx fakeTx) Rollback() error {
+	if tx.restore != nil {
+		tx.restore()
+	}
+	return nil
+}
+
+type fakeRowSet struct {
+	rows [][]driver.Value
+	next int
+}
+
+func (r *fakeRowSet) Columns() []string {
+	if len(r.rows) == 0 {
+		// sqlc scans by position; with no rows the count doesn't matter
+		return nil
+	}
+	cols := make([]string, len(r.rows[0]))
+	for i := range cols {
+		cols[i] = fmt.Sprintf("c%d", i)
+	}
+	return cols
+}
+
+func (r *fakeRowSet) Close() error { return nil }
+
+func (r *fakeRowSet) Next(dest []driver.Value) error {
+	if r.next == len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
+
+// fakeQueries is database.Queries over db.
+func fakeQueries(db *fakeDB) *database.Queries {
+	return database.New(db.sqlDB())
+}
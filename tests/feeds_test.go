@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/feeds"
+)
+
+func TestRenderFeedsEscapesBody(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	feed := feeds.Feed{
+		ID:      "urn:uuid:feed",
+		Title:   "Chirpy",
+		SelfURL: "http://localhost:8080/feeds/chirps.atom",
+		SiteURL: "http://localhost:8080/api/chirps",
+		Updated: now,
+		Entries: []feeds.Entry{{
+			ID:        "urn:uuid:entry",
+			URL:       "http://localhost:8080/api/chirps/entry",
+			Author:    "someone",
+			Body:      `<script>alert("x")</script> & friends`,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}},
+	}
+
+	renderers := map[string]func(feeds.Feed) ([]byte, error){
+		"atom": feeds.RenderAtom,
+		"rss":  feeds.RenderRSS,
+	}
+
+	for name, render := range renderers {
+		t.Run(name, func(t *testing.T) {
+			body, err := render(feed)
+			if err != nil {
+				t.Fatalf("render error: %v", err)
+			}
+			if strings.Contains(string(body), "<script>") {
+				t.Errorf("chirp body was not escaped:\n%s", body)
+			}
+
+			// must still be well-formed XML
+			var v struct{}
+			if err := xml.Unmarshal(body, &v); err != nil {
+				t.Errorf("invalid XML: %v", err)
+			}
+		})
+	}
+}
+
+func TestRenderAtomUsesUpdatedTimestamps(t *testing.T) {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	updated := created.Add(time.Hour)
+
+	body, err := feeds.RenderAtom(feeds.Feed{
+		ID:      "urn:uuid:feed",
+		Updated: updated,
+		Entries: []feeds.Entry{{ID: "urn:uuid:entry", Body: "hi", CreatedAt: created, UpdatedAt: updated}},
+	})
+	if err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+
+	if !strings.Contains(string(body), "<updated>2025-01-01T01:00:00Z</updated>") {
+		t.Errorf("expected updated timestamp in feed:\n%s", body)
+	}
+	if !strings.Contains(string(body), "<published>2025-01-01T00:00:00Z</published>") {
+		t.Errorf("expected published timestamp in feed:\n%s", body)
+	}
+}
@@ -0,0 +1,291 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/jobs"
+	"github.com/google/uuid"
+)
+
+func TestCronSchedule(t *testing.T) {
+	from := time.Date(2025, 3, 14, 10, 7, 30, 0, time.UTC) // a Friday
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2025, 3, 14, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2025, 3, 14, 10, 15, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2025, 3, 14, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2025, 3, 17, 9, 30, 0, 0, time.UTC)},
+		{"0 0 1 */3 *", time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC)},
+		// both day fields restricted: either one matches
+		{"0 0 20 * 0", time.Date(2025, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{"5,10 10 * * *", time.Date(2025, 3, 14, 10, 10, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		s, err := jobs.ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%q.Next = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "@often"} {
+		if _, err := jobs.ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) should fail", spec)
+		}
+	}
+}
+
+func TestJobRetryDelay(t *testing.T) {
+	if got := jobs.RetryDelay(1); got != 10*time.Second {
+		t.Errorf("RetryDelay(1) = %v", got)
+	}
+	if got := jobs.RetryDelay(3); got != 40*time.Second {
+		t.Errorf("RetryDelay(3) = %v", got)
+	}
+	if got := jobs.RetryDelay(30); got != time.Hour {
+		t.Errorf("RetryDelay(30) = %v", got)
+	}
+}
+
+// memoryJobStore mimics the jobs queries closely enough to drive a worker.
+type memoryJobStore struct {
+	mu   sync.Mutex
+	jobs []*database.Job
+}
+
+func (s *memoryJobStore) EnqueueJob(ctx context.Context, arg database.EnqueueJobParams) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, j := range s.jobs {
+		if arg.UniqueKey.Valid && j.UniqueKey == arg.UniqueKey && j.Status == jobs.StatusPending && j.Attempts == 0 {
+			return 0, nil
+		}
+	}
+	s.jobs = append(s.jobs, &database.Job{
+		ID:          uuid.New(),
+		Kind:        arg.Kind,
+		Args:        arg.Args,
+		Status:      jobs.StatusPending,
+		MaxAttempts: arg.MaxAttempts,
+		RunAt:       arg.RunAt,
+		UniqueKey:   arg.UniqueKey,
+	})
+	return 1, nil
+}
+
+func (s *memoryJobStore) ClaimJobs(ctx context.Context, arg database.ClaimJobsParams) ([]database.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var claimed []database.Job
+	for _, j := range s.jobs {
+		if len(claimed) == int(arg.MaxJobs) {
+			break
+		}
+		if j.Status != jobs.StatusPending || j.RunAt.After(time.Now()) || !slices.Contains(arg.Kinds, j.Kind) {
+			continue
+		}
+		j.Status = jobs.StatusRunning
+		j.Attempts++
+		j.LockedUntil = arg.LockedUntil
+		claimed = append(claimed, *j)
+	}
+	return claimed, nil
+}
+
+func (s *memoryJobStore) FinishJob(ctx context.Context, arg database.FinishJobParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, j := range s.jobs {
+		if j.ID == arg.ID {
+			j.Status = arg.Status
+			j.RunAt = arg.RunAt
+			j.LastError = arg.LastError
+		}
+	}
+	return nil
+}
+
+func (s *memoryJobStore) UpsertJobSchedule(ctx context.Context, arg database.UpsertJobScheduleParams) error {
+	return nil
+}
+
+func (s *memoryJobStore) EnqueueScheduledJob(ctx context.Context, arg database.EnqueueScheduledJobParams) (int64, error) {
+	return 0, nil
+}
+
+// status waits for the job to have been claimed and to reach one of the
+// statuses.
+func (s *memoryJobStore) status(t *testing.T, id int, want ...string) database.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		j := *s.jobs[id]
+		s.mu.Unlock()
+
+		if slices.Contains(want, j.Status) && j.LockedUntil.Valid {
+			return j
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %d (%s) is %s, want %v", id, j.Kind, j.Status, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type greetJob struct {
+	Name string `json:"name"`
+}
+
+func (greetJob) Kind() string { return "greet" }
+
+type flakyJob struct {
+	Permanent bool `json:"permanent"`
+}
+
+func (flakyJob) Kind() string { return "flaky" }
+
+type panicJob struct{}
+
+func (panicJob) Kind() string { return "panic" }
+
+type slowJob struct{}
+
+func (slowJob) Kind() string { return "slow" }
+
+func TestWorkerRunsJobs(t *testing.T) {
+	store := &memoryJobStore{}
+	client := jobs.NewClient(store)
+	ctx := context.Background()
+
+	w := jobs.NewWorker(store)
+	w.PollInterval = 10 * time.Millisecond
+
+	greeted := make(chan string, 1)
+	jobs.Register(w, func(ctx context.Context, args greetJob) error {
+		greeted <- args.Name
+		return nil
+	})
+	jobs.Register(w, func(ctx context.Context, args flakyJob) error {
+		if args.Permanent {
+			return jobs.Permanent(errors.New("bad input"))
+		}
+		return errors.New("try again")
+	})
+	jobs.Register(w, func(ctx context.Context, args panicJob) error {
+		panic("boom")
+	})
+
+	client.Enqueue(ctx, greetJob{Name: "ada"}, jobs.Options{UniqueKey: "greet"})
+	if ok, _ := client.Enqueue(ctx, greetJob{Name: "bob"}, jobs.Options{UniqueKey: "greet"}); ok {
+		t.Error("a job with a waiting unique key should be dropped")
+	}
+	client.Enqueue(ctx, flakyJob{}, jobs.Options{MaxAttempts: 5})
+	client.Enqueue(ctx, flakyJob{Permanent: true}, jobs.Options{})
+	client.Enqueue(ctx, panicJob{}, jobs.Options{MaxAttempts: 1})
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() { done <- w.Run(runCtx) }()
+
+	if name := <-greeted; name != "ada" {
+		t.Errorf("greeted %q", name)
+	}
+	store.status(t, 0, jobs.StatusSucceeded)
+
+	retried := store.status(t, 1, jobs.StatusPending)
+	if retried.Attempts != 1 || time.Until(retried.RunAt) < 5*time.Second || retried.LastError.String != "try again" {
+		t.Errorf("failed job should be retried later: %+v", retried)
+	}
+
+	if dead := store.status(t, 2, jobs.StatusDead); !dead.LastError.Valid {
+		t.Error("dead job should keep its error")
+	}
+	if dead := store.status(t, 3, jobs.StatusDead); dead.LastError.String != "panic: boom" {
+		t.Errorf("panic recorded as %q", dead.LastError.String)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run: %v", err)
+	}
+}
+
+func TestWorkerDrainsOnShutdown(t *testing.T) {
+	store := &memoryJobStore{}
+	w := jobs.NewWorker(store)
+	w.PollInterval = 10 * time.Millisecond
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	jobs.Register(w, func(ctx context.Context, _ slowJob) error {
+		close(started)
+		<-release
+		return nil
+	})
+	jobs.NewClient(store).Enqueue(context.Background(), slowJob{}, jobs.Options{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	<-started
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("Run returned before its running job finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-done
+	store.status(t, 0, jobs.StatusSucceeded)
+}
+
+func TestWorkerCancelsJobsAfterDrainTimeout(t *testing.T) {
+	store := &memoryJobStore{}
+	w := jobs.NewWorker(store)
+	w.PollInterval = 10 * time.Millisecond
+	w.DrainTimeout = 20 * time.Millisecond
+
+	started := make(chan struct{})
+	jobs.Register(w, func(ctx context.Context, _ slowJob) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	jobs.NewClient(store).Enqueue(context.Background(), slowJob{}, jobs.Options{MaxAttempts: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	<-started
+	cancel()
+	<-done
+
+	// interrupted, so back in the queue even though it was its last attempt
+	j := store.status(t, 0, jobs.StatusPending)
+	if time.Until(j.RunAt) > time.Second {
+		t.Errorf("interrupted job should be retried right away, not at %v", j.RunAt)
+	}
+}
@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/Johnermac/http-server/internal/auth"
+)
+
+func newTestKeys(t *testing.T) (auth.SigningKey, auth.SigningKey) {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey error: %v", err)
+	}
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey error: %v", err)
+	}
+
+	return auth.SigningKey{ID: "rsa-1", Alg: "RS256", Private: rsaKey, Public: &rsaKey.PublicKey},
+		auth.SigningKey{ID: "ed-1", Alg: "EdDSA", Private: edPriv, Public: edPub}
+}
+
+func TestKeysetRotation(t *testing.T) {
+	rsaKey, edKey := newTestKeys(t)
+	userID := uuid.New()
+
+	// before rotation: RSA signs
+	before, err := auth.NewKeyset(rsaKey)
+	if err != nil {
+		t.Fatalf("NewKeyset error: %v", err)
+	}
+	oldToken, err := before.MakeSessionJWT(userID, uuid.Nil)
+	if err != nil {
+		t.Fatalf("MakeSessionJWT error: %v", err)
+	}
+
+	// after rotation: EdDSA signs, RSA public key still validates
+	rsaKey.Private = nil
+	after, err := auth.NewKeyset(edKey, rsaKey)
+	if err != nil {
+		t.Fatalf("NewKeyset error: %v", err)
+	}
+	newToken, err := after.MakeSessionJWT(userID, uuid.Nil)
+	if err != nil {
+		t.Fatalf("MakeSessionJWT error: %v", err)
+	}
+
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		claims, err := after.ValidateAccessToken(token)
+		if err != nil {
+			t.Errorf("%s token: unexpected error: %v", name, err)
+			continue
+		}
+		if claims.Subject != userID.String() {
+			t.Errorf("%s token: expected subject %v, got %v", name, userID, claims.Subject)
+		}
+	}
+
+	// a keyset that never knew the EdDSA key rejects the new token
+	if _, err := before.ValidateAccessToken(newToken); err == nil {
+		t.Errorf("expected error for unknown kid")
+	}
+}
+
+func TestKeysetRejectsAlgorithmConfusion(t *testing.T) {
+	rsaKey, _ := newTestKeys(t)
+	ks, err := auth.NewKeyset(rsaKey)
+	if err != nil {
+		t.Fatalf("NewKeyset error: %v", err)
+	}
+
+	// HS256 token claiming the RSA kid
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.RegisteredClaims{
+		Issuer:  "chirpy",
+		Subject: uuid.NewString(),
+	})
+	token.Header["kid"] = rsaKey.ID
+	forged, _ := token.SignedString([]byte("anything"))
+
+	if _, err := ks.ValidateAccessToken(forged); err == nil {
+		t.Errorf("expected error for HS256 token with RS256 kid")
+	}
+}
+
+func TestKeysetJWKS(t *testing.T) {
+	rsaKey, edKey := newTestKeys(t)
+	ks, err := auth.NewKeyset(edKey, rsaKey)
+	if err != nil {
+		t.Fatalf("NewKeyset error: %v", err)
+	}
+
+	jwks := ks.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(jwks.Keys))
+	}
+	for _, k := range jwks.Keys {
+		switch k.Kid {
+		case "rsa-1":
+			if k.Kty != "RSA" || k.N == "" || k.E != "AQAB" {
+				t.Errorf("unexpected RSA JWK: %+v", k)
+			}
+		case "ed-1":
+			if k.Kty != "OKP" || k.Crv != "Ed25519" || k.X == "" {
+				t.Errorf("unexpected Ed25519 JWK: %+v", k)
+			}
+		default:
+			t.Errorf("unexpected kid %q", k.Kid)
+		}
+	}
+
+	// secrets are never published
+	if keys := auth.NewHMACKeyset("supersecret").JWKS().Keys; len(keys) != 0 {
+		t.Errorf("expected no keys for HMAC keyset, got %d", len(keys))
+	}
+}
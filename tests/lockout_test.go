@@ -0,0 +1,218 @@
+package tests
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/api"
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/ratelimit"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const lockoutPassword = "correct horse battery staple"
+
+// memoryLogins answers the queries a login runs, for one user.
+type memoryLogins struct {
+	mu       sync.Mutex
+	user     database.User
+	failures map[uuid.UUID]*database.LoginFailure
+}
+
+func newMemoryLogins(t *testing.T, db *fakeDB, passwords *auth.Passwords) *memoryLogins {
+	hash, err := passwords.Hash(lockoutPassword)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	s := &memoryLogins{
+		user: database.User{
+			ID:             uuid.New(),
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
+			Email:          "walt@example.com",
+			HashedPassword: hash,
+			Role:           auth.RoleUser,
+		},
+		failures: map[uuid.UUID]*database.LoginFailure{},
+	}
+
+	db.handle("GetUserByEmail", func(args []driver.Value) (fakeResult, error) {
+		if argString(args[0]) == s.user.Email {
+			return fakeRows(s.user), nil
+		}
+		return fakeResult{}, nil
+	})
+	db.handle("GetUser", func(args []driver.Value) (fakeResult, error) {
+		if argUUID(args[0]) == s.user.ID {
+			return fakeRows(s.user), nil
+		}
+		return fakeResult{}, nil
+	})
+	db.handle("GetLoginFailures", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if f, ok := s.failures[argUUID(args[0])]; ok {
+			return fakeRows(*f), nil
+		}
+		return fakeResult{}, nil
+	})
+	db.handle("RecordLoginFailure", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		f, ok := s.failures[argUUID(args[0])]
+		if !ok {
+			f = &database.LoginFailure{UserID: argUUID(args[0])}
+			s.failures[f.UserID] = f
+		}
+		f.FailedAttempts++
+		f.LastFailedAt = time.Now()
+		return fakeResult{rows: [][]driver.Value{{int64(f.FailedAttempts)}}}, nil
+	})
+	db.handle("LockAccount", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if f, ok := s.failures[argUUID(args[0])]; ok {
+			f.LockedUntil = sql.NullTime{Time: argTime(args[1]), Valid: true}
+		}
+		return fakeAffected(1), nil
+	})
+	db.handle("ClearLoginFailures", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		_, ok := s.failures[argUUID(args[0])]
+		delete(s.failures, argUUID(args[0]))
+		if ok {
+			return fakeAffected(1), nil
+		}
+		return fakeAffected(0), nil
+	})
+	// what a successful login goes on to run: no 2FA, no subscription
+	db.handle("GetTOTP", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	db.handle("GetSubscription", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	newMemoryRefreshTokens(db)
+	return s
+}
+
+// lock locks the account until the given time.
+func (s *memoryLogins) lock(until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[s.user.ID] = &database.LoginFailure{
+		UserID:         s.user.ID,
+		FailedAttempts: 5,
+		LastFailedAt:   time.Now(),
+		LockedUntil:    sql.NullTime{Time: until, Valid: true},
+	}
+}
+
+// locked reports whether the account is locked now.
+func (s *memoryLogins) locked() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.failures[s.user.ID]
+	return ok && f.LockedUntil.Valid && f.LockedUntil.Time.After(time.Now())
+}
+
+func newLockoutConfig(t *testing.T, ipThreshold int) (*api.APIConfig, *memoryLogins) {
+	db := newFakeDB(t)
+	passwords := auth.NewPasswords(auth.BcryptHasher{Cost: bcrypt.MinCost})
+	logins := newMemoryLogins(t, db, passwords)
+	return &api.APIConfig{
+		DB:                fakeQueries(db),
+		Keyset:            auth.NewHMACKeyset("supersecret"),
+		Passwords:         passwords,
+		Metrics:           api.NewAppMetrics(db.sqlDB()),
+		LoginFailuresByIP: ratelimit.NewBackoff(ipThreshold, time.Minute, time.Hour),
+	}, logins
+}
+
+// login posts to /api/login.
+func login(cfg *api.APIConfig, email, password string) *httptest.ResponseRecorder {
+	body := `{"email": "` + email + `", "password": "` + password + `"}`
+	req := httptest.NewRequest("POST", "/api/login", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	cfg.LoginUserHandler(rec, req)
+	return rec
+}
+
+func TestLoginFailuresLookAlike(t *testing.T) {
+	cfg, logins := newLockoutConfig(t, 20)
+
+	unknown := login(cfg, "nobody@example.com", lockoutPassword)
+	wrong := login(cfg, logins.user.Email, "not the password")
+	logins.lock(time.Now().Add(time.Hour))
+	locked := login(cfg, logins.user.Email, lockoutPassword)
+
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"unknown email":  unknown,
+		"wrong password": wrong,
+		"locked account": locked,
+	} {
+		if rec.Code != 401 {
+			t.Errorf("%s answered %d, want 401", name, rec.Code)
+		}
+		if rec.Body.String() != unknown.Body.String() || rec.Header().Get("Retry-After") != "" {
+			t.Errorf("%s answered %q, which tells it apart from %q", name, rec.Body, unknown.Body)
+		}
+	}
+}
+
+func TestLoginBlockedByIP(t *testing.T) {
+	cfg, logins := newLockoutConfig(t, 3)
+
+	for i := 0; i < 3; i++ {
+		if rec := login(cfg, logins.user.Email, "not the password"); rec.Code != 401 {
+			t.Fatalf("failure %d answered %d", i+1, rec.Code)
+		}
+	}
+
+	// blocked before the password is even looked at
+	rec := login(cfg, logins.user.Email, lockoutPassword)
+	if rec.Code != 429 {
+		t.Fatalf("blocked IP answered %d, want 429", rec.Code)
+	}
+	if wait, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || wait < 1 || wait > 61 {
+		t.Errorf("Retry-After = %q, want the seconds left of a minute", rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestUnlockUser(t *testing.T) {
+	cfg, logins := newLockoutConfig(t, 20)
+
+	for i := 0; i < 5; i++ {
+		login(cfg, logins.user.Email, "not the password")
+	}
+	if !logins.locked() {
+		t.Fatal("five failed logins should lock the account")
+	}
+	if rec := login(cfg, logins.user.Email, lockoutPassword); rec.Code != 401 {
+		t.Fatalf("locked account answered %d, want 401", rec.Code)
+	}
+
+	req := httptest.NewRequest("POST", "/admin/users/"+logins.user.ID.String()+"/unlock", nil)
+	req.SetPathValue("userID", logins.user.ID.String())
+	rec := httptest.NewRecorder()
+	cfg.UnlockUserHandler(rec, req)
+	if rec.Code != 204 {
+		t.Fatalf("unlock = %d", rec.Code)
+	}
+	if logins.locked() {
+		t.Error("unlock should clear the lock")
+	}
+
+	if rec := login(cfg, logins.user.Email, lockoutPassword); rec.Code != 200 {
+		t.Errorf("login after unlock = %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,205 @@
+package tests
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Johnermac/http-server/internal/api"
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/helpers"
+	"github.com/Johnermac/http-server/internal/passwordpolicy"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMiddlewareLogging(t *testing.T) {
+	var logs bytes.Buffer
+	cfg := &api.APIConfig{
+		Keyset: auth.NewHMACKeyset("supersecret"),
+		Logger: slog.New(slog.NewJSONHandler(&logs, nil)),
+	}
+
+	var seenID string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		seenID = api.RequestID(r.Context())
+		if _, _, err := cfg.AuthenticateRequest(r); err != nil {
+			helpers.RespondWithError(w, 401, err.Error())
+			return
+		}
+		helpers.RespondWithError(w, 500, "Database error", errors.New("connection refused"))
+	})
+	handler := cfg.MiddlewareLogging(mux)
+
+	serve := func(requestID, token string) (*httptest.ResponseRecorder, map[string]any) {
+		t.Helper()
+		logs.Reset()
+		req := httptest.NewRequest("GET", "/things/42", nil)
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var line map[string]any
+		if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+			t.Fatalf("log line %q: %v", logs.String(), err)
+		}
+		return rec, line
+	}
+
+	userID := uuid.New()
+	token, err := cfg.Keyset.MakeSessionJWT(userID, uuid.Nil)
+	if err != nil {
+		t.Fatalf("MakeSessionJWT: %v", err)
+	}
+
+	rec, line := serve("abc-123", token)
+	if got := rec.Header().Get("X-Request-ID"); got != "abc-123" || seenID != "abc-123" {
+		t.Errorf("request ID should be passed on, header %q, context %q", got, seenID)
+	}
+	want := map[string]any{
+		"level":      "ERROR",
+		"msg":        "request",
+		"request_id": "abc-123",
+		"method":     "GET",
+		"route":      "GET /things/{id}",
+		"status":     float64(500),
+		"bytes":      float64(rec.Body.Len()),
+		"user_id":    userID.String(),
+		"error":      "Database error",
+		"cause":      "connection refused",
+	}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("%s = %v, want %v", k, line[k], v)
+		}
+	}
+	if _, ok := line["duration_ms"].(float64); !ok {
+		t.Errorf("duration_ms missing: %v", line)
+	}
+
+	// unusable IDs are replaced, and nobody is logged in
+	rec, line = serve("has spaces", "")
+	if got := rec.Header().Get("X-Request-ID"); got == "has spaces" || uuid.Validate(got) != nil {
+		t.Errorf("X-Request-ID = %q, want a new ID", got)
+	}
+	if line["level"] != "INFO" || line["status"] != float64(401) || line["user_id"] != nil || line["cause"] != nil {
+		t.Errorf("unauthenticated request logged as %v", line)
+	}
+}
+
+func TestOAuthErrorsAreLogged(t *testing.T) {
+	var logs bytes.Buffer
+	cfg := &api.APIConfig{Logger: slog.New(slog.NewJSONHandler(&logs, nil))}
+	handler := cfg.MiddlewareLogging(http.HandlerFunc(cfg.OAuthTokenHandler))
+
+	req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader("grant_type=%zz"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var body map[string]string
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != 400 || body["error"] != "invalid_request" || body["error_description"] != "Invalid form" {
+		t.Errorf("response = %d %v, want an RFC 6749 error", rec.Code, body)
+	}
+
+	var line map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatalf("log line %q: %v", logs.String(), err)
+	}
+	if line["error"] != "invalid_request: Invalid form" || line["cause"] == nil {
+		t.Errorf("logged %v, want the error and its cause", line)
+	}
+}
+
+func TestHandlerLogsCarryRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	cfg, logins := newLockoutConfig(t, 20)
+	cfg.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	handler := cfg.MiddlewareLogging(http.HandlerFunc(cfg.LoginUserHandler))
+
+	for i := 1; i <= 5; i++ {
+		body := `{"email": "` + logins.user.Email + `", "password": "not the password"}`
+		req := httptest.NewRequest("POST", "/api/login", strings.NewReader(body))
+		req.Header.Set("X-Request-ID", "login-"+strconv.Itoa(i))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// the fifth failure locks the account
+	for _, l := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var line map[string]any
+		if err := json.Unmarshal(l, &line); err != nil {
+			t.Fatalf("log line %q: %v", l, err)
+		}
+		if line["msg"] != "locking account" {
+			continue
+		}
+		if line["request_id"] != "login-5" || line["user_id"] != logins.user.ID.String() {
+			t.Errorf("logged %v, want it tied to request login-5", line)
+		}
+		return
+	}
+	t.Errorf("nothing logged about locking the account: %s", logs.String())
+}
+
+func TestSignupLogsFailedVerificationEmail(t *testing.T) {
+	var logs bytes.Buffer
+	db := newFakeDB(t)
+	user := database.User{ID: uuid.New(), Email: "walt@example.com", Role: auth.RoleUser}
+	db.handle("CreateUser", func(args []driver.Value) (fakeResult, error) {
+		return fakeRows(user), nil
+	})
+	db.handle("CreateEmailVerification", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, errors.New("connection refused")
+	})
+	db.handle("GetSubscription", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	cfg := &api.APIConfig{
+		DB:             fakeQueries(db),
+		Passwords:      auth.NewPasswords(auth.BcryptHasher{Cost: bcrypt.MinCost}),
+		PasswordPolicy: passwordpolicy.Policy{MinLength: 8},
+		JWTSecret:      "supersecret",
+		Logger:         slog.New(slog.NewJSONHandler(&logs, nil)),
+	}
+	handler := cfg.MiddlewareLogging(http.HandlerFunc(cfg.CreateUserHandler))
+
+	body := `{"email": "walt@example.com", "password": "correct horse battery staple"}`
+	req := httptest.NewRequest("POST", "/api/users", strings.NewReader(body))
+	req.Header.Set("X-Request-ID", "signup-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// the user can ask for a resend, so signup still succeeds
+	if rec.Code != 201 {
+		t.Fatalf("signup = %d %s, want 201", rec.Code, rec.Body)
+	}
+	for _, l := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var line map[string]any
+		if err := json.Unmarshal(l, &line); err != nil {
+			t.Fatalf("log line %q: %v", l, err)
+		}
+		if line["msg"] != "send verification email" {
+			continue
+		}
+		if line["request_id"] != "signup-1" || line["user_id"] != user.ID.String() || line["error"] != "connection refused" {
+			t.Errorf("logged %v, want the error tied to request signup-1", line)
+		}
+		return
+	}
+	t.Errorf("nothing logged about the verification email: %s", logs.String())
+}
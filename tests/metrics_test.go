@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/api"
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/google/uuid"
+)
+
+// scrape calls /metrics through the same middleware as the server, with
+// authorization as the bearer token if set.
+func scrape(cfg *api.APIConfig, authorization string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", cfg.MetricsHandler)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", "Bearer "+authorization)
+	}
+	rec := httptest.NewRecorder()
+	cfg.MiddlewareMetrics(mux).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMetricsWithToken(t *testing.T) {
+	db := newFakeDB(t)
+	cfg := &api.APIConfig{
+		Metrics:      api.NewAppMetrics(db.sqlDB()),
+		MetricsToken: "scraper-token",
+	}
+
+	if rec := scrape(cfg, ""); rec.Code != 401 {
+		t.Errorf("scrape without the token = %d, want 401", rec.Code)
+	}
+	if rec := scrape(cfg, "guess"); rec.Code != 401 {
+		t.Errorf("scrape with a wrong token = %d, want 401", rec.Code)
+	}
+
+	rec := scrape(cfg, "scraper-token")
+	if rec.Code != 200 {
+		t.Fatalf("scrape with the token = %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`chirpy_http_requests_total{code="401",route="GET /metrics"} 2`,
+		`chirpy_http_request_duration_seconds_bucket{code="401",route="GET /metrics",le="+Inf"} 2`,
+		`go_sql_open_connections{db_name="chirpy"}`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("exposition is missing %s", want)
+		}
+	}
+}
+
+func TestMetricsWithoutTokenNeedsAdmin(t *testing.T) {
+	db := newFakeDB(t)
+	users := map[uuid.UUID]database.User{}
+	db.handle("GetUser", func(args []driver.Value) (fakeResult, error) {
+		if u, ok := users[argUUID(args[0])]; ok {
+			return fakeRows(u), nil
+		}
+		return fakeResult{}, nil
+	})
+	cfg := &api.APIConfig{
+		DB:      fakeQueries(db),
+		Keyset:  auth.NewHMACKeyset("supersecret"),
+		Metrics: api.NewAppMetrics(db.sqlDB()),
+	}
+
+	tokenFor := func(role string) string {
+		u := database.User{ID: uuid.New(), CreatedAt: time.Now(), UpdatedAt: time.Now(), Email: role + "@example.com", Role: role}
+		users[u.ID] = u
+		token, err := cfg.Keyset.MakeSessionJWT(u.ID, uuid.Nil)
+		if err != nil {
+			t.Fatalf("MakeSessionJWT: %v", err)
+		}
+		return token
+	}
+
+	if rec := scrape(cfg, ""); rec.Code != 401 {
+		t.Errorf("anonymous scrape = %d, want 401", rec.Code)
+	}
+	if rec := scrape(cfg, tokenFor(auth.RoleUser)); rec.Code != 403 {
+		t.Errorf("scrape as a user = %d, want 403", rec.Code)
+	}
+	if rec := scrape(cfg, tokenFor(auth.RoleAdmin)); rec.Code != 200 || !strings.Contains(rec.Body.String(), "chirpy_http_requests_total") {
+		t.Errorf("scrape as an admin = %d", rec.Code)
+	}
+}
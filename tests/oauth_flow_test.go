@@ -0,0 +1,307 @@
+package tests
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/api"
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/entitlements"
+	"github.com/google/uuid"
+)
+
+// memoryOAuth answers the OAuth queries like the tables would.
+type memoryOAuth struct {
+	mu      sync.Mutex
+	clients map[uuid.UUID]database.OauthClient
+	codes   map[string]*database.OauthAuthorizationCode
+	tokens  map[uuid.UUID]database.OauthAccessToken
+}
+
+func newMemoryOAuth(db *fakeDB) *memoryOAuth {
+	s := &memoryOAuth{
+		clients: map[uuid.UUID]database.OauthClient{},
+		codes:   map[string]*database.OauthAuthorizationCode{},
+		tokens:  map[uuid.UUID]database.OauthAccessToken{},
+	}
+
+	db.handle("GetOAuthClient", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if c, ok := s.clients[argUUID(args[0])]; ok {
+			return fakeRows(c), nil
+		}
+		return fakeResult{}, nil
+	})
+	db.handle("CreateAuthorizationCode", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.codes[argString(args[0])] = &database.OauthAuthorizationCode{
+			CodeHash:      argString(args[0]),
+			CreatedAt:     time.Now(),
+			ClientID:      argUUID(args[1]),
+			UserID:        argUUID(args[2]),
+			RedirectUri:   argString(args[3]),
+			Scopes:        argStrings(args[4]),
+			CodeChallenge: argString(args[5]),
+			ExpiresAt:     argTime(args[6]),
+		}
+		return fakeAffected(1), nil
+	})
+	db.handle("UseAuthorizationCode", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		c, ok := s.codes[argString(args[0])]
+		if !ok || c.UsedAt.Valid || !c.ExpiresAt.After(time.Now()) {
+			return fakeResult{}, nil
+		}
+		c.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		return fakeRows(*c), nil
+	})
+	db.handle("CreateOAuthAccessToken", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.tokens[argUUID(args[0])] = database.OauthAccessToken{
+			ID:        argUUID(args[0]),
+			CreatedAt: time.Now(),
+			ClientID:  argUUID(args[1]),
+			UserID:    argUUID(args[2]),
+			Scopes:    argStrings(args[3]),
+			ExpiresAt: argTime(args[4]),
+		}
+		return fakeAffected(1), nil
+	})
+	db.handle("GetOAuthAccessToken", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if t, ok := s.tokens[argUUID(args[0])]; ok {
+			return fakeRows(t), nil
+		}
+		return fakeResult{}, nil
+	})
+	return s
+}
+
+// oauthFlow is a third-party app and a signed-in user on a test server.
+type oauthFlow struct {
+	server      *httptest.Server
+	client      database.OauthClient
+	userJWT     string
+	redirectURI string
+	verifier    string
+}
+
+func newOAuthFlow(t *testing.T) *oauthFlow {
+	db := newFakeDB(t)
+	store := newMemoryOAuth(db)
+	// nobody subscribed: the API call below sees the free plan
+	db.handle("GetSubscription", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+
+	cfg := &api.APIConfig{
+		DB:           fakeQueries(db),
+		Keyset:       auth.NewHMACKeyset("supersecret"),
+		Entitlements: entitlements.NewEngine(entitlements.DefaultPlans),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /oauth/authorize", cfg.AuthorizeHandler)
+	mux.HandleFunc("POST /oauth/authorize", cfg.AuthorizeConsentHandler)
+	mux.HandleFunc("POST /oauth/token", cfg.OAuthTokenHandler)
+	mux.HandleFunc("GET /api/me/entitlements", cfg.GetEntitlementsHandler)
+	mux.HandleFunc("POST /api/verify-email/resend", cfg.ResendVerificationHandler)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	f := &oauthFlow{
+		server:      server,
+		redirectURI: "https://app.example.com/callback",
+		verifier:    "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk",
+	}
+	f.client = database.OauthClient{
+		ID:           uuid.New(),
+		CreatedAt:    time.Now(),
+		OwnerID:      uuid.New(),
+		Name:         "Chirp Scheduler",
+		RedirectUris: []string{f.redirectURI},
+		Scopes:       []string{auth.ScopeChirpsWrite},
+	}
+	store.clients[f.client.ID] = f.client
+
+	var err error
+	f.userJWT, err = cfg.Keyset.MakeSessionJWT(uuid.New(), uuid.Nil)
+	if err != nil {
+		t.Fatalf("MakeSessionJWT: %v", err)
+	}
+	return f
+}
+
+// authorizeParams is what the app puts in the authorize URL.
+func (f *oauthFlow) authorizeParams() url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {f.client.ID.String()},
+		"redirect_uri":          {f.redirectURI},
+		"scope":                 {auth.ScopeChirpsWrite},
+		"state":                 {"xyz"},
+		"code_challenge":        {auth.PKCEChallenge(f.verifier)},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+// do sends req without following redirects.
+func (f *oauthFlow) do(t *testing.T, req *http.Request) *http.Response {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+// authorize walks the user through the consent screen and returns the code
+// the app receives.
+func (f *oauthFlow) authorize(t *testing.T) string {
+	t.Helper()
+	params := f.authorizeParams()
+
+	res := f.do(t, mustRequest(t, "GET", f.server.URL+"/oauth/authorize?"+params.Encode(), nil))
+	page, _ := io.ReadAll(res.Body)
+	if res.StatusCode != 200 || !strings.Contains(string(page), f.client.Name) {
+		t.Fatalf("consent screen = %d: %s", res.StatusCode, page)
+	}
+
+	params.Set("decision", "approve")
+	req := mustRequest(t, "POST", f.server.URL+"/oauth/authorize", strings.NewReader(params.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+f.userJWT)
+	res = f.do(t, req)
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("consent = %d, want a redirect", res.StatusCode)
+	}
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), f.redirectURI+"?") {
+		t.Fatalf("redirected to %q", res.Header.Get("Location"))
+	}
+	if location.Query().Get("state") != "xyz" {
+		t.Errorf("state = %q, want it echoed back", location.Query().Get("state"))
+	}
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Fatalf("no code in %s", location)
+	}
+	return code
+}
+
+// exchange trades a code at the token endpoint, like a public client.
+func (f *oauthFlow) exchange(t *testing.T, code, redirectURI, verifier string) (int, map[string]any) {
+	t.Helper()
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {f.client.ID.String()},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	req := mustRequest(t, "POST", f.server.URL+"/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res := f.do(t, req)
+
+	var body map[string]any
+	json.NewDecoder(res.Body).Decode(&body)
+	return res.StatusCode, body
+}
+
+// call hits the API with an access token.
+func (f *oauthFlow) call(t *testing.T, method, path, token string) int {
+	t.Helper()
+	req := mustRequest(t, method, f.server.URL+path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return f.do(t, req).StatusCode
+}
+
+func mustRequest(t *testing.T, method, rawURL string, body io.Reader) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, rawURL, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	f := newOAuthFlow(t)
+
+	code := f.authorize(t)
+	status, body := f.exchange(t, code, f.redirectURI, f.verifier)
+	if status != 200 {
+		t.Fatalf("token exchange = %d: %v", status, body)
+	}
+	if body["token_type"] != "Bearer" || body["scope"] != auth.ScopeChirpsWrite {
+		t.Errorf("token response = %v", body)
+	}
+	accessToken, _ := body["access_token"].(string)
+
+	if got := f.call(t, "GET", "/api/me/entitlements", accessToken); got != 200 {
+		t.Errorf("API call with the access token = %d, want 200", got)
+	}
+	// the app only got what the user consented to
+	if got := f.call(t, "POST", "/api/verify-email/resend", accessToken); got != 403 {
+		t.Errorf("API call outside the granted scope = %d, want 403", got)
+	}
+}
+
+func TestOAuthTokenExchangeRejections(t *testing.T) {
+	t.Run("wrong code_verifier", func(t *testing.T) {
+		f := newOAuthFlow(t)
+		code := f.authorize(t)
+		other := strings.Repeat("a", 43)
+		if status, body := f.exchange(t, code, f.redirectURI, other); status != 400 || body["error"] != "invalid_grant" {
+			t.Errorf("exchange = %d %v, want 400 invalid_grant", status, body)
+		}
+	})
+
+	t.Run("code reuse", func(t *testing.T) {
+		f := newOAuthFlow(t)
+		code := f.authorize(t)
+		if status, body := f.exchange(t, code, f.redirectURI, f.verifier); status != 200 {
+			t.Fatalf("first exchange = %d %v", status, body)
+		}
+		if status, body := f.exchange(t, code, f.redirectURI, f.verifier); status != 400 || body["error"] != "invalid_grant" {
+			t.Errorf("second exchange = %d %v, want 400 invalid_grant", status, body)
+		}
+	})
+
+	t.Run("redirect_uri mismatch at the token endpoint", func(t *testing.T) {
+		f := newOAuthFlow(t)
+		code := f.authorize(t)
+		if status, body := f.exchange(t, code, "https://evil.example.com/callback", f.verifier); status != 400 || body["error"] != "invalid_grant" {
+			t.Errorf("exchange = %d %v, want 400 invalid_grant", status, body)
+		}
+	})
+
+	t.Run("unregistered redirect_uri at the authorize endpoint", func(t *testing.T) {
+		f := newOAuthFlow(t)
+		params := f.authorizeParams()
+		params.Set("redirect_uri", "https://evil.example.com/callback")
+		res := f.do(t, mustRequest(t, "GET", f.server.URL+"/oauth/authorize?"+params.Encode(), nil))
+		if res.StatusCode != 400 || res.Header.Get("Location") != "" {
+			t.Errorf("authorize = %d to %q, want 400 without a redirect", res.StatusCode, res.Header.Get("Location"))
+		}
+	})
+}
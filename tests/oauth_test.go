@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Johnermac/http-server/internal/auth"
+)
+
+func TestPKCEChallengeRFC7636(t *testing.T) {
+	// RFC 7636, appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if got := auth.PKCEChallenge(verifier); got != want {
+		t.Errorf("PKCEChallenge() = %q, want %q", got, want)
+	}
+	if !auth.VerifyPKCE(verifier, want) {
+		t.Error("expected verifier to match its challenge")
+	}
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	// what a client does before sending the user to /oauth/authorize
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	verifier := base64.RawURLEncoding.EncodeToString(buf)
+	challenge := auth.PKCEChallenge(verifier)
+
+	tests := []struct {
+		name     string
+		verifier string
+		want     bool
+	}{
+		{"matching verifier", verifier, true},
+		{"other verifier", verifier[1:] + "A", false},
+		{"challenge as verifier", challenge, false},
+		{"too short", "abc", false},
+		{"invalid characters", verifier[:42] + "+", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := auth.VerifyPKCE(tt.verifier, challenge); got != tt.want {
+				t.Errorf("VerifyPKCE() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOAuthJWTIsValidAccessToken(t *testing.T) {
+	secret := "supersecret"
+	userID, clientID, tokenID := uuid.New(), uuid.New(), uuid.New()
+	scopes := []string{auth.ScopeChirpsWrite, auth.ScopeProfileWrite}
+
+	token, err := auth.NewHMACKeyset(secret).MakeOAuthJWT(userID, clientID, tokenID, scopes, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeOAuthJWT error: %v", err)
+	}
+
+	got, err := auth.ValidateJWT(token, secret)
+	if err != nil {
+		t.Fatalf("ValidateJWT error: %v", err)
+	}
+	if got != userID {
+		t.Errorf("ValidateJWT() = %v, want %v", got, userID)
+	}
+
+	claims, err := auth.ValidateAccessToken(token, secret)
+	if err != nil {
+		t.Fatalf("ValidateAccessToken error: %v", err)
+	}
+	if claims.ClientID != clientID.String() || claims.ID != tokenID.String() {
+		t.Errorf("unexpected client or token id: %+v", claims)
+	}
+	if !slices.Equal(auth.ParseScope(claims.Scope), scopes) {
+		t.Errorf("scope = %q, want %v", claims.Scope, scopes)
+	}
+	if claims.SessionID != "" {
+		t.Error("OAuth tokens must not carry a session")
+	}
+}
+
+func TestMatchRedirectURI(t *testing.T) {
+	one := []string{"https://app.example.com/cb"}
+	two := []string{"https://app.example.com/cb", "http://localhost:3000/cb"}
+
+	tests := []struct {
+		name       string
+		registered []string
+		requested  string
+		want       string
+		ok         bool
+	}{
+		{"exact match", two, "http://localhost:3000/cb", "http://localhost:3000/cb", true},
+		{"omitted with one registered", one, "", "https://app.example.com/cb", true},
+		{"omitted with several registered", two, "", "", false},
+		{"prefix is not a match", one, "https://app.example.com/cb/evil", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := auth.MatchRedirectURI(tt.registered, tt.requested)
+			if ok != tt.ok || (ok && got != tt.want) {
+				t.Errorf("MatchRedirectURI() = %q, %v, want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri     string
+		wantErr bool
+	}{
+		{"https://app.example.com/cb", false},
+		{"http://localhost:8000/cb", false},
+		{"http://app.example.com/cb", true},
+		{"https://app.example.com/cb#frag", true},
+		{"/relative/cb", true},
+	}
+
+	for _, tt := range tests {
+		err := auth.ValidateRedirectURI(tt.uri)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateRedirectURI(%q) error = %v, wantErr %v", tt.uri, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGrantScopes(t *testing.T) {
+	allowed := []string{auth.ScopeChirpsWrite, auth.ScopeProfileWrite}
+
+	if got, ok := auth.GrantScopes(allowed, nil); !ok || !slices.Equal(got, allowed) {
+		t.Errorf("expected no requested scopes to grant all allowed, got %v", got)
+	}
+	if got, ok := auth.GrantScopes(allowed, []string{auth.ScopeChirpsWrite}); !ok || !slices.Equal(got, []string{auth.ScopeChirpsWrite}) {
+		t.Errorf("expected subset to be granted, got %v", got)
+	}
+	if _, ok := auth.GrantScopes([]string{auth.ScopeChirpsWrite}, []string{auth.ScopeProfileWrite}); ok {
+		t.Error("expected scope outside the client's to be refused")
+	}
+}
@@ -0,0 +1,297 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/Johnermac/http-server/internal/api"
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/oidc"
+)
+
+// mockIdP is a minimal OpenID provider: discovery, JWKS and a token endpoint
+// that answers with whatever ID token claims the test asks for.
+type mockIdP struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	kid       string
+	challenge string
+	claims    jwt.MapClaims
+
+	// issuer is what discovery and ID tokens say, the server URL unless a
+	// test changes it
+	issuer string
+	// public makes the token endpoint expect a client without a secret
+	public bool
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey error: %v", err)
+	}
+	idp := &mockIdP{key: key, kid: "idp-key-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.issuer,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": idp.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		id, secret, ok := r.BasicAuth()
+		if idp.public {
+			id, secret = r.PostForm.Get("client_id"), "s3cret"
+			ok = !ok && r.Header.Get("Authorization") == ""
+		}
+		if !ok || id != "chirpy" || secret != "s3cret" || r.PostForm.Get("code") != "good-code" ||
+			!auth.VerifyPKCE(r.PostForm.Get("code_verifier"), idp.challenge) {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(t, idp.claims)})
+	})
+
+	idp.Server = httptest.NewServer(mux)
+	idp.issuer = idp.URL
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *mockIdP) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = idp.kid
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		t.Fatalf("sign id token: %v", err)
+	}
+	return signed
+}
+
+func (idp *mockIdP) validClaims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            idp.issuer,
+		"aud":            "chirpy",
+		"sub":            "employee-42",
+		"email":          "walt@example.com",
+		"email_verified": true,
+		"nonce":          nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+func newTestProvider(t *testing.T, idp *mockIdP) *oidc.Provider {
+	t.Helper()
+	p, err := oidc.NewProvider(oidc.Config{
+		Name:         "corp",
+		Issuer:       idp.URL,
+		ClientID:     "chirpy",
+		ClientSecret: "s3cret",
+	})
+	if err != nil {
+		t.Fatalf("NewProvider error: %v", err)
+	}
+	return p
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	idp := newMockIdP(t)
+	p := newTestProvider(t, idp)
+	ctx := context.Background()
+
+	verifier, _ := auth.MakeRefreshToken()
+	authURL, err := p.AuthCodeURL(ctx, "http://localhost:8080/api/auth/oidc/corp/callback", "state-1", "nonce-1", auth.PKCEChallenge(verifier))
+	if err != nil {
+		t.Fatalf("AuthCodeURL error: %v", err)
+	}
+
+	// what the browser would carry to the IdP
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	if q.Get("client_id") != "chirpy" || q.Get("state") != "state-1" || q.Get("nonce") != "nonce-1" || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization url: %s", authURL)
+	}
+	idp.challenge = q.Get("code_challenge")
+	idp.claims = idp.validClaims(q.Get("nonce"))
+
+	if _, err := p.Exchange(ctx, "http://localhost:8080/api/auth/oidc/corp/callback", "good-code", "wrong-verifier-wrong-verifier-wrong-verifier"); err == nil {
+		t.Error("expected exchange with a wrong code_verifier to fail")
+	}
+
+	rawIDToken, err := p.Exchange(ctx, "http://localhost:8080/api/auth/oidc/corp/callback", "good-code", verifier)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+
+	claims, err := p.VerifyIDToken(ctx, rawIDToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("VerifyIDToken error: %v", err)
+	}
+	if claims.Subject != "employee-42" || claims.Email != "walt@example.com" || !claims.EmailVerified {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestOIDCVerifyIDTokenRejects(t *testing.T) {
+	idp := newMockIdP(t)
+	p := newTestProvider(t, idp)
+	ctx := context.Background()
+
+	with := func(k string, v any) jwt.MapClaims {
+		c := idp.validClaims("nonce-1")
+		c[k] = v
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"wrong nonce", idp.sign(t, with("nonce", "other"))},
+		{"wrong audience", idp.sign(t, with("aud", "someone-else"))},
+		{"wrong issuer", idp.sign(t, with("iss", "https://evil.example.com"))},
+		{"expired", idp.sign(t, with("exp", time.Now().Add(-time.Minute).Unix()))},
+		{"hs256 with public key", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, idp.validClaims("nonce-1"))
+			token.Header["kid"] = idp.kid
+			s, _ := token.SignedString(idp.key.PublicKey.N.Bytes())
+			return s
+		}()},
+		{"unknown kid", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, idp.validClaims("nonce-1"))
+			token.Header["kid"] = "rotated-away"
+			s, _ := token.SignedString(idp.key)
+			return s
+		}()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.VerifyIDToken(ctx, tt.token, "nonce-1"); err == nil {
+				t.Error("expected id token to be rejected")
+			}
+		})
+	}
+}
+
+func TestOIDCIssuerWithTrailingSlash(t *testing.T) {
+	idp := newMockIdP(t)
+	// some providers, Auth0 among them, put a trailing slash in the issuer
+	idp.issuer = idp.URL + "/"
+	p := newTestProvider(t, idp)
+	ctx := context.Background()
+
+	if _, err := p.VerifyIDToken(ctx, idp.sign(t, idp.validClaims("nonce-1")), "nonce-1"); err != nil {
+		t.Errorf("token with the discovered issuer rejected: %v", err)
+	}
+
+	// the issuer is compared exactly, not up to a slash
+	claims := idp.validClaims("nonce-1")
+	claims["iss"] = idp.URL
+	if _, err := p.VerifyIDToken(ctx, idp.sign(t, claims), "nonce-1"); err == nil {
+		t.Error("expected a token with another spelling of the issuer to be rejected")
+	}
+}
+
+func TestOIDCPublicClientExchange(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.public = true
+	p, err := oidc.NewProvider(oidc.Config{Name: "corp", Issuer: idp.URL, ClientID: "chirpy"})
+	if err != nil {
+		t.Fatalf("NewProvider error: %v", err)
+	}
+	ctx := context.Background()
+
+	verifier, _ := auth.MakeRefreshToken()
+	idp.challenge = auth.PKCEChallenge(verifier)
+	idp.claims = idp.validClaims("nonce-1")
+
+	if _, err := p.Exchange(ctx, "http://localhost:8080/api/auth/oidc/corp/callback", "good-code", verifier); err != nil {
+		t.Errorf("public client exchange should send client_id without Basic auth: %v", err)
+	}
+}
+
+func TestOIDCCallbackLogsCause(t *testing.T) {
+	idp := newMockIdP(t)
+	verifier, _ := auth.MakeRefreshToken()
+	idp.challenge = auth.PKCEChallenge(verifier)
+	idp.claims = idp.validClaims("nonce-1")
+
+	db := newFakeDB(t)
+	db.handle("UseOIDCLoginState", func(args []driver.Value) (fakeResult, error) {
+		return fakeRows(database.OidcLoginState{
+			StateHash:    argString(args[0]),
+			CreatedAt:    time.Now(),
+			Provider:     "corp",
+			Nonce:        "nonce-1",
+			CodeVerifier: verifier,
+			ExpiresAt:    time.Now().Add(time.Minute),
+		}), nil
+	})
+	db.handle("GetUserIdentity", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, errors.New("connection refused")
+	})
+
+	var logs bytes.Buffer
+	cfg := &api.APIConfig{
+		DB:            fakeQueries(db),
+		BaseURL:       "http://localhost:8080",
+		OIDCProviders: map[string]*oidc.Provider{"corp": newTestProvider(t, idp)},
+		Logger:        slog.New(slog.NewJSONHandler(&logs, nil)),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/auth/oidc/{provider}/callback", cfg.OIDCCallbackHandler)
+
+	req := httptest.NewRequest("GET", "/api/auth/oidc/corp/callback?state=state-1&code=good-code", nil)
+	req.AddCookie(&http.Cookie{Name: "chirpy_oidc_state", Value: "state-1"})
+	rec := httptest.NewRecorder()
+	cfg.MiddlewareLogging(mux).ServeHTTP(rec, req)
+
+	if rec.Code != 500 || strings.Contains(rec.Body.String(), "connection refused") {
+		t.Errorf("callback = %d %s, want 500 without the cause", rec.Code, rec.Body)
+	}
+	var line map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatalf("log line %q: %v", logs.String(), err)
+	}
+	if line["error"] != "Database error" || line["cause"] != "connection refused" {
+		t.Errorf("logged %v, want the error and its cause", line)
+	}
+}
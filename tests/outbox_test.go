@@ -0,0 +1,325 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/jobs"
+	"github.com/Johnermac/http-server/internal/outbox"
+	"github.com/google/uuid"
+)
+
+// memoryOutbox answers the outbox queries like the table would, and keeps
+// the jobs subscribers enqueue. A rolled back transaction undoes both.
+type memoryOutbox struct {
+	mu     sync.Mutex
+	nextID int64
+	events []database.OutboxEvent
+	jobs   []json.RawMessage
+
+	// another relay holds the lock
+	relayBusy bool
+	// the process dies this many times between publishing an event and
+	// marking it published
+	crashes int
+}
+
+func newMemoryOutbox(db *fakeDB) *memoryOutbox {
+	s := &memoryOutbox{}
+
+	db.atomic(func() func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		events, enqueued := slices.Clone(s.events), slices.Clone(s.jobs)
+		return func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.events, s.jobs = events, enqueued
+		}
+	})
+
+	db.handle("CreateOutboxEvent", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.nextID++
+		e := s.insert(s.nextID, argString(args[0]))
+		return fakeRows(e), nil
+	})
+	db.handle("TryLockOutboxRelay", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return fakeResult{rows: [][]driver.Value{{!s.relayBusy}}}, nil
+	})
+	db.handle("NextOutboxEvent", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, e := range s.events {
+			if e.Status == outbox.StatusPending {
+				return fakeRows(e), nil
+			}
+		}
+		return fakeResult{}, nil
+	})
+	db.handle("MarkOutboxEventPublished", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.crashes > 0 {
+			s.crashes--
+			return fakeResult{}, errors.New("connection reset by peer")
+		}
+		e := s.find(args[0].(int64))
+		e.Status = outbox.StatusPublished
+		e.Attempts++
+		e.LastError = sql.NullString{}
+		e.FinishedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		return fakeAffected(1), nil
+	})
+	db.handle("FailOutboxEvent", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		e := s.find(args[0].(int64))
+		if e.Status != outbox.StatusPending {
+			return fakeAffected(0), nil
+		}
+		e.Status = argString(args[1])
+		e.Attempts++
+		e.LastError = sql.NullString{String: argString(args[2]), Valid: true}
+		if e.Status == outbox.StatusDead {
+			e.FinishedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+		return fakeAffected(1), nil
+	})
+	db.handle("EnqueueJob", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.jobs = append(s.jobs, json.RawMessage(argString(args[1])))
+		return fakeAffected(1), nil
+	})
+	return s
+}
+
+// insert commits an event with the given id, keeping events in id order.
+func (s *memoryOutbox) insert(id int64, eventType string) database.OutboxEvent {
+	e := database.OutboxEvent{
+		ID:        id,
+		EventID:   uuid.New(),
+		CreatedAt: time.Now(),
+		EventType: eventType,
+		UserID:    uuid.New(),
+		Payload:   json.RawMessage(`{}`),
+		Status:    outbox.StatusPending,
+	}
+	i, _ := slices.BinarySearchFunc(s.events, id, func(e database.OutboxEvent, id int64) int {
+		return int(e.ID - id)
+	})
+	s.events = slices.Insert(s.events, i, e)
+	return e
+}
+
+func (s *memoryOutbox) find(id int64) *database.OutboxEvent {
+	for i := range s.events {
+		if s.events[i].ID == id {
+			return &s.events[i]
+		}
+	}
+	return &database.OutboxEvent{}
+}
+
+// event is the stored row for id.
+func (s *memoryOutbox) event(id int64) database.OutboxEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.find(id)
+}
+
+// enqueued are the ids of the events subscribers enqueued jobs for.
+func (s *memoryOutbox) enqueued(t *testing.T) []int64 {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []int64
+	for _, args := range s.jobs {
+		var job outboxTestJob
+		if err := json.Unmarshal(args, &job); err != nil {
+			t.Fatalf("job args %s: %v", args, err)
+		}
+		ids = append(ids, job.EventID)
+	}
+	return ids
+}
+
+// outboxTestJob is what the test subscriber enqueues for each event.
+type outboxTestJob struct {
+	EventID int64 `json:"event_id"`
+}
+
+func (outboxTestJob) Kind() string { return "outbox_test" }
+
+// newOutboxRelay is a relay over a memoryOutbox, and the queries to write
+// events with.
+func newOutboxRelay(t *testing.T) (*outbox.Relay, *database.Queries, *memoryOutbox) {
+	db := newFakeDB(t)
+	store := newMemoryOutbox(db)
+	return outboxRelay(db), fakeQueries(db), store
+}
+
+// outboxRelay is a relay with a subscriber to "chirp.created" that enqueues
+// a job per event, the way the server's do.
+func outboxRelay(db *fakeDB) *outbox.Relay {
+	relay := outbox.NewRelay(db.sqlDB(), fakeQueries(db))
+	relay.Subscribe("chirp.created", func(ctx context.Context, q *database.Queries, ev outbox.Event) error {
+		_, err := jobs.NewClient(q).Enqueue(ctx, outboxTestJob{EventID: ev.ID}, jobs.Options{})
+		return err
+	})
+	return relay
+}
+
+func writeOutboxEvent(t *testing.T, q *database.Queries, eventType string) {
+	t.Helper()
+	if err := outbox.Write(context.Background(), q, eventType, uuid.New(), struct{}{}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+}
+
+// publishAll publishes until there's nothing left, and fails the test on
+// an error.
+func publishAll(t *testing.T, relay *outbox.Relay) {
+	t.Helper()
+	for i := 0; ; i++ {
+		ok, err := relay.PublishNext(context.Background())
+		if err != nil {
+			t.Fatalf("PublishNext: %v", err)
+		}
+		if !ok {
+			return
+		}
+		if i == 100 {
+			t.Fatal("PublishNext never ran out of events")
+		}
+	}
+}
+
+func TestOutboxPublishesInOrder(t *testing.T) {
+	relay, q, store := newOutboxRelay(t)
+
+	for i := 0; i < 3; i++ {
+		writeOutboxEvent(t, q, "chirp.created")
+	}
+	publishAll(t, relay)
+	if got := store.enqueued(t); !slices.Equal(got, []int64{1, 2, 3}) {
+		t.Fatalf("published %v, want 1, 2, 3", got)
+	}
+
+	// event 4 takes its id, then its transaction commits after event 5's
+	store.mu.Lock()
+	store.nextID = 5
+	store.insert(5, "chirp.created")
+	store.mu.Unlock()
+	publishAll(t, relay)
+
+	store.mu.Lock()
+	store.insert(4, "chirp.created")
+	store.mu.Unlock()
+	publishAll(t, relay)
+
+	// late, but not skipped
+	if got := store.enqueued(t); !slices.Equal(got, []int64{1, 2, 3, 5, 4}) {
+		t.Errorf("published %v, want 1, 2, 3, 5, 4", got)
+	}
+}
+
+func TestOutboxCrashBeforeMarkingPublished(t *testing.T) {
+	db := newFakeDB(t)
+	store := newMemoryOutbox(db)
+	relay := outboxRelay(db)
+	ctx := context.Background()
+
+	writeOutboxEvent(t, fakeQueries(db), "chirp.created")
+	store.crashes = 1
+
+	if ok, err := relay.PublishNext(ctx); ok || err == nil {
+		t.Fatalf("PublishNext = %v, %v, want the crash", ok, err)
+	}
+	// the job the subscriber enqueued went with the transaction
+	if got := store.enqueued(t); len(got) != 0 {
+		t.Fatalf("enqueued %v after the crash, want nothing", got)
+	}
+	if e := store.event(1); e.Status != outbox.StatusPending || e.Attempts != 0 {
+		t.Errorf("event is %s after %d attempts, want pending and not counted", e.Status, e.Attempts)
+	}
+
+	// a restarted process publishes it, and no relay does again
+	publishAll(t, outboxRelay(db))
+	publishAll(t, outboxRelay(db))
+	if got := store.enqueued(t); !slices.Equal(got, []int64{1}) {
+		t.Errorf("enqueued %v, want the event's job once", got)
+	}
+	if e := store.event(1); e.Status != outbox.StatusPublished {
+		t.Errorf("event is %s, want published", e.Status)
+	}
+}
+
+func TestOutboxRetriesThenDeadLetters(t *testing.T) {
+	relay, q, store := newOutboxRelay(t)
+	relay.MaxAttempts = 3
+	ctx := context.Background()
+
+	fails := 0
+	relay.Subscribe("chirp.deleted", func(ctx context.Context, q *database.Queries, ev outbox.Event) error {
+		fails++
+		return errors.New("subscriber is down")
+	})
+	writeOutboxEvent(t, q, "chirp.deleted")
+	writeOutboxEvent(t, q, "chirp.created")
+
+	// retried before anything after it
+	for i := 1; i < 3; i++ {
+		if ok, err := relay.PublishNext(ctx); ok || err == nil {
+			t.Fatalf("attempt %d = %v, %v, want the subscriber's error", i, ok, err)
+		}
+		if e := store.event(1); e.Status != outbox.StatusPending || int(e.Attempts) != i {
+			t.Fatalf("after attempt %d event is %s after %d attempts", i, e.Status, e.Attempts)
+		}
+		if got := store.enqueued(t); len(got) != 0 {
+			t.Fatalf("event 2 was published while event 1 was pending")
+		}
+	}
+
+	// out of attempts: set aside, and the relay moves on
+	if ok, err := relay.PublishNext(ctx); !ok || err != nil {
+		t.Fatalf("last attempt = %v, %v, want the event dead-lettered", ok, err)
+	}
+	e := store.event(1)
+	if e.Status != outbox.StatusDead || e.Attempts != 3 || e.LastError.String == "" || !e.FinishedAt.Valid {
+		t.Errorf("event = %+v, want dead after 3 attempts with the error", e)
+	}
+	publishAll(t, relay)
+	if got := store.enqueued(t); !slices.Equal(got, []int64{2}) {
+		t.Errorf("published %v after the dead event, want 2", got)
+	}
+	if fails != 3 {
+		t.Errorf("subscriber ran %d times, want 3", fails)
+	}
+}
+
+func TestOutboxOneRelayAtATime(t *testing.T) {
+	relay, q, store := newOutboxRelay(t)
+
+	writeOutboxEvent(t, q, "chirp.created")
+	store.relayBusy = true
+
+	if ok, err := relay.PublishNext(context.Background()); ok || err != nil {
+		t.Fatalf("PublishNext while another relay publishes = %v, %v, want false, nil", ok, err)
+	}
+	if got := store.enqueued(t); len(got) != 0 {
+		t.Errorf("published %v while another relay held the lock", got)
+	}
+}
@@ -0,0 +1,294 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"maps"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/api"
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/mailer"
+	"github.com/Johnermac/http-server/internal/outbox"
+	"github.com/Johnermac/http-server/internal/passwordpolicy"
+	"github.com/Johnermac/http-server/internal/ratelimit"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const resetOldPassword = "correct horse battery staple"
+
+// memoryPasswordResets answers the queries a password reset runs, for one
+// user. A rolled back transaction undoes its writes.
+type memoryPasswordResets struct {
+	mu     sync.Mutex
+	user   database.User
+	resets map[string]database.PasswordReset
+}
+
+func newMemoryPasswordResets(t *testing.T, db *fakeDB, passwords *auth.Passwords) *memoryPasswordResets {
+	hash, err := passwords.Hash(resetOldPassword)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	s := &memoryPasswordResets{
+		user: database.User{
+			ID:             uuid.New(),
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
+			Email:          "walt@example.com",
+			HashedPassword: hash,
+			Role:           auth.RoleUser,
+		},
+		resets: map[string]database.PasswordReset{},
+	}
+
+	db.atomic(func() func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		user, resets := s.user, maps.Clone(s.resets)
+		return func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.user, s.resets = user, resets
+		}
+	})
+
+	db.handle("GetUserByEmail", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if argString(args[0]) == s.user.Email {
+			return fakeRows(s.user), nil
+		}
+		return fakeResult{}, nil
+	})
+	db.handle("CreatePasswordReset", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.resets[argString(args[0])] = database.PasswordReset{
+			TokenHash: argString(args[0]),
+			CreatedAt: time.Now(),
+			UserID:    argUUID(args[1]),
+			ExpiresAt: time.Now().Add(time.Hour),
+		}
+		return fakeAffected(1), nil
+	})
+	db.handle("GetPasswordResetEmail", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r, ok := s.resets[argString(args[0])]; ok && !r.UsedAt.Valid && r.ExpiresAt.After(time.Now()) {
+			return fakeResult{rows: [][]driver.Value{{s.user.Email}}}, nil
+		}
+		return fakeResult{}, nil
+	})
+	db.handle("UsePasswordReset", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		r, ok := s.resets[argString(args[0])]
+		if !ok || r.UsedAt.Valid || !r.ExpiresAt.After(time.Now()) {
+			return fakeResult{}, nil
+		}
+		r.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		s.resets[r.TokenHash] = r
+		return fakeRows(r), nil
+	})
+	db.handle("UpdateUserPassword", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.user.HashedPassword = argString(args[1])
+		return fakeAffected(1), nil
+	})
+	db.handle("InvalidatePasswordResets", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for hash, r := range s.resets {
+			if !r.UsedAt.Valid {
+				r.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+				s.resets[hash] = r
+			}
+		}
+		return fakeAffected(1), nil
+	})
+	return s
+}
+
+// add stores a reset for the user and returns its token.
+func (s *memoryPasswordResets) add(t *testing.T, expiresAt time.Time) string {
+	t.Helper()
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("MakeRefreshToken: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resets[auth.HashToken(token)] = database.PasswordReset{
+		TokenHash: auth.HashToken(token),
+		CreatedAt: time.Now(),
+		UserID:    s.user.ID,
+		ExpiresAt: expiresAt,
+	}
+	return token
+}
+
+// hasPassword reports whether password is the user's password now.
+func (s *memoryPasswordResets) hasPassword(t *testing.T, passwords *auth.Passwords, password string) bool {
+	t.Helper()
+	s.mu.Lock()
+	hash := s.user.HashedPassword
+	s.mu.Unlock()
+	ok, _, err := passwords.Verify(password, hash)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	return ok
+}
+
+// memoryMailer keeps what would have been sent.
+type memoryMailer struct {
+	sent chan mailer.Message
+}
+
+func (m *memoryMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent <- msg
+	return nil
+}
+
+type passwordResetTest struct {
+	cfg       *api.APIConfig
+	db        *fakeDB
+	resets    *memoryPasswordResets
+	sessions  *memoryRefreshTokens
+	mail      *memoryMailer
+	passwords *auth.Passwords
+}
+
+func newPasswordResetTest(t *testing.T) *passwordResetTest {
+	db := newFakeDB(t)
+	passwords := auth.NewPasswords(auth.BcryptHasher{Cost: bcrypt.MinCost})
+	pt := &passwordResetTest{
+		db:        db,
+		resets:    newMemoryPasswordResets(t, db, passwords),
+		sessions:  newMemoryRefreshTokens(db),
+		mail:      &memoryMailer{sent: make(chan mailer.Message, 10)},
+		passwords: passwords,
+	}
+	pt.cfg = &api.APIConfig{
+		DB:                   fakeQueries(db),
+		SQLDB:                db.sqlDB(),
+		Outbox:               outbox.NewRelay(nil, nil),
+		Passwords:            passwords,
+		PasswordPolicy:       passwordpolicy.Policy{MinLength: 8},
+		Mailer:               pt.mail,
+		Metrics:              api.NewAppMetrics(db.sqlDB()),
+		PasswordResetByIP:    ratelimit.New(100, time.Hour),
+		PasswordResetByEmail: ratelimit.New(100, time.Hour),
+	}
+	return pt
+}
+
+// forgot posts to /api/password/forgot.
+func (pt *passwordResetTest) forgot(email string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/password/forgot", strings.NewReader(`{"email": "`+email+`"}`))
+	rec := httptest.NewRecorder()
+	pt.cfg.ForgotPasswordHandler(rec, req)
+	return rec
+}
+
+// reset posts to /api/password/reset.
+func (pt *passwordResetTest) reset(token, password string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/password/reset", strings.NewReader(`{"token": "`+token+`", "password": "`+password+`"}`))
+	rec := httptest.NewRecorder()
+	pt.cfg.ResetPasswordHandler(rec, req)
+	return rec
+}
+
+func TestPasswordReset(t *testing.T) {
+	pt := newPasswordResetTest(t)
+	session := pt.sessions.issue(t, pt.resets.user.ID)
+
+	unknown := pt.forgot("nobody@example.com")
+	known := pt.forgot(pt.resets.user.Email)
+	if known.Code != 202 || unknown.Code != known.Code || unknown.Body.String() != known.Body.String() {
+		t.Errorf("known email = %d %s, unknown = %d %s, want the same 202", known.Code, known.Body, unknown.Code, unknown.Body)
+	}
+
+	var msg mailer.Message
+	select {
+	case msg = <-pt.mail.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reset email was sent")
+	}
+	if msg.To != pt.resets.user.Email {
+		t.Fatalf("reset email went to %s", msg.To)
+	}
+	// the token is the paragraph after the instructions
+	parts := strings.Split(msg.Body, "\n\n")
+	if len(parts) < 3 {
+		t.Fatalf("no token in %q", msg.Body)
+	}
+	token := parts[2]
+
+	if rec := pt.reset(token, "a brand new password"); rec.Code != 204 {
+		t.Fatalf("reset = %d: %s", rec.Code, rec.Body)
+	}
+	if !pt.resets.hasPassword(t, pt.passwords, "a brand new password") {
+		t.Error("password wasn't changed")
+	}
+	if !pt.sessions.get(session).RevokedAt.Valid {
+		t.Error("existing sessions should be revoked")
+	}
+
+	// single use
+	if rec := pt.reset(token, "yet another password"); rec.Code != 400 {
+		t.Errorf("second reset with the token = %d, want 400", rec.Code)
+	}
+	if !pt.resets.hasPassword(t, pt.passwords, "a brand new password") {
+		t.Error("a used token changed the password")
+	}
+}
+
+func TestPasswordResetExpired(t *testing.T) {
+	pt := newPasswordResetTest(t)
+	token := pt.resets.add(t, time.Now().Add(-time.Minute))
+
+	if rec := pt.reset(token, "a brand new password"); rec.Code != 400 {
+		t.Errorf("reset with an expired token = %d, want 400", rec.Code)
+	}
+	if !pt.resets.hasPassword(t, pt.passwords, resetOldPassword) {
+		t.Error("an expired token changed the password")
+	}
+}
+
+func TestPasswordResetIsAtomic(t *testing.T) {
+	pt := newPasswordResetTest(t)
+	session := pt.sessions.issue(t, pt.resets.user.ID)
+	token := pt.resets.add(t, time.Now().Add(time.Hour))
+
+	pt.db.handle("RevokeAllRefreshTokens", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, errors.New("connection refused")
+	})
+	if rec := pt.reset(token, "a brand new password"); rec.Code != 500 {
+		t.Fatalf("reset with the database down = %d, want 500", rec.Code)
+	}
+	if !pt.resets.hasPassword(t, pt.passwords, resetOldPassword) {
+		t.Error("password changed although its sessions weren't revoked")
+	}
+
+	// the token wasn't spent, so the reset can be retried once the
+	// database is back
+	pt.sessions = newMemoryRefreshTokens(pt.db)
+	session = pt.sessions.issue(t, pt.resets.user.ID)
+	if rec := pt.reset(token, "a brand new password"); rec.Code != 204 {
+		t.Fatalf("retried reset = %d: %s", rec.Code, rec.Body)
+	}
+	if !pt.resets.hasPassword(t, pt.passwords, "a brand new password") || !pt.sessions.get(session).RevokedAt.Valid {
+		t.Error("retried reset should change the password and revoke sessions")
+	}
+}
@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/auth"
+)
+
+// small parameters keep the tests fast
+var testArgon2id = auth.Argon2idHasher{Params: auth.Argon2idParams{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}}
+
+func TestArgon2idHashFormat(t *testing.T) {
+	hash, err := testArgon2id.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash error: %v", err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("unexpected PHC string: %s", hash)
+	}
+	if strings.Count(hash, "$") != 5 {
+		t.Errorf("expected 6 PHC fields: %s", hash)
+	}
+
+	other, _ := testArgon2id.Hash("correct horse")
+	if hash == other {
+		t.Error("expected a fresh salt for every hash")
+	}
+}
+
+func TestPasswordsVerify(t *testing.T) {
+	bcryptHasher := auth.BcryptHasher{Cost: 4}
+	passwords := auth.NewPasswords(testArgon2id, bcryptHasher)
+
+	current, _ := passwords.Hash("correct horse")
+	legacy, _ := bcryptHasher.Hash("correct horse")
+	weaker, _ := auth.Argon2idHasher{Params: auth.Argon2idParams{
+		Memory: 512, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32,
+	}}.Hash("correct horse")
+
+	tests := []struct {
+		name       string
+		password   string
+		hash       string
+		wantOK     bool
+		wantRehash bool
+		wantErr    bool
+	}{
+		{"current hash", "correct horse", current, true, false, false},
+		{"wrong password", "battery staple", current, false, false, false},
+		{"legacy bcrypt hash", "correct horse", legacy, true, true, false},
+		{"wrong password on legacy hash", "battery staple", legacy, false, false, false},
+		{"outdated argon2id parameters", "correct horse", weaker, true, true, false},
+		{"unknown format", "correct horse", "plaintext", false, false, true},
+		{"malformed argon2id", "correct horse", "$argon2id$v=19$m=x$salt$hash", false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash, err := passwords.Verify(tt.password, tt.hash)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ok != tt.wantOK || rehash != tt.wantRehash {
+				t.Errorf("Verify() = %v, %v, want %v, %v", ok, rehash, tt.wantOK, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestArgon2idLongPasswords(t *testing.T) {
+	// bcrypt only sees the first 72 bytes; argon2id must see all of them
+	long := strings.Repeat("a", 72)
+
+	hash, err := testArgon2id.Hash(long + "1")
+	if err != nil {
+		t.Fatalf("Hash error: %v", err)
+	}
+	if ok, _ := testArgon2id.Verify(long+"2", hash); ok {
+		t.Error("passwords differing after 72 bytes must not match")
+	}
+	if ok, _ := testArgon2id.Verify(long+"1", hash); !ok {
+		t.Error("expected long password to match")
+	}
+}
+
+// slowHasher takes a while and tracks how many calls overlap.
+type slowHasher struct {
+	auth.PasswordHasher
+	running, peak *atomic.Int32
+}
+
+func (h slowHasher) Verify(password, encoded string) (bool, error) {
+	n := h.running.Add(1)
+	defer h.running.Add(-1)
+	for {
+		peak := h.peak.Load()
+		if n <= peak || h.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return h.PasswordHasher.Verify(password, encoded)
+}
+
+func TestPasswordsMaxConcurrent(t *testing.T) {
+	h := slowHasher{PasswordHasher: testArgon2id, running: new(atomic.Int32), peak: new(atomic.Int32)}
+	passwords := auth.NewPasswords(h).WithMaxConcurrent(2)
+	hash, _ := passwords.Hash("correct horse")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				passwords.Verify("correct horse", hash)
+			} else {
+				passwords.BurnCheck("battery staple")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak := h.peak.Load(); peak > 2 {
+		t.Errorf("%d checks ran at once, want at most 2", peak)
+	}
+}
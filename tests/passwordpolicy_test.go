@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Johnermac/http-server/internal/passwordpolicy"
+)
+
+func rules(violations []passwordpolicy.Violation) []string {
+	out := []string{}
+	for _, v := range violations {
+		out = append(out, v.Rule)
+	}
+	return out
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := passwordpolicy.DefaultPolicy
+
+	tests := []struct {
+		name     string
+		password string
+		email    string
+		want     []string
+	}{
+		{"empty", "", "walt@example.com", []string{"min_length", "entropy"}},
+		{"short", "xK9#", "walt@example.com", []string{"min_length", "entropy"}},
+		{"email as password", "Walt@Example.com", "walt@example.com", []string{"not_email"}},
+		{"email local part", "walterwhite", "walterwhite@example.com", []string{"not_email"}},
+		{"common password with suffix", "Password123", "walt@example.com", []string{"entropy"}},
+		{"leetspeak common password", "P@ssw0rd!", "walt@example.com", []string{"entropy"}},
+		{"common password with digits", "zaq1zaq1", "walt@example.com", []string{"entropy"}},
+		{"common password with digits, capitalized", "Blink182", "walt@example.com", []string{"entropy"}},
+		{"repeats", "aaaaaaaaaaaa", "walt@example.com", []string{"entropy"}},
+		{"sequence", "abcdefgh12", "walt@example.com", []string{"entropy"}},
+		{"strong", "correct horse battery staple", "walt@example.com", []string{}},
+		{"random", "Tr0ub4dor&3", "walt@example.com", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rules(policy.Check(tt.password, tt.email))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Check(%q) rules = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreachedPasswordCorpus(t *testing.T) {
+	dir := t.TempDir()
+
+	// a range file with the password's suffix and one unrelated hash
+	prefix, suffix := passwordpolicy.HashPrefix("correct horse battery staple")
+	lines := "0000000000000000000000000000000000A:3\n" + suffix + ":1742\n"
+	if err := os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	corpus, err := passwordpolicy.OpenCorpus(dir)
+	if err != nil {
+		t.Fatalf("OpenCorpus error: %v", err)
+	}
+
+	if n, err := corpus.Count("correct horse battery staple"); err != nil || n != 1742 {
+		t.Errorf("Count() = %d, %v, want 1742", n, err)
+	}
+	if n, err := corpus.Count("a password nobody has used yet"); err != nil || n != 0 {
+		t.Errorf("Count() = %d, %v, want 0", n, err)
+	}
+
+	policy := passwordpolicy.DefaultPolicy
+	policy.Breached = corpus
+	if got := rules(policy.Check("correct horse battery staple", "walt@example.com")); !slices.Equal(got, []string{"breached"}) {
+		t.Errorf("rules = %v, want [breached]", got)
+	}
+}
+
+func TestHashPrefixKnownValue(t *testing.T) {
+	// the example from the Pwned Passwords range API docs
+	prefix, suffix := passwordpolicy.HashPrefix("password")
+	if prefix != "5BAA6" || suffix != "1E4C9B93F3F0682250B6CF8331B7EE68FD8" {
+		t.Errorf("HashPrefix() = %s, %s", prefix, suffix)
+	}
+}
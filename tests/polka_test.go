@@ -0,0 +1,267 @@
+package tests
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/api"
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/billing"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/outbox"
+	"github.com/Johnermac/http-server/internal/webhook"
+	"github.com/google/uuid"
+)
+
+var polkaSecret = []byte("whsec_polka")
+
+// memoryWebhookEvents answers the webhook event queries like the table would.
+type memoryWebhookEvents struct {
+	mu     sync.Mutex
+	events map[uuid.UUID]*database.WebhookEvent
+}
+
+func newMemoryWebhookEvents(db *fakeDB) *memoryWebhookEvents {
+	s := &memoryWebhookEvents{events: map[uuid.UUID]*database.WebhookEvent{}}
+
+	db.handle("CreateWebhookEvent", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, e := range s.events {
+			if e.Provider == argString(args[0]) && e.EventID == argString(args[1]) {
+				return fakeResult{}, nil
+			}
+		}
+		now := time.Now()
+		e := &database.WebhookEvent{
+			ID:         uuid.New(),
+			ReceivedAt: now,
+			Provider:   argString(args[0]),
+			EventID:    argString(args[1]),
+			EventType:  argString(args[2]),
+			Payload:    json.RawMessage(argString(args[3])),
+			Status:     "processing",
+			ClaimedAt:  now,
+		}
+		s.events[e.ID] = e
+		return fakeRows(*e), nil
+	})
+	db.handle("GetWebhookEventByEventID", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, e := range s.events {
+			if e.Provider == argString(args[0]) && e.EventID == argString(args[1]) {
+				return fakeRows(*e), nil
+			}
+		}
+		return fakeResult{}, nil
+	})
+	db.handle("GetWebhookEvent", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if e, ok := s.events[argUUID(args[0])]; ok {
+			return fakeRows(*e), nil
+		}
+		return fakeResult{}, nil
+	})
+	db.handle("RetryWebhookEvent", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		e, ok := s.events[argUUID(args[0])]
+		if !ok {
+			return fakeResult{}, nil
+		}
+		stalled := e.Status == "processing" && e.ClaimedAt.Before(argTime(args[1]))
+		if e.Status != "failed" && !stalled {
+			return fakeResult{}, nil
+		}
+		e.Status = "processing"
+		e.ClaimedAt = time.Now()
+		return fakeRows(*e), nil
+	})
+	db.handle("FinishWebhookEvent", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if e, ok := s.events[argUUID(args[0])]; ok {
+			e.Status = argString(args[1])
+			e.Attempts++
+			e.LastError = sql.NullString{String: argString(args[2]), Valid: args[2] != nil}
+		}
+		return fakeAffected(1), nil
+	})
+	return s
+}
+
+// add stores an event as if an earlier delivery had recorded it.
+func (s *memoryWebhookEvents) add(e database.WebhookEvent) uuid.UUID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = uuid.New()
+	e.Provider = "polka"
+	s.events[e.ID] = &e
+	return e.ID
+}
+
+// get is the stored row for a Polka event id.
+func (s *memoryWebhookEvents) get(t *testing.T, eventID string) database.WebhookEvent {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.events {
+		if e.EventID == eventID {
+			return *e
+		}
+	}
+	t.Fatalf("event %s wasn't recorded", eventID)
+	return database.WebhookEvent{}
+}
+
+func newPolkaConfig(t *testing.T) (*api.APIConfig, *fakeDB, *memoryWebhookEvents) {
+	db := newFakeDB(t)
+	events := newMemoryWebhookEvents(db)
+	return &api.APIConfig{
+		DB:            fakeQueries(db),
+		SQLDB:         db.sqlDB(),
+		Outbox:        outbox.NewRelay(nil, nil),
+		Metrics:       api.NewAppMetrics(db.sqlDB()),
+		Billing:       billing.DefaultPolicy,
+		PolkaWebhooks: &webhook.Verifier{Secrets: [][]byte{polkaSecret}, Tolerance: 5 * time.Minute},
+	}, db, events
+}
+
+// postPolka delivers a signed Polka webhook.
+func postPolka(cfg *api.APIConfig, body string) *httptest.ResponseRecorder {
+	now := time.Now()
+	req := httptest.NewRequest("POST", "/api/polka/webhooks", bytes.NewBufferString(body))
+	req.Header.Set("Polka-Timestamp", strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set("Polka-Signature", webhook.Sign(polkaSecret, now, []byte(body)))
+	rec := httptest.NewRecorder()
+	cfg.UpdatePremiumUserHandler(rec, req)
+	return rec
+}
+
+// an event type Polka sends that doesn't touch subscriptions
+const polkaIgnoredEvent = `{"id": "evt_1", "event": "user.created", "data": {"user_id": "3311741c-680c-4546-99f3-fc9efac2036c"}}`
+
+func TestPolkaWebhookRequiresEventID(t *testing.T) {
+	cfg, _, events := newPolkaConfig(t)
+
+	rec := postPolka(cfg, `{"event": "user.upgraded", "data": {"user_id": "3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+	if rec.Code != 400 {
+		t.Errorf("event without an id = %d, want 400", rec.Code)
+	}
+	if len(events.events) != 0 {
+		t.Errorf("recorded %d events, want none", len(events.events))
+	}
+}
+
+func TestPolkaWebhookRedelivery(t *testing.T) {
+	cfg, _, events := newPolkaConfig(t)
+
+	for i := 0; i < 2; i++ {
+		if rec := postPolka(cfg, polkaIgnoredEvent); rec.Code != 204 {
+			t.Fatalf("delivery %d = %d: %s", i+1, rec.Code, rec.Body)
+		}
+	}
+	if e := events.get(t, "evt_1"); e.Status != "ignored" || e.Attempts != 1 {
+		t.Errorf("event is %s after %d attempts, want ignored after 1", e.Status, e.Attempts)
+	}
+}
+
+func TestPolkaWebhookTakesOverStalledEvent(t *testing.T) {
+	cfg, _, events := newPolkaConfig(t)
+
+	// an attempt that's still within its lease is left alone
+	busy := events.add(database.WebhookEvent{
+		EventID:   "evt_busy",
+		EventType: "user.created",
+		Payload:   json.RawMessage(`{"id": "evt_busy", "event": "user.created"}`),
+		Status:    "processing",
+		ClaimedAt: time.Now().Add(-time.Minute),
+	})
+	if rec := postPolka(cfg, `{"id": "evt_busy", "event": "user.created"}`); rec.Code != 204 {
+		t.Fatalf("redelivery while processing = %d", rec.Code)
+	}
+	if e := events.get(t, "evt_busy"); e.Status != "processing" || e.Attempts != 0 {
+		t.Errorf("event in progress was %s after %d attempts, want it untouched", e.Status, e.Attempts)
+	}
+
+	req := httptest.NewRequest("POST", "/admin/webhooks/"+busy.String()+"/replay", nil)
+	req.SetPathValue("eventID", busy.String())
+	rec := httptest.NewRecorder()
+	cfg.ReplayWebhookEventHandler(rec, req)
+	if rec.Code != 409 {
+		t.Errorf("replaying an event in progress = %d, want 409", rec.Code)
+	}
+
+	// one whose process died mid-attempt is picked up by the next delivery
+	events.add(database.WebhookEvent{
+		EventID:   "evt_1",
+		EventType: "user.created",
+		Payload:   json.RawMessage(polkaIgnoredEvent),
+		Status:    "processing",
+		ClaimedAt: time.Now().Add(-time.Hour),
+	})
+	if rec := postPolka(cfg, polkaIgnoredEvent); rec.Code != 204 {
+		t.Fatalf("redelivery of a stalled event = %d: %s", rec.Code, rec.Body)
+	}
+	if e := events.get(t, "evt_1"); e.Status != "ignored" || e.Attempts != 1 {
+		t.Errorf("stalled event is %s after %d attempts, want ignored after 1", e.Status, e.Attempts)
+	}
+}
+
+func TestPolkaWebhookFailures(t *testing.T) {
+	user := database.User{ID: uuid.New(), CreatedAt: time.Now(), UpdatedAt: time.Now(), Email: "walt@example.com", Role: auth.RoleUser}
+	renewal := `{"id": "evt_renew", "event": "user.renewed", "data": {"user_id": "` + user.ID.String() + `"}}`
+
+	tests := []struct {
+		name         string
+		subscription func([]driver.Value) (fakeResult, error)
+		wantCode     int
+		wantBody     string
+	}{
+		{
+			// nothing to renew: Polka sent something that can't apply
+			name:         "invalid event",
+			subscription: func([]driver.Value) (fakeResult, error) { return fakeResult{}, nil },
+			wantCode:     422,
+			wantBody:     "no subscription to renew",
+		},
+		{
+			// our fault, so Polka should try again later
+			name:         "database down",
+			subscription: func([]driver.Value) (fakeResult, error) { return fakeResult{}, errors.New("connection refused") },
+			wantCode:     500,
+			wantBody:     "Something went wrong",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, db, events := newPolkaConfig(t)
+			db.handle("GetUser", func([]driver.Value) (fakeResult, error) { return fakeRows(user), nil })
+			db.handle("GetSubscription", tt.subscription)
+
+			rec := postPolka(cfg, renewal)
+			if rec.Code != tt.wantCode || !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("delivery = %d %s, want %d %q", rec.Code, rec.Body, tt.wantCode, tt.wantBody)
+			}
+			if strings.Contains(rec.Body.String(), "connection refused") {
+				t.Errorf("response leaks the cause: %s", rec.Body)
+			}
+
+			// either way it can be retried, and the admin sees why it failed
+			if e := events.get(t, "evt_renew"); e.Status != "failed" || !e.LastError.Valid {
+				t.Errorf("event is %s with error %v, want failed with the cause", e.Status, e.LastError)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/ratelimit"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	limiter := ratelimit.New(3, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow("a") {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+	if limiter.Allow("a") {
+		t.Errorf("4th request should be limited")
+	}
+
+	// keys are independent
+	if !limiter.Allow("b") {
+		t.Errorf("other key should be allowed")
+	}
+}
+
+func TestLimiterRefills(t *testing.T) {
+	limiter := ratelimit.New(1, 20*time.Millisecond)
+
+	if !limiter.Allow("a") {
+		t.Fatalf("first request should be allowed")
+	}
+	if limiter.Allow("a") {
+		t.Fatalf("second request should be limited")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !limiter.Allow("a") {
+		t.Errorf("request after window should be allowed")
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{8, 8 * time.Minute},
+		{50, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := ratelimit.BackoffDelay(tt.failures, 5, time.Minute, time.Hour); got != tt.want {
+			t.Errorf("BackoffDelay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffBlocks(t *testing.T) {
+	backoff := ratelimit.NewBackoff(3, time.Hour, 24*time.Hour)
+
+	for i := 0; i < 2; i++ {
+		backoff.Fail("1.2.3.4")
+		if _, blocked := backoff.Blocked("1.2.3.4"); blocked {
+			t.Fatalf("should not be blocked after %d failures", i+1)
+		}
+	}
+
+	if delay := backoff.Fail("1.2.3.4"); delay != time.Hour {
+		t.Errorf("Fail() = %s, want %s", delay, time.Hour)
+	}
+	if wait, blocked := backoff.Blocked("1.2.3.4"); !blocked || wait <= 0 {
+		t.Errorf("expected key to be blocked, got %s, %v", wait, blocked)
+	}
+
+	// keys are independent
+	if _, blocked := backoff.Blocked("5.6.7.8"); blocked {
+		t.Errorf("other key should not be blocked")
+	}
+
+	backoff.Reset("1.2.3.4")
+	if _, blocked := backoff.Blocked("1.2.3.4"); blocked {
+		t.Errorf("reset key should not be blocked")
+	}
+}
@@ -0,0 +1,211 @@
+package tests
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/api"
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/google/uuid"
+)
+
+// memoryRefreshTokens answers the refresh token queries like the table would.
+type memoryRefreshTokens struct {
+	mu     sync.Mutex
+	tokens map[string]*database.RefreshToken
+}
+
+func newMemoryRefreshTokens(db *fakeDB) *memoryRefreshTokens {
+	s := &memoryRefreshTokens{tokens: map[string]*database.RefreshToken{}}
+
+	db.handle("GetRefreshToken", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if t, ok := s.tokens[argString(args[0])]; ok {
+			return fakeRows(*t), nil
+		}
+		return fakeResult{}, nil
+	})
+	db.handle("InsertRefreshToken", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		now := time.Now()
+		t := &database.RefreshToken{
+			TokenHash:  argString(args[0]),
+			CreatedAt:  now,
+			UpdatedAt:  now,
+			UserID:     argUUID(args[1]),
+			ExpiresAt:  now.Add(60 * 24 * time.Hour),
+			FamilyID:   argUUID(args[2]),
+			ID:         uuid.New(),
+			LastUsedAt: now,
+			UserAgent:  argString(args[3]),
+			Ip:         argString(args[4]),
+		}
+		s.tokens[t.TokenHash] = t
+		return fakeRows(*t), nil
+	})
+	db.handle("UpdateRevokeAt", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		t, ok := s.tokens[argString(args[0])]
+		if !ok || t.RevokedAt.Valid {
+			return fakeResult{}, nil
+		}
+		t.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		if replacedBy := argString(args[1]); replacedBy != "" {
+			t.ReplacedBy = sql.NullString{String: replacedBy, Valid: true}
+		}
+		return fakeRows(*t), nil
+	})
+	db.handle("RevokeRefreshTokenFamily", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var n int64
+		for _, t := range s.tokens {
+			if t.FamilyID == argUUID(args[0]) && !t.RevokedAt.Valid {
+				t.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+				n++
+			}
+		}
+		return fakeAffected(n), nil
+	})
+	db.handle("RevokeAllRefreshTokens", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, t := range s.tokens {
+			if t.UserID == argUUID(args[0]) && !t.RevokedAt.Valid {
+				t.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+			}
+		}
+		return fakeAffected(0), nil
+	})
+	db.handle("TouchSession", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		now := time.Now()
+		active := false
+		for _, t := range s.tokens {
+			if t.FamilyID != argUUID(args[0]) || t.RevokedAt.Valid || !t.ExpiresAt.After(now) {
+				continue
+			}
+			active = true
+			if t.LastUsedAt.Before(now.Add(-time.Minute)) {
+				t.LastUsedAt = now
+			}
+		}
+		return fakeResult{rows: [][]driver.Value{{active}}}, nil
+	})
+	db.handle("RevokeUserSession", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var n int64
+		for _, t := range s.tokens {
+			if t.UserID == argUUID(args[0]) && t.FamilyID == argUUID(args[1]) && !t.RevokedAt.Valid {
+				t.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+				n++
+			}
+		}
+		return fakeAffected(n), nil
+	})
+	return s
+}
+
+// get is the stored row for token.
+func (s *memoryRefreshTokens) get(token string) database.RefreshToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.tokens[auth.HashToken(token)]
+}
+
+// issue stores a fresh token for a new session of userID.
+func (s *memoryRefreshTokens) issue(t *testing.T, userID uuid.UUID) string {
+	t.Helper()
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("MakeRefreshToken: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.tokens[auth.HashToken(token)] = &database.RefreshToken{
+		TokenHash:  auth.HashToken(token),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		UserID:     userID,
+		ExpiresAt:  now.Add(time.Hour),
+		FamilyID:   uuid.New(),
+		ID:         uuid.New(),
+		LastUsedAt: now,
+	}
+	return token
+}
+
+// refresh calls POST /api/refresh and returns the status and rotated token.
+func refresh(t *testing.T, cfg *api.APIConfig, token string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/api/refresh", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	cfg.UpdateTokenHandler(rec, req)
+
+	var body struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return rec.Code, body.RefreshToken
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	db := newFakeDB(t)
+	tokens := newMemoryRefreshTokens(db)
+	cfg := &api.APIConfig{DB: fakeQueries(db), Keyset: auth.NewHMACKeyset("supersecret")}
+
+	first := tokens.issue(t, uuid.New())
+	code, second := refresh(t, cfg, first)
+	if code != 200 || second == "" || second == first {
+		t.Fatalf("refresh = %d, %q; want a new token", code, second)
+	}
+
+	code, third := refresh(t, cfg, second)
+	if code != 200 || third == "" {
+		t.Fatalf("refreshing the rotated token = %d", code)
+	}
+
+	// second was rotated: presenting it again is reuse
+	if code, _ := refresh(t, cfg, second); code != 401 {
+		t.Errorf("reused token answered %d, want 401", code)
+	}
+	// which revoked the family, so its newest token is dead too
+	if code, _ := refresh(t, cfg, third); code != 401 {
+		t.Errorf("token from a revoked family answered %d, want 401", code)
+	}
+	if !tokens.get(third).RevokedAt.Valid {
+		t.Error("reuse should revoke every token in the family")
+	}
+}
+
+func TestRefreshTokenReuseLeavesOtherSessions(t *testing.T) {
+	db := newFakeDB(t)
+	tokens := newMemoryRefreshTokens(db)
+	cfg := &api.APIConfig{DB: fakeQueries(db), Keyset: auth.NewHMACKeyset("supersecret")}
+
+	userID := uuid.New()
+	leaked := tokens.issue(t, userID)
+	otherDevice := tokens.issue(t, userID)
+
+	refresh(t, cfg, leaked)
+	if code, _ := refresh(t, cfg, leaked); code != 401 {
+		t.Fatalf("reused token answered %d, want 401", code)
+	}
+	if code, _ := refresh(t, cfg, otherDevice); code != 200 {
+		t.Errorf("another session of the same user answered %d, want 200", code)
+	}
+}
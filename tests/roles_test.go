@@ -0,0 +1,203 @@
+package tests
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/api"
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/outbox"
+	"github.com/google/uuid"
+)
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		role     string
+		required string
+		want     bool
+	}{
+		{auth.RoleUser, auth.RoleUser, true},
+		{auth.RoleUser, auth.RoleModerator, false},
+		{auth.RoleUser, auth.RoleAdmin, false},
+		{auth.RoleModerator, auth.RoleUser, true},
+		{auth.RoleModerator, auth.RoleModerator, true},
+		{auth.RoleModerator, auth.RoleAdmin, false},
+		{auth.RoleAdmin, auth.RoleModerator, true},
+		{auth.RoleAdmin, auth.RoleAdmin, true},
+		{"", auth.RoleUser, false},
+		{"superuser", auth.RoleUser, false},
+		{auth.RoleAdmin, "superuser", false},
+	}
+
+	for _, tt := range tests {
+		if got := auth.HasRole(tt.role, tt.required); got != tt.want {
+			t.Errorf("HasRole(%q, %q) = %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRole(t *testing.T) {
+	for _, role := range auth.Roles {
+		if err := auth.ValidateRole(role); err != nil {
+			t.Errorf("ValidateRole(%q) error: %v", role, err)
+		}
+	}
+	for _, role := range []string{"", "Admin", "root"} {
+		if err := auth.ValidateRole(role); err == nil {
+			t.Errorf("ValidateRole(%q) expected error", role)
+		}
+	}
+}
+
+// memoryUsers answers the user queries role changes run.
+type memoryUsers struct {
+	mu    sync.Mutex
+	users map[uuid.UUID]*database.User
+}
+
+func newMemoryUsers(db *fakeDB) *memoryUsers {
+	s := &memoryUsers{users: map[uuid.UUID]*database.User{}}
+
+	db.handle("GetUser", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if u, ok := s.users[argUUID(args[0])]; ok {
+			return fakeRows(*u), nil
+		}
+		return fakeResult{}, nil
+	})
+	db.handle("LockUsersByRole", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var res fakeResult
+		for _, u := range s.users {
+			if u.Role == argString(args[0]) {
+				res.rows = append(res.rows, []driver.Value{u.ID.String()})
+			}
+		}
+		return res, nil
+	})
+	db.handle("UpdateUserRole", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		u, ok := s.users[argUUID(args[0])]
+		if !ok {
+			return fakeResult{}, nil
+		}
+		u.Role = argString(args[1])
+		u.UpdatedAt = time.Now()
+		return fakeRows(*u), nil
+	})
+	return s
+}
+
+// add stores a user with role and returns a session token for them.
+func (s *memoryUsers) add(t *testing.T, ks *auth.Keyset, role string) (uuid.UUID, string) {
+	t.Helper()
+	u := &database.User{ID: uuid.New(), CreatedAt: time.Now(), UpdatedAt: time.Now(), Email: uuid.NewString() + "@example.com", Role: role}
+	s.mu.Lock()
+	s.users[u.ID] = u
+	s.mu.Unlock()
+
+	token, err := ks.MakeSessionJWT(u.ID, uuid.Nil)
+	if err != nil {
+		t.Fatalf("MakeSessionJWT: %v", err)
+	}
+	return u.ID, token
+}
+
+func newRolesConfig(t *testing.T) (*api.APIConfig, *memoryUsers) {
+	db := newFakeDB(t)
+	users := newMemoryUsers(db)
+	return &api.APIConfig{
+		DB:     fakeQueries(db),
+		SQLDB:  db.sqlDB(),
+		Keyset: auth.NewHMACKeyset("supersecret"),
+		Outbox: outbox.NewRelay(nil, nil),
+	}, users
+}
+
+func TestMiddlewareRequireRole(t *testing.T) {
+	cfg, users := newRolesConfig(t)
+	handler := cfg.MiddlewareRequireRole(auth.RoleModerator, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	}))
+
+	_, user := users.add(t, cfg.Keyset, auth.RoleUser)
+	_, moderator := users.add(t, cfg.Keyset, auth.RoleModerator)
+	_, admin := users.add(t, cfg.Keyset, auth.RoleAdmin)
+	pat, _ := auth.MakePersonalAccessToken()
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"no token", "", 401},
+		{"garbage token", "not-a-jwt", 401},
+		{"personal access token", pat, 401},
+		{"user", user, 403},
+		{"moderator", moderator, 204},
+		{"admin outranks moderator", admin, 204},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/admin/users/x/unlock", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetUserRoleKeepsLastAdmin(t *testing.T) {
+	cfg, users := newRolesConfig(t)
+	first, _ := users.add(t, cfg.Keyset, auth.RoleAdmin)
+	second, _ := users.add(t, cfg.Keyset, auth.RoleUser)
+
+	setRole := func(userID uuid.UUID, role string) int {
+		req := httptest.NewRequest("PUT", "/admin/users/"+userID.String()+"/role", strings.NewReader(`{"role": "`+role+`"}`))
+		req.SetPathValue("userID", userID.String())
+		rec := httptest.NewRecorder()
+		cfg.SetUserRoleHandler(rec, req)
+		return rec.Code
+	}
+
+	if got := setRole(first, auth.RoleUser); got != 409 {
+		t.Errorf("demoting the last admin = %d, want 409", got)
+	}
+	if got := setRole(second, auth.RoleAdmin); got != 200 {
+		t.Fatalf("promoting a user = %d", got)
+	}
+	if got := setRole(first, auth.RoleModerator); got != 200 {
+		t.Errorf("demoting one of two admins = %d, want 200", got)
+	}
+	if got := setRole(second, auth.RoleUser); got != 409 {
+		t.Errorf("demoting the new last admin = %d, want 409", got)
+	}
+	if got := setRole(uuid.New(), auth.RoleUser); got != 404 {
+		t.Errorf("unknown user = %d, want 404", got)
+	}
+
+	var admins []uuid.UUID
+	for id, u := range users.users {
+		if u.Role == auth.RoleAdmin {
+			admins = append(admins, id)
+		}
+	}
+	if !slices.Equal(admins, []uuid.UUID{second}) {
+		t.Errorf("admins = %v, want only %v", admins, second)
+	}
+}
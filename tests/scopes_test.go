@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"database/sql/driver"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/api"
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestScopesHas(t *testing.T) {
+	pat := auth.Scopes{auth.ScopeProfileWrite}
+	if !pat.Has(auth.ScopeProfileWrite) {
+		t.Error("expected granted scope to be present")
+	}
+	if pat.Has(auth.ScopeChirpsWrite) {
+		t.Error("expected missing scope to be rejected")
+	}
+	if pat.Has(auth.ScopeAll) {
+		t.Error("a PAT must never hold the full scope")
+	}
+
+	jwt := auth.Scopes{auth.ScopeAll}
+	if !jwt.Has(auth.ScopeChirpsWrite) {
+		t.Error("expected full scope to grant everything")
+	}
+}
+
+func TestValidatePATScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  []string
+		wantErr bool
+	}{
+		{"valid", []string{auth.ScopeChirpsWrite, auth.ScopeProfileWrite}, false},
+		{"no read scope", []string{"chirps:read"}, true},
+		{"empty", nil, true},
+		{"unknown", []string{"admin"}, true},
+		{"full scope", []string{auth.ScopeAll}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := auth.ValidatePATScopes(tt.scopes)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePATScopes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMakePersonalAccessToken(t *testing.T) {
+	a, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := auth.MakePersonalAccessToken()
+	if a == b {
+		t.Error("expected unique tokens")
+	}
+	if !auth.IsPersonalAccessToken(a) {
+		t.Errorf("expected %q to be recognised as a PAT", a)
+	}
+	if auth.IsPersonalAccessToken("eyJhbGciOi.x.y") {
+		t.Error("expected a JWT not to be recognised as a PAT")
+	}
+}
+
+func TestPATCannotChangeCredentials(t *testing.T) {
+	for _, scopes := range [][]string{
+		{auth.ScopeProfileWrite},
+		auth.PATScopes,
+	} {
+		t.Run(strings.Join(scopes, ","), func(t *testing.T) {
+			token, _ := auth.MakePersonalAccessToken()
+			pat := database.PersonalAccessToken{
+				ID:        uuid.New(),
+				CreatedAt: time.Now(),
+				UserID:    uuid.New(),
+				Name:      "bot",
+				TokenHash: auth.HashToken(token),
+				Scopes:    scopes,
+			}
+
+			// any query past the scope check fails the test
+			db := newFakeDB(t)
+			db.handle("GetPersonalAccessToken", func(args []driver.Value) (fakeResult, error) {
+				return fakeRows(pat), nil
+			})
+			db.handle("TouchPersonalAccessToken", func(args []driver.Value) (fakeResult, error) {
+				return fakeAffected(1), nil
+			})
+			cfg := &api.APIConfig{DB: fakeQueries(db), Keyset: auth.NewHMACKeyset("supersecret")}
+
+			body := `{"email": "attacker@example.com", "password": "a brand new passphrase"}`
+			req := httptest.NewRequest("PUT", "/api/users", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			cfg.UpdateUserHandler(rec, req)
+
+			if rec.Code != 403 {
+				t.Errorf("PAT changing credentials got %d, want 403", rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/api"
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/google/uuid"
+)
+
+func TestRevokedSessionRejectsAccessTokens(t *testing.T) {
+	db := newFakeDB(t)
+	tokens := newMemoryRefreshTokens(db)
+	cfg := &api.APIConfig{DB: fakeQueries(db), Keyset: auth.NewHMACKeyset("supersecret")}
+
+	userID := uuid.New()
+	phone := tokens.get(tokens.issue(t, userID)).FamilyID
+	laptop := tokens.get(tokens.issue(t, userID)).FamilyID
+
+	phoneJWT, _ := cfg.Keyset.MakeSessionJWT(userID, phone)
+	laptopJWT, _ := cfg.Keyset.MakeSessionJWT(userID, laptop)
+
+	authenticate := func(token string) error {
+		req := httptest.NewRequest("GET", "/api/me/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		_, _, err := cfg.AuthenticateRequest(req)
+		return err
+	}
+	if err := authenticate(phoneJWT); err != nil {
+		t.Fatalf("active session rejected: %v", err)
+	}
+
+	// sign the phone out from the laptop
+	req := httptest.NewRequest("DELETE", "/api/me/sessions/"+phone.String(), nil)
+	req.SetPathValue("sessionID", phone.String())
+	req.Header.Set("Authorization", "Bearer "+laptopJWT)
+	rec := httptest.NewRecorder()
+	cfg.RevokeSessionHandler(rec, req)
+	if rec.Code != 204 {
+		t.Fatalf("revoke session = %d", rec.Code)
+	}
+
+	if err := authenticate(phoneJWT); err == nil {
+		t.Error("access token of a revoked session still works")
+	}
+	if err := authenticate(laptopJWT); err != nil {
+		t.Errorf("other session rejected: %v", err)
+	}
+}
+
+func TestAuthenticationTouchesSession(t *testing.T) {
+	db := newFakeDB(t)
+	tokens := newMemoryRefreshTokens(db)
+	cfg := &api.APIConfig{DB: fakeQueries(db), Keyset: auth.NewHMACKeyset("supersecret")}
+
+	userID := uuid.New()
+	refreshToken := tokens.issue(t, userID)
+	hourAgo := time.Now().Add(-time.Hour)
+	tokens.tokens[auth.HashToken(refreshToken)].LastUsedAt = hourAgo
+
+	accessJWT, _ := cfg.Keyset.MakeSessionJWT(userID, tokens.get(refreshToken).FamilyID)
+	req := httptest.NewRequest("GET", "/api/me/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+accessJWT)
+	if _, _, err := cfg.AuthenticateRequest(req); err != nil {
+		t.Fatalf("AuthenticateRequest: %v", err)
+	}
+
+	if used := tokens.get(refreshToken).LastUsedAt; !used.After(hourAgo) {
+		t.Errorf("last_used_at = %v, should have moved on", used)
+	}
+}
@@ -0,0 +1,271 @@
+package tests
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base32"
+	"encoding/json"
+	"hash"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Johnermac/http-server/internal/api"
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/ratelimit"
+)
+
+// Test vectors from RFC 6238, Appendix B.
+func TestGenerateTOTPCodeRFC6238(t *testing.T) {
+	seed := "1234567890"
+	secrets := map[string][]byte{
+		"SHA1":   []byte(strings.Repeat(seed, 2)),
+		"SHA256": []byte(strings.Repeat(seed, 3) + "12"),
+		"SHA512": []byte(strings.Repeat(seed, 6) + "1234"),
+	}
+	hashes := map[string]func() hash.Hash{
+		"SHA1":   sha1.New,
+		"SHA256": sha256.New,
+		"SHA512": sha512.New,
+	}
+
+	tests := []struct {
+		unix int64
+		alg  string
+		code string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111109, "SHA256", "68084774"},
+		{1111111109, "SHA512", "25091201"},
+		{1111111111, "SHA1", "14050471"},
+		{1111111111, "SHA256", "67062674"},
+		{1111111111, "SHA512", "99943326"},
+		{1234567890, "SHA1", "89005924"},
+		{1234567890, "SHA256", "91819424"},
+		{1234567890, "SHA512", "93441116"},
+		{2000000000, "SHA1", "69279037"},
+		{2000000000, "SHA256", "90698825"},
+		{2000000000, "SHA512", "38618901"},
+		{20000000000, "SHA1", "65353130"},
+		{20000000000, "SHA256", "77737706"},
+		{20000000000, "SHA512", "47863826"},
+	}
+
+	for _, tc := range tests {
+		got := auth.GenerateTOTPCode(secrets[tc.alg], time.Unix(tc.unix, 0), 8, hashes[tc.alg])
+		if got != tc.code {
+			t.Errorf("%s at %d: expected %s, got %s", tc.alg, tc.unix, tc.code, got)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret error: %v", err)
+	}
+	key, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+
+	now := time.Now()
+	tests := []struct {
+		name   string
+		code   string
+		expect bool
+	}{
+		{"current step", auth.GenerateTOTPCode(key, now, 6, sha1.New), true},
+		{"previous step (drift)", auth.GenerateTOTPCode(key, now.Add(-30*time.Second), 6, sha1.New), true},
+		{"too old", auth.GenerateTOTPCode(key, now.Add(-5*time.Minute), 6, sha1.New), false},
+		{"wrong length", "123", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, ok := auth.ValidateTOTP(secret, tc.code, now); ok != tc.expect {
+				t.Errorf("expected %v, got %v", tc.expect, ok)
+			}
+		})
+	}
+}
+
+func TestMFATokenIsNotAnAccessToken(t *testing.T) {
+	secret := "supersecret"
+	userID := uuid.New()
+
+	token, err := auth.MakeMFAToken(userID, secret, time.Minute)
+	if err != nil {
+		t.Fatalf("MakeMFAToken error: %v", err)
+	}
+	gotID, err := auth.ValidateMFAToken(token, secret)
+	if err != nil || gotID != userID {
+		t.Errorf("expected %v, got %v (err %v)", userID, gotID, err)
+	}
+	if _, err := auth.ValidateJWT(token, secret); err == nil {
+		t.Errorf("expected ValidateJWT to reject MFA token")
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	codes, err := auth.GenerateRecoveryCodes(1)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes error: %v", err)
+	}
+	want := auth.NormalizeRecoveryCode(codes[0])
+
+	for _, typed := range []string{
+		codes[0],
+		strings.ReplaceAll(codes[0], "-", ""),
+		strings.ToUpper(codes[0]),
+		" " + strings.ReplaceAll(codes[0], "-", " ") + " ",
+	} {
+		if got := auth.NormalizeRecoveryCode(typed); got != want {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q, want %q", typed, got, want)
+		}
+	}
+}
+
+// memoryTOTP answers the 2FA queries for one user with 2FA enabled.
+type memoryTOTP struct {
+	mu       sync.Mutex
+	totp     database.UserTotp
+	recovery map[string]bool // code hash: used
+}
+
+func newMemoryTOTP(t *testing.T, db *fakeDB, userID uuid.UUID) *memoryTOTP {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret error: %v", err)
+	}
+	s := &memoryTOTP{
+		totp: database.UserTotp{
+			UserID:      userID,
+			CreatedAt:   time.Now(),
+			Secret:      secret,
+			ConfirmedAt: sql.NullTime{Time: time.Now(), Valid: true},
+		},
+		recovery: map[string]bool{},
+	}
+
+	db.handle("GetTOTP", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return fakeRows(s.totp), nil
+	})
+	db.handle("UseTOTPStep", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		step := args[1].(int64)
+		if s.totp.LastUsedStep >= step {
+			return fakeAffected(0), nil
+		}
+		s.totp.LastUsedStep = step
+		return fakeAffected(1), nil
+	})
+	db.handle("UseRecoveryCode", func(args []driver.Value) (fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		used, ok := s.recovery[argString(args[1])]
+		if !ok || used {
+			return fakeAffected(0), nil
+		}
+		s.recovery[argString(args[1])] = true
+		return fakeAffected(1), nil
+	})
+	return s
+}
+
+// code is the current TOTP code.
+func (s *memoryTOTP) code() string {
+	key, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s.totp.Secret)
+	return auth.GenerateTOTPCode(key, time.Now(), 6, sha1.New)
+}
+
+type loginResponse struct {
+	MFARequired  bool   `json:"mfa_required"`
+	MFAToken     string `json:"mfa_token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func TestLoginWithTOTP(t *testing.T) {
+	db := newFakeDB(t)
+	passwords := auth.NewPasswords(auth.BcryptHasher{Cost: bcrypt.MinCost})
+	logins := newMemoryLogins(t, db, passwords)
+	totp := newMemoryTOTP(t, db, logins.user.ID)
+	cfg := &api.APIConfig{
+		DB:                fakeQueries(db),
+		JWTSecret:         "supersecret",
+		Keyset:            auth.NewHMACKeyset("supersecret"),
+		Passwords:         passwords,
+		Metrics:           api.NewAppMetrics(db.sqlDB()),
+		LoginFailuresByIP: ratelimit.NewBackoff(20, time.Minute, time.Hour),
+		MFAAttempts:       ratelimit.New(20, time.Minute),
+	}
+
+	// firstStep logs in with the password and returns the MFA token.
+	firstStep := func() string {
+		t.Helper()
+		rec := login(cfg, logins.user.Email, lockoutPassword)
+		var res loginResponse
+		json.Unmarshal(rec.Body.Bytes(), &res)
+		if rec.Code != 200 || !res.MFARequired || res.MFAToken == "" || res.Token != "" || res.RefreshToken != "" {
+			t.Fatalf("password login = %d %s, want an MFA challenge and no tokens", rec.Code, rec.Body)
+		}
+		return res.MFAToken
+	}
+	// secondStep sends the MFA token with a TOTP or recovery code.
+	secondStep := func(mfaToken, field, code string) (int, loginResponse) {
+		body := `{"mfa_token": "` + mfaToken + `", "` + field + `": "` + code + `"}`
+		req := httptest.NewRequest("POST", "/api/login/mfa", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		cfg.LoginMFAHandler(rec, req)
+		var res loginResponse
+		json.Unmarshal(rec.Body.Bytes(), &res)
+		return rec.Code, res
+	}
+
+	code := totp.code()
+	if status, res := secondStep(firstStep(), "code", code); status != 200 || res.Token == "" || res.RefreshToken == "" {
+		t.Fatalf("second step with a valid code = %d %+v, want tokens", status, res)
+	}
+	if status, _ := secondStep(firstStep(), "code", code); status != 401 {
+		t.Errorf("replayed code = %d, want 401", status)
+	}
+	if status, _ := secondStep("not-a-token", "code", totp.code()); status != 401 {
+		t.Errorf("second step without an MFA token = %d, want 401", status)
+	}
+
+	// recovery codes work once each, typed with or without the dash
+	codes := []string{"abcd-efgh", "ijkl-mnop"}
+	for _, c := range codes {
+		totp.recovery[auth.HashToken(auth.NormalizeRecoveryCode(c))] = false
+	}
+	// stored before the dash was ignored
+	totp.recovery[auth.HashToken("qrst-uvwx")] = false
+
+	for _, tc := range []struct {
+		typed string
+		want  int
+	}{
+		{"ABCDEFGH", 200},
+		{"abcd-efgh", 401},
+		{"ijkl-mnop", 200},
+		{"ijklmnop", 401},
+		{"qrstuvwx", 200},
+	} {
+		if status, _ := secondStep(firstStep(), "recovery_code", tc.typed); status != tc.want {
+			t.Errorf("recovery code %q = %d, want %d", tc.typed, status, tc.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Johnermac/http-server/internal/api"
+	"github.com/Johnermac/http-server/internal/auth"
+	"github.com/Johnermac/http-server/internal/database"
+	"github.com/Johnermac/http-server/internal/helpers"
+	"github.com/Johnermac/http-server/internal/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// failingDB fails every statement, the way a lost connection would.
+type failingDB struct {
+	database.DBTX
+}
+
+func (failingDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	cfg := &api.APIConfig{
+		DB:     database.New(tracing.WrapDB(failingDB{})),
+		Keyset: auth.NewHMACKeyset("supersecret"),
+		Logger: slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		// behind a proxy that sets traceparent
+		TrustTraceparent: true,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := cfg.AuthenticateRequest(r); err != nil {
+			helpers.RespondWithError(w, 401, err.Error())
+			return
+		}
+		if err := cfg.DB.TouchPersonalAccessToken(r.Context(), uuid.New()); err != nil {
+			helpers.RespondWithError(w, 500, "Database error", err)
+			return
+		}
+	})
+	mux.HandleFunc("POST /oauth/token", cfg.OAuthTokenHandler)
+	handler := cfg.MiddlewareTracing(cfg.MiddlewareLogging(mux))
+
+	token, err := cfg.Keyset.MakeSessionJWT(uuid.New(), uuid.Nil)
+	if err != nil {
+		t.Fatalf("MakeSessionJWT: %v", err)
+	}
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "/things/42", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var body map[string]string
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if body["trace_id"] != traceID {
+		t.Errorf("error response %v should carry the caller's trace ID", body)
+	}
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = s
+	}
+	server, ok := spans["GET /things/{id}"]
+	if !ok {
+		t.Fatalf("no server span named after the route in %v", spans)
+	}
+	if server.SpanContext.TraceID().String() != traceID || server.Status.Code != codes.Error {
+		t.Errorf("server span: trace %s, status %v", server.SpanContext.TraceID(), server.Status)
+	}
+	for _, name := range []string{"jwt.verify", "TouchPersonalAccessToken"} {
+		s, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if s.Parent.SpanID() != server.SpanContext.SpanID() {
+			t.Errorf("%s should be a child of the server span", name)
+		}
+	}
+	if query := spans["TouchPersonalAccessToken"]; query.Status.Code != codes.Error || len(query.Events) == 0 {
+		t.Errorf("failed query should be marked and record its error: %v %v", query.Status, query.Events)
+	}
+
+	// OAuth errors have their own format, and carry it too
+	req = httptest.NewRequest("POST", "/oauth/token", strings.NewReader("grant_type=%zz"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	body = nil
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if body["error"] != "invalid_request" || body["trace_id"] != traceID {
+		t.Errorf("OAuth error response %v should carry the caller's trace ID", body)
+	}
+
+	// queries outside a traced request aren't traced at all
+	exporter.Reset()
+	cfg.DB.TouchPersonalAccessToken(context.Background(), uuid.New())
+	if n := len(exporter.GetSpans()); n != 0 {
+		t.Errorf("untraced query made %d spans", n)
+	}
+}
+
+func TestTracingUntrustedTraceparent(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	cfg := &api.APIConfig{Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		helpers.RespondWithError(w, 404, "Not found")
+	})
+	handler := cfg.MiddlewareTracing(cfg.MiddlewareLogging(mux))
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "/things/42", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want the server span", len(spans))
+	}
+	server := spans[0]
+	if server.SpanContext.TraceID().String() == traceID || server.Parent.IsValid() {
+		t.Errorf("server span continued the client's trace %s", traceID)
+	}
+	if len(server.Links) != 1 || server.Links[0].SpanContext.TraceID().String() != traceID {
+		t.Errorf("server span links %v, want the client's trace", server.Links)
+	}
+
+	var body map[string]string
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if body["trace_id"] != server.SpanContext.TraceID().String() {
+		t.Errorf("error response %v should carry our trace ID", body)
+	}
+}
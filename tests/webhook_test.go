@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Johnermac/http-server/internal/webhook"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	now := time.Unix(1760000000, 0)
+	current := []byte("whsec_current")
+	previous := []byte("whsec_previous")
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	v := &webhook.Verifier{
+		Secrets:   [][]byte{current, previous},
+		Tolerance: 5 * time.Minute,
+		Now:       func() time.Time { return now },
+	}
+
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		body      []byte
+		want      error
+	}{
+		{"current secret", ts, webhook.Sign(current, now, body), body, nil},
+		{"previous secret", ts, webhook.Sign(previous, now, body), body, nil},
+		{"one of several signatures", ts, "v1=00ff," + webhook.Sign(current, now, body), body, nil},
+		{"within tolerance", strconv.FormatInt(now.Unix()-299, 10), webhook.Sign(current, now.Add(-299*time.Second), body), body, nil},
+		{"unknown secret", ts, webhook.Sign([]byte("whsec_other"), now, body), body, webhook.ErrInvalidSignature},
+		{"tampered body", ts, webhook.Sign(current, now, body), []byte(`{"event":"user.upgraded"}`), webhook.ErrInvalidSignature},
+		{"timestamp changed", strconv.FormatInt(now.Unix()+1, 10), webhook.Sign(current, now, body), body, webhook.ErrInvalidSignature},
+		{"unknown version", ts, "v0=" + webhook.Sign(current, now, body)[3:], body, webhook.ErrInvalidSignature},
+		{"too old", strconv.FormatInt(now.Unix()-301, 10), webhook.Sign(current, now.Add(-301*time.Second), body), body, webhook.ErrExpired},
+		{"too far in the future", strconv.FormatInt(now.Unix()+301, 10), webhook.Sign(current, now.Add(301*time.Second), body), body, webhook.ErrExpired},
+		{"bad timestamp", "yesterday", webhook.Sign(current, now, body), body, webhook.ErrInvalidTimestamp},
+		{"missing signature", ts, "", body, webhook.ErrMissingSignature},
+		{"missing timestamp", "", webhook.Sign(current, now, body), body, webhook.ErrMissingSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.Verify(tt.timestamp, tt.signature, tt.body)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookVerifierWithoutSecrets(t *testing.T) {
+	v := &webhook.Verifier{}
+	now := time.Now()
+	err := v.Verify(strconv.FormatInt(now.Unix(), 10), webhook.Sign(nil, now, nil), nil)
+	if !errors.Is(err, webhook.ErrNoSecrets) {
+		t.Errorf("Verify() error = %v, want %v", err, webhook.ErrNoSecrets)
+	}
+}